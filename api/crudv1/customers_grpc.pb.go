@@ -0,0 +1,169 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+
+package crudv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion7
+
+// CustomersClient is the client API for Customers service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type CustomersClient interface {
+	Register(ctx context.Context, in *RegisterCustomerRequest, opts ...grpc.CallOption) (*Customer, error)
+	Token(ctx context.Context, in *TokenRequest, opts ...grpc.CallOption) (*TokenResponse, error)
+	Products(ctx context.Context, in *ProductsRequest, opts ...grpc.CallOption) (*CatalogProducts, error)
+}
+
+type customersClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewCustomersClient(cc grpc.ClientConnInterface) CustomersClient {
+	return &customersClient{cc}
+}
+
+func (c *customersClient) Register(ctx context.Context, in *RegisterCustomerRequest, opts ...grpc.CallOption) (*Customer, error) {
+	out := new(Customer)
+	err := c.cc.Invoke(ctx, "/crud.v1.Customers/Register", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *customersClient) Token(ctx context.Context, in *TokenRequest, opts ...grpc.CallOption) (*TokenResponse, error) {
+	out := new(TokenResponse)
+	err := c.cc.Invoke(ctx, "/crud.v1.Customers/Token", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *customersClient) Products(ctx context.Context, in *ProductsRequest, opts ...grpc.CallOption) (*CatalogProducts, error) {
+	out := new(CatalogProducts)
+	err := c.cc.Invoke(ctx, "/crud.v1.Customers/Products", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// CustomersServer is the server API for Customers service.
+// All implementations must embed UnimplementedCustomersServer
+// for forward compatibility
+type CustomersServer interface {
+	Register(context.Context, *RegisterCustomerRequest) (*Customer, error)
+	Token(context.Context, *TokenRequest) (*TokenResponse, error)
+	Products(context.Context, *ProductsRequest) (*CatalogProducts, error)
+	mustEmbedUnimplementedCustomersServer()
+}
+
+// UnimplementedCustomersServer must be embedded to have forward compatible implementations.
+type UnimplementedCustomersServer struct {
+}
+
+func (UnimplementedCustomersServer) Register(context.Context, *RegisterCustomerRequest) (*Customer, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Register not implemented")
+}
+func (UnimplementedCustomersServer) Token(context.Context, *TokenRequest) (*TokenResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Token not implemented")
+}
+func (UnimplementedCustomersServer) Products(context.Context, *ProductsRequest) (*CatalogProducts, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Products not implemented")
+}
+func (UnimplementedCustomersServer) mustEmbedUnimplementedCustomersServer() {}
+
+// UnsafeCustomersServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to CustomersServer will
+// result in compilation errors.
+type UnsafeCustomersServer interface {
+	mustEmbedUnimplementedCustomersServer()
+}
+
+func RegisterCustomersServer(s grpc.ServiceRegistrar, srv CustomersServer) {
+	s.RegisterService(&_Customers_serviceDesc, srv)
+}
+
+func _Customers_Register_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RegisterCustomerRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CustomersServer).Register(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/crud.v1.Customers/Register",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CustomersServer).Register(ctx, req.(*RegisterCustomerRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Customers_Token_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TokenRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CustomersServer).Token(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/crud.v1.Customers/Token",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CustomersServer).Token(ctx, req.(*TokenRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Customers_Products_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ProductsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CustomersServer).Products(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/crud.v1.Customers/Products",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CustomersServer).Products(ctx, req.(*ProductsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _Customers_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "crud.v1.Customers",
+	HandlerType: (*CustomersServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Register",
+			Handler:    _Customers_Register_Handler,
+		},
+		{
+			MethodName: "Token",
+			Handler:    _Customers_Token_Handler,
+		},
+		{
+			MethodName: "Products",
+			Handler:    _Customers_Products_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "customers.proto",
+}
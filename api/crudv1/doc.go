@@ -0,0 +1,7 @@
+// Package crudv1 is the code generated from the crud.v1 services in
+// api/proto. Regenerate it after changing the .proto files; it is built with
+// protoc-gen-go v1.25.0 and protoc-gen-go-grpc v1.0.1, the versions that go
+// with the protobuf and grpc modules in go.mod.
+package crudv1
+
+//go:generate protoc -I ../proto --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative common.proto customers.proto managers.proto
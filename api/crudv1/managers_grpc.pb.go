@@ -0,0 +1,423 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+
+package crudv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion7
+
+// ManagersClient is the client API for Managers service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ManagersClient interface {
+	Token(ctx context.Context, in *TokenRequest, opts ...grpc.CallOption) (*TokenResponse, error)
+	// Register is for admins and returns the token of the new manager.
+	Register(ctx context.Context, in *RegisterManagerRequest, opts ...grpc.CallOption) (*TokenResponse, error)
+	Products(ctx context.Context, in *ProductsRequest, opts ...grpc.CallOption) (*ProductList, error)
+	SaveProduct(ctx context.Context, in *Product, opts ...grpc.CallOption) (*Product, error)
+	RemoveProduct(ctx context.Context, in *IDRequest, opts ...grpc.CallOption) (*Empty, error)
+	MakeSale(ctx context.Context, in *Sale, opts ...grpc.CallOption) (*Sale, error)
+	Sales(ctx context.Context, in *ProductsRequest, opts ...grpc.CallOption) (*SalesTotals, error)
+	Customers(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*CustomerList, error)
+	SaveCustomer(ctx context.Context, in *Customer, opts ...grpc.CallOption) (*Customer, error)
+	RemoveCustomer(ctx context.Context, in *IDRequest, opts ...grpc.CallOption) (*Empty, error)
+}
+
+type managersClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewManagersClient(cc grpc.ClientConnInterface) ManagersClient {
+	return &managersClient{cc}
+}
+
+func (c *managersClient) Token(ctx context.Context, in *TokenRequest, opts ...grpc.CallOption) (*TokenResponse, error) {
+	out := new(TokenResponse)
+	err := c.cc.Invoke(ctx, "/crud.v1.Managers/Token", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *managersClient) Register(ctx context.Context, in *RegisterManagerRequest, opts ...grpc.CallOption) (*TokenResponse, error) {
+	out := new(TokenResponse)
+	err := c.cc.Invoke(ctx, "/crud.v1.Managers/Register", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *managersClient) Products(ctx context.Context, in *ProductsRequest, opts ...grpc.CallOption) (*ProductList, error) {
+	out := new(ProductList)
+	err := c.cc.Invoke(ctx, "/crud.v1.Managers/Products", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *managersClient) SaveProduct(ctx context.Context, in *Product, opts ...grpc.CallOption) (*Product, error) {
+	out := new(Product)
+	err := c.cc.Invoke(ctx, "/crud.v1.Managers/SaveProduct", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *managersClient) RemoveProduct(ctx context.Context, in *IDRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, "/crud.v1.Managers/RemoveProduct", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *managersClient) MakeSale(ctx context.Context, in *Sale, opts ...grpc.CallOption) (*Sale, error) {
+	out := new(Sale)
+	err := c.cc.Invoke(ctx, "/crud.v1.Managers/MakeSale", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *managersClient) Sales(ctx context.Context, in *ProductsRequest, opts ...grpc.CallOption) (*SalesTotals, error) {
+	out := new(SalesTotals)
+	err := c.cc.Invoke(ctx, "/crud.v1.Managers/Sales", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *managersClient) Customers(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*CustomerList, error) {
+	out := new(CustomerList)
+	err := c.cc.Invoke(ctx, "/crud.v1.Managers/Customers", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *managersClient) SaveCustomer(ctx context.Context, in *Customer, opts ...grpc.CallOption) (*Customer, error) {
+	out := new(Customer)
+	err := c.cc.Invoke(ctx, "/crud.v1.Managers/SaveCustomer", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *managersClient) RemoveCustomer(ctx context.Context, in *IDRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, "/crud.v1.Managers/RemoveCustomer", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ManagersServer is the server API for Managers service.
+// All implementations must embed UnimplementedManagersServer
+// for forward compatibility
+type ManagersServer interface {
+	Token(context.Context, *TokenRequest) (*TokenResponse, error)
+	// Register is for admins and returns the token of the new manager.
+	Register(context.Context, *RegisterManagerRequest) (*TokenResponse, error)
+	Products(context.Context, *ProductsRequest) (*ProductList, error)
+	SaveProduct(context.Context, *Product) (*Product, error)
+	RemoveProduct(context.Context, *IDRequest) (*Empty, error)
+	MakeSale(context.Context, *Sale) (*Sale, error)
+	Sales(context.Context, *ProductsRequest) (*SalesTotals, error)
+	Customers(context.Context, *Empty) (*CustomerList, error)
+	SaveCustomer(context.Context, *Customer) (*Customer, error)
+	RemoveCustomer(context.Context, *IDRequest) (*Empty, error)
+	mustEmbedUnimplementedManagersServer()
+}
+
+// UnimplementedManagersServer must be embedded to have forward compatible implementations.
+type UnimplementedManagersServer struct {
+}
+
+func (UnimplementedManagersServer) Token(context.Context, *TokenRequest) (*TokenResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Token not implemented")
+}
+func (UnimplementedManagersServer) Register(context.Context, *RegisterManagerRequest) (*TokenResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Register not implemented")
+}
+func (UnimplementedManagersServer) Products(context.Context, *ProductsRequest) (*ProductList, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Products not implemented")
+}
+func (UnimplementedManagersServer) SaveProduct(context.Context, *Product) (*Product, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SaveProduct not implemented")
+}
+func (UnimplementedManagersServer) RemoveProduct(context.Context, *IDRequest) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RemoveProduct not implemented")
+}
+func (UnimplementedManagersServer) MakeSale(context.Context, *Sale) (*Sale, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method MakeSale not implemented")
+}
+func (UnimplementedManagersServer) Sales(context.Context, *ProductsRequest) (*SalesTotals, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Sales not implemented")
+}
+func (UnimplementedManagersServer) Customers(context.Context, *Empty) (*CustomerList, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Customers not implemented")
+}
+func (UnimplementedManagersServer) SaveCustomer(context.Context, *Customer) (*Customer, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SaveCustomer not implemented")
+}
+func (UnimplementedManagersServer) RemoveCustomer(context.Context, *IDRequest) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RemoveCustomer not implemented")
+}
+func (UnimplementedManagersServer) mustEmbedUnimplementedManagersServer() {}
+
+// UnsafeManagersServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ManagersServer will
+// result in compilation errors.
+type UnsafeManagersServer interface {
+	mustEmbedUnimplementedManagersServer()
+}
+
+func RegisterManagersServer(s grpc.ServiceRegistrar, srv ManagersServer) {
+	s.RegisterService(&_Managers_serviceDesc, srv)
+}
+
+func _Managers_Token_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TokenRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ManagersServer).Token(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/crud.v1.Managers/Token",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ManagersServer).Token(ctx, req.(*TokenRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Managers_Register_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RegisterManagerRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ManagersServer).Register(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/crud.v1.Managers/Register",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ManagersServer).Register(ctx, req.(*RegisterManagerRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Managers_Products_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ProductsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ManagersServer).Products(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/crud.v1.Managers/Products",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ManagersServer).Products(ctx, req.(*ProductsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Managers_SaveProduct_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Product)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ManagersServer).SaveProduct(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/crud.v1.Managers/SaveProduct",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ManagersServer).SaveProduct(ctx, req.(*Product))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Managers_RemoveProduct_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(IDRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ManagersServer).RemoveProduct(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/crud.v1.Managers/RemoveProduct",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ManagersServer).RemoveProduct(ctx, req.(*IDRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Managers_MakeSale_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Sale)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ManagersServer).MakeSale(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/crud.v1.Managers/MakeSale",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ManagersServer).MakeSale(ctx, req.(*Sale))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Managers_Sales_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ProductsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ManagersServer).Sales(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/crud.v1.Managers/Sales",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ManagersServer).Sales(ctx, req.(*ProductsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Managers_Customers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ManagersServer).Customers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/crud.v1.Managers/Customers",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ManagersServer).Customers(ctx, req.(*Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Managers_SaveCustomer_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Customer)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ManagersServer).SaveCustomer(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/crud.v1.Managers/SaveCustomer",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ManagersServer).SaveCustomer(ctx, req.(*Customer))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Managers_RemoveCustomer_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(IDRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ManagersServer).RemoveCustomer(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/crud.v1.Managers/RemoveCustomer",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ManagersServer).RemoveCustomer(ctx, req.(*IDRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _Managers_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "crud.v1.Managers",
+	HandlerType: (*ManagersServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Token",
+			Handler:    _Managers_Token_Handler,
+		},
+		{
+			MethodName: "Register",
+			Handler:    _Managers_Register_Handler,
+		},
+		{
+			MethodName: "Products",
+			Handler:    _Managers_Products_Handler,
+		},
+		{
+			MethodName: "SaveProduct",
+			Handler:    _Managers_SaveProduct_Handler,
+		},
+		{
+			MethodName: "RemoveProduct",
+			Handler:    _Managers_RemoveProduct_Handler,
+		},
+		{
+			MethodName: "MakeSale",
+			Handler:    _Managers_MakeSale_Handler,
+		},
+		{
+			MethodName: "Sales",
+			Handler:    _Managers_Sales_Handler,
+		},
+		{
+			MethodName: "Customers",
+			Handler:    _Managers_Customers_Handler,
+		},
+		{
+			MethodName: "SaveCustomer",
+			Handler:    _Managers_SaveCustomer_Handler,
+		},
+		{
+			MethodName: "RemoveCustomer",
+			Handler:    _Managers_RemoveCustomer_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "managers.proto",
+}
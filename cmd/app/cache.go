@@ -0,0 +1,27 @@
+package app
+
+import (
+	"net/http"
+
+	"github.com/ehsontjk/crud/cmd/app/middleware"
+)
+
+// handleManagerGetCacheStats shows admins how well the product cache of this
+// instance does.
+func (s *Server) handleManagerGetCacheStats(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if id == 0 {
+		s.errorWriter(w, r, http.StatusForbidden, err)
+		return
+	}
+	if !s.managerSvc.IsAdmin(r.Context(), id) {
+		s.errorWriter(w, r, http.StatusForbidden, err)
+		return
+	}
+
+	s.respondJSON(w, r, s.productCache.Stats())
+}
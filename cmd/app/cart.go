@@ -0,0 +1,168 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"github.com/ehsontjk/crud/cmd/app/middleware"
+	"github.com/ehsontjk/crud/pkg/customers"
+	"github.com/ehsontjk/crud/pkg/inventory"
+	"github.com/ehsontjk/crud/pkg/loyalty"
+	"github.com/ehsontjk/crud/pkg/managers"
+	"github.com/ehsontjk/crud/pkg/promotions"
+)
+
+const checkoutIdempotencyScope = "customers.checkout"
+
+func (s *Server) respondCart(w http.ResponseWriter, r *http.Request, cart *customers.Cart, err error) {
+	switch err {
+	case nil:
+		s.respondJSON(w, r, cart)
+	case customers.ErrNotFound:
+		s.errorWriter(w, r, http.StatusNotFound, err)
+	case customers.ErrInvalidQty:
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+	default:
+		s.errorWriter(w, r, http.StatusInternalServerError, err)
+	}
+}
+
+func cartProductID(r *http.Request) (int64, error) {
+	idParam, ok := mux.Vars(r)["id"]
+	if !ok {
+		return 0, errors.New("Missing id")
+	}
+	return strconv.ParseInt(idParam, 10, 64)
+}
+
+func (s *Server) handleCustomerGetCart(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if id == 0 {
+		s.errorWriter(w, r, http.StatusForbidden, err)
+		return
+	}
+
+	cart, err := s.customerSvc.Cart(r.Context(), id)
+	s.respondCart(w, r, cart, err)
+}
+
+func (s *Server) handleCustomerAddToCart(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if id == 0 {
+		s.errorWriter(w, r, http.StatusForbidden, err)
+		return
+	}
+
+	var item struct {
+		ProductID int64 `json:"product_id"`
+		Qty       int   `json:"qty"`
+	}
+	if err = json.NewDecoder(r.Body).Decode(&item); err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	cart, err := s.customerSvc.AddToCart(r.Context(), id, item.ProductID, item.Qty)
+	s.respondCart(w, r, cart, err)
+}
+
+func (s *Server) handleCustomerUpdateCartItem(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if id == 0 {
+		s.errorWriter(w, r, http.StatusForbidden, err)
+		return
+	}
+
+	productID, err := cartProductID(r)
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	var item struct {
+		Qty int `json:"qty"`
+	}
+	if err = json.NewDecoder(r.Body).Decode(&item); err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	cart, err := s.customerSvc.SetCartQty(r.Context(), id, productID, item.Qty)
+	s.respondCart(w, r, cart, err)
+}
+
+func (s *Server) handleCustomerRemoveCartItem(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if id == 0 {
+		s.errorWriter(w, r, http.StatusForbidden, err)
+		return
+	}
+
+	productID, err := cartProductID(r)
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	cart, err := s.customerSvc.RemoveFromCart(r.Context(), id, productID)
+	s.respondCart(w, r, cart, err)
+}
+
+func (s *Server) handleCustomerCheckout(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if id == 0 {
+		s.errorWriter(w, r, http.StatusForbidden, err)
+		return
+	}
+
+	var item struct {
+		PromoCodes   []string `json:"promo_codes"`
+		RedeemPoints int      `json:"redeem_points"`
+	}
+	if r.ContentLength != 0 {
+		if err = json.NewDecoder(r.Body).Decode(&item); err != nil {
+			s.errorWriter(w, r, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	sale, err := s.customerSvc.Checkout(r.Context(), id, item.PromoCodes, item.RedeemPoints)
+	switch err {
+	case nil:
+	case customers.ErrInternal, managers.ErrInternal, inventory.ErrInternal, promotions.ErrInternal, loyalty.ErrInternal:
+		s.errorWriter(w, r, http.StatusInternalServerError, err)
+		return
+	case customers.ErrCartChanged:
+		s.errorWriter(w, r, http.StatusConflict, err)
+		return
+	default:
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	s.respondJSON(w, r, sale)
+}
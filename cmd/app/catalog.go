@@ -0,0 +1,157 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/ehsontjk/crud/cmd/app/middleware"
+	"github.com/ehsontjk/crud/pkg/catalog"
+	"github.com/ehsontjk/crud/pkg/export"
+	"github.com/ehsontjk/crud/pkg/inventory"
+)
+
+const maxCatalogFileSize = 64 << 20
+
+var errCatalogFileTooLarge = errors.New("catalog file is too large")
+
+// catalogFile returns the uploaded file either from the "file" field of a
+// multipart form or from the raw request body. The caller must close it.
+func catalogFile(w http.ResponseWriter, r *http.Request) (file interface {
+	io.ReaderAt
+	io.Closer
+}, size int64, name string, err error) {
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxCatalogFileSize)
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		f, header, err := r.FormFile("file")
+		if err != nil {
+			return nil, 0, "", err
+		}
+		return f, header.Size, header.Filename, nil
+	}
+
+	// the body is spooled to disk because XLSX needs random access
+	tmp, err := ioutil.TempFile("", "catalog-*")
+	if err != nil {
+		return nil, 0, "", err
+	}
+	os.Remove(tmp.Name())
+	if size, err = io.Copy(tmp, r.Body); err != nil {
+		tmp.Close()
+		return nil, 0, "", errCatalogFileTooLarge
+	}
+	return tmp, size, "", nil
+}
+
+func (s *Server) handleManagerImportProducts(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if id == 0 {
+		s.errorWriter(w, r, http.StatusForbidden, err)
+		return
+	}
+
+	dryRun := false
+	if param := r.URL.Query().Get("dry_run"); param != "" {
+		if dryRun, err = strconv.ParseBool(param); err != nil {
+			s.errorWriter(w, r, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	file, size, name, err := catalogFile(w, r)
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+	defer file.Close()
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = catalog.FormatCSV
+		if strings.EqualFold(path.Ext(name), ".xlsx") {
+			format = catalog.FormatXLSX
+		}
+	}
+
+	rows, err := catalog.ReadRows(format, file, size)
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	report, err := s.catalogSvc.Import(r.Context(), id, rows, dryRun)
+	switch err {
+	case nil:
+	case catalog.ErrEmptyFile:
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	case catalog.ErrSKUConflict:
+		s.errorWriter(w, r, http.StatusConflict, err)
+		return
+	case inventory.ErrInvalidMovement, inventory.ErrReasonRequired:
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	default:
+		s.errorWriter(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	if len(report.Errors) > 0 && !dryRun {
+		data, err := json.Marshal(report)
+		if err != nil {
+			s.errorWriter(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		s.log.Warn(r.Context(), "catalog import rejected", "errors", len(report.Errors))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		if _, err = w.Write(data); err != nil {
+			s.log.Error(r.Context(), "write response", "err", err)
+		}
+		return
+	}
+
+	s.respondJSON(w, r, report)
+}
+
+func (s *Server) handleManagerExportProducts(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if id == 0 {
+		s.errorWriter(w, r, http.StatusForbidden, err)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	switch format {
+	case "":
+		format = catalog.FormatCSV
+	case catalog.FormatCSV, catalog.FormatXLSX:
+	default:
+		s.errorWriter(w, r, http.StatusBadRequest, catalog.ErrUnsupportedFormat)
+		return
+	}
+	w.Header().Set("Content-Type", export.ContentType(format))
+	w.Header().Set("Content-Disposition", "attachment; filename=products."+format)
+
+	// headers are already sent once rows start streaming, so a failure
+	// midway can only be logged
+	if err = s.catalogSvc.Export(r.Context(), w, format); err != nil {
+		s.log.Error(r.Context(), "export catalog", "err", err)
+	}
+}
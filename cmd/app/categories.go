@@ -0,0 +1,135 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"github.com/ehsontjk/crud/cmd/app/middleware"
+	"github.com/ehsontjk/crud/pkg/categories"
+)
+
+// categoryFilter resolves the optional ?category= parameter, an id or a slug,
+// to the ids of that category and its descendants. It returns nil when the
+// listing is not filtered.
+func (s *Server) categoryFilter(r *http.Request) ([]int64, error) {
+	ref := r.URL.Query().Get("category")
+	if ref == "" {
+		return nil, nil
+	}
+	return s.categorySvc.Subtree(r.Context(), ref)
+}
+
+func (s *Server) categoryFilterError(w http.ResponseWriter, r *http.Request, err error) {
+	if err == categories.ErrNotFound {
+		s.errorWriter(w, r, http.StatusNotFound, err)
+		return
+	}
+	s.errorWriter(w, r, http.StatusInternalServerError, err)
+}
+
+func (s *Server) handleCustomerGetCategories(w http.ResponseWriter, r *http.Request) {
+
+	items, err := s.categorySvc.Tree(r.Context(), true)
+	if err != nil {
+		s.errorWriter(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	s.respondJSON(w, r, items)
+}
+
+func (s *Server) handleManagerGetCategories(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if id == 0 {
+		s.errorWriter(w, r, http.StatusForbidden, err)
+		return
+	}
+
+	items, err := s.categorySvc.Tree(r.Context(), false)
+	if err != nil {
+		s.errorWriter(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	s.respondJSON(w, r, items)
+}
+
+func (s *Server) handleManagerSaveCategory(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if id == 0 {
+		s.errorWriter(w, r, http.StatusForbidden, err)
+		return
+	}
+
+	// new categories are visible unless the request says otherwise
+	item := &categories.Category{Active: true}
+	if err = json.NewDecoder(r.Body).Decode(&item); err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	item, err = s.categorySvc.Save(r.Context(), item)
+	switch err {
+	case nil:
+	case categories.ErrNotFound:
+		s.errorWriter(w, r, http.StatusNotFound, err)
+		return
+	case categories.ErrInvalidCategory, categories.ErrCycle:
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	case categories.ErrSlugDuplicated:
+		s.errorWriter(w, r, http.StatusConflict, err)
+		return
+	default:
+		s.errorWriter(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	s.respondJSON(w, r, item)
+}
+
+func (s *Server) handleManagerRemoveCategoryByID(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if id == 0 {
+		s.errorWriter(w, r, http.StatusForbidden, err)
+		return
+	}
+
+	idParam, ok := mux.Vars(r)["id"]
+	if !ok {
+		s.errorWriter(w, r, http.StatusBadRequest, errors.New("Missing id"))
+		return
+	}
+	categoryID, err := strconv.ParseInt(idParam, 10, 64)
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	err = s.categorySvc.Remove(r.Context(), categoryID)
+	switch err {
+	case nil:
+	case categories.ErrNotFound:
+		s.errorWriter(w, r, http.StatusNotFound, err)
+	case categories.ErrInUse:
+		s.errorWriter(w, r, http.StatusConflict, err)
+	default:
+		s.errorWriter(w, r, http.StatusInternalServerError, err)
+	}
+}
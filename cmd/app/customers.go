@@ -11,11 +11,15 @@ import (
 func (s *Server) handleCustomerRegistration(w http.ResponseWriter, r *http.Request) {
 	
 	
-	var item *customers.Customer
+	var item *struct {
+		Name     string `json:"name"`
+		Phone    string `json:"phone"`
+		Password string `json:"password"`
+	}
 
 	if err := json.NewDecoder(r.Body).Decode(&item); err != nil {
 		
-		errorWriter(w, http.StatusBadRequest, err)
+		s.errorWriter(w, r, http.StatusBadRequest, err)
 		return
 	}
 
@@ -23,23 +27,21 @@ func (s *Server) handleCustomerRegistration(w http.ResponseWriter, r *http.Reque
 	hashed, err := bcrypt.GenerateFromPassword([]byte(item.Password), bcrypt.DefaultCost)
 	if err != nil {
 	
-		errorWriter(w, http.StatusInternalServerError, err)
+		s.errorWriter(w, r, http.StatusInternalServerError, err)
 		return
 	}
 
-	item.Password = string(hashed)
-
 	
-	customer, err := s.customerSvc.Save(r.Context(), item)
+	customer, err := s.customerSvc.Save(r.Context(), &customers.Customer{Name: item.Name, Phone: item.Phone, Password: string(hashed)})
 
 	
 	if err != nil {
 		
-		errorWriter(w, http.StatusInternalServerError, err)
+		s.errorWriter(w, r, http.StatusInternalServerError, err)
 		return
 	}
 	
-	respondJSON(w, customer)
+	s.respondJSON(w, r, newCustomerResource(customer.ID, customer.Name, customer.Phone, customer.Active, customer.Created))
 }
 
 
@@ -56,7 +58,7 @@ func (s *Server) handleCustomerGetToken(w http.ResponseWriter, r *http.Request)
 	//извелекаем данные из запраса
 	if err := json.NewDecoder(r.Body).Decode(&item); err != nil {
 		//вызываем фукцию для ответа с ошибкой
-		errorWriter(w, http.StatusBadRequest, err)
+		s.errorWriter(w, r, http.StatusBadRequest, err)
 		return
 	}
 	//взываем из сервиса  securitySvc метод AuthenticateCustomer
@@ -64,12 +66,12 @@ func (s *Server) handleCustomerGetToken(w http.ResponseWriter, r *http.Request)
 
 	if err != nil {
 		//вызываем фукцию для ответа с ошибкой
-		errorWriter(w, http.StatusBadRequest, err)
+		s.errorWriter(w, r, http.StatusBadRequest, err)
 		return
 	}
 
 	//вызываем функцию для ответа в формате JSON
-	respondJSON(w, map[string]interface{}{"status": "ok", "token": token})
+	s.respondJSON(w, r, &customerTokenResponse{Status: "ok", Token: token})
 
 }
 
@@ -77,13 +79,19 @@ func (s *Server) handleCustomerGetToken(w http.ResponseWriter, r *http.Request)
 
 func (s *Server) handleCustomerGetProducts(w http.ResponseWriter, r *http.Request) {
 
-	items, err := s.customerSvc.Products(r.Context())
+	categoryIDs, err := s.categoryFilter(r)
+	if err != nil {
+		s.categoryFilterError(w, r, err)
+		return
+	}
+
+	items, err := s.customerSvc.Products(r.Context(), categoryIDs)
 	if err != nil {
 		//вызываем фукцию для ответа с ошибкой
-		errorWriter(w, http.StatusBadRequest, err)
+		s.errorWriter(w, r, http.StatusBadRequest, err)
 		return
 	}
 
-	respondJSON(w, items)
+	s.respondJSON(w, r, items)
 
 }
\ No newline at end of file
@@ -0,0 +1,86 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/ehsontjk/crud/cmd/app/middleware"
+	"github.com/ehsontjk/crud/pkg/export"
+)
+
+const dateLayout = "2006-01-02"
+
+var errInvalidDate = errors.New("dates must be YYYY-MM-DD or RFC 3339")
+
+// exportRange reads the from and to query parameters. Plain dates cover the
+// whole day, so from=2020-01-01&to=2020-01-31 is the month of January.
+func exportRange(r *http.Request) (from, to time.Time, err error) {
+
+	parse := func(name string, endOfDay bool) (time.Time, error) {
+		value := r.URL.Query().Get(name)
+		if t, err := time.Parse(dateLayout, value); err == nil {
+			if endOfDay {
+				t = t.AddDate(0, 0, 1)
+			}
+			return t, nil
+		}
+		if t, err := time.Parse(time.RFC3339, value); err == nil {
+			return t.UTC(), nil
+		}
+		return time.Time{}, errInvalidDate
+	}
+
+	if from, err = parse("from", false); err != nil {
+		return
+	}
+	to, err = parse("to", true)
+	return
+}
+
+func (s *Server) handleManagerExportSales(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if id == 0 || !s.managerSvc.IsAdmin(r.Context(), id) {
+		s.errorWriter(w, r, http.StatusForbidden, err)
+		return
+	}
+
+	from, to, err := exportRange(r)
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if !from.Before(to) {
+		s.errorWriter(w, r, http.StatusBadRequest, export.ErrInvalidRange)
+		return
+	}
+
+	categoryIDs, err := s.categoryFilter(r)
+	if err != nil {
+		s.categoryFilterError(w, r, err)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	switch format {
+	case "":
+		format = export.FormatCSV
+	case export.FormatCSV, export.FormatXLSX, export.FormatNDJSON:
+	default:
+		s.errorWriter(w, r, http.StatusBadRequest, export.ErrUnsupportedFormat)
+		return
+	}
+
+	w.Header().Set("Content-Type", export.ContentType(format))
+	w.Header().Set("Content-Disposition", "attachment; filename=sales-"+from.Format(dateLayout)+"."+format)
+
+	// headers are already sent once rows start streaming, so a failure
+	// midway can only be logged
+	if err = s.exportSvc.Sales(r.Context(), w, format, from, to, categoryIDs); err != nil {
+		s.log.Error(r.Context(), "export sales", "err", err)
+	}
+}
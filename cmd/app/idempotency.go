@@ -0,0 +1,148 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/ehsontjk/crud/cmd/app/middleware"
+	"github.com/ehsontjk/crud/pkg/idempotency"
+)
+
+const (
+	IdempotencyKeyHeader      = "Idempotency-Key"
+	IdempotentReplayedHeader  = "Idempotent-Replayed"
+	maxIdempotencyKeyLength   = 255
+	maxIdempotentRequestBytes = 1 << 20
+	// finishIdempotencyTimeout bounds storing or releasing the key once the
+	// handler is done, which happens even if the client has gone
+	finishIdempotencyTimeout = 5 * time.Second
+)
+
+const salesIdempotencyScope = "managers.sales"
+
+var ErrIdempotencyKeyTooLong = errors.New("idempotency key too long")
+
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *responseRecorder) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseRecorder) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+// detached keeps the values of a request context, such as the request ID,
+// but not its cancellation.
+type detached struct {
+	context.Context
+}
+
+func (detached) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detached) Done() <-chan struct{} {
+	return nil
+}
+
+func (detached) Err() error {
+	return nil
+}
+
+// idempotent makes retries of the wrapped handler with the same
+// Idempotency-Key header replay the first successful response instead of
+// executing it again. Keys are scoped to the authenticated user.
+func (s *Server) idempotent(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyKeyHeader)
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			s.errorWriter(w, r, http.StatusBadRequest, ErrIdempotencyKeyTooLong)
+			return
+		}
+
+		id, err := middleware.Authentication(r.Context())
+		if err != nil {
+			s.errorWriter(w, r, http.StatusBadRequest, err)
+			return
+		}
+		if id == 0 {
+			s.errorWriter(w, r, http.StatusForbidden, err)
+			return
+		}
+
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxIdempotentRequestBytes))
+		if err != nil {
+			s.errorWriter(w, r, http.StatusBadRequest, err)
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		record, err := s.idempotencySvc.Begin(r.Context(), scope, id, key, idempotency.Hash(body))
+		switch err {
+		case nil:
+		case idempotency.ErrPayloadMismatch:
+			s.errorWriter(w, r, http.StatusUnprocessableEntity, err)
+			return
+		case idempotency.ErrInProgress:
+			s.errorWriter(w, r, http.StatusConflict, err)
+			return
+		default:
+			s.errorWriter(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		if record != nil {
+			if record.ContentType != "" {
+				w.Header().Set("Content-Type", record.ContentType)
+			}
+			w.Header().Set(IdempotentReplayedHeader, "true")
+			w.WriteHeader(record.Status)
+			if _, err = w.Write(record.Body); err != nil {
+				s.log.Error(r.Context(), "write response", "err", err)
+			}
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: w}
+		next(recorder, r)
+
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+
+		// a key left reserved would answer every retry with 409 until its
+		// lease runs out, so a client that went away must not stop this
+		ctx, cancel := context.WithTimeout(detached{r.Context()}, finishIdempotencyTimeout)
+		defer cancel()
+		if recorder.status >= 200 && recorder.status < 300 {
+			err = s.idempotencySvc.Complete(ctx, scope, id, key, &idempotency.Record{
+				Status:      recorder.status,
+				ContentType: w.Header().Get("Content-Type"),
+				Body:        recorder.body.Bytes(),
+			})
+		} else {
+			err = s.idempotencySvc.Release(ctx, scope, id, key)
+		}
+		if err != nil {
+			s.log.Error(ctx, "finish idempotent request", "scope", scope, "status", recorder.status, "err", err)
+		}
+	}
+}
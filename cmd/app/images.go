@@ -0,0 +1,139 @@
+package app
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/ehsontjk/crud/cmd/app/middleware"
+	"github.com/ehsontjk/crud/pkg/images"
+)
+
+func productImageParams(r *http.Request) (productID, imageID int64, err error) {
+	vars := mux.Vars(r)
+	idParam, ok := vars["id"]
+	if !ok {
+		return 0, 0, errors.New("Missing id")
+	}
+	if productID, err = strconv.ParseInt(idParam, 10, 64); err != nil {
+		return 0, 0, err
+	}
+	if imageParam, ok := vars["imageID"]; ok {
+		imageID, err = strconv.ParseInt(imageParam, 10, 64)
+	}
+	return productID, imageID, err
+}
+
+func (s *Server) handleManagerUploadProductImage(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if id == 0 {
+		s.errorWriter(w, r, http.StatusForbidden, err)
+		return
+	}
+
+	productID, _, err := productImageParams(r)
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	// leave room for the multipart envelope, the image itself is checked by the service
+	r.Body = http.MaxBytesReader(w, r.Body, images.MaxSize+1<<20)
+	var body io.Reader = r.Body
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, _, err := r.FormFile("image")
+		if err != nil {
+			s.errorWriter(w, r, http.StatusBadRequest, err)
+			return
+		}
+		defer file.Close()
+		body = file
+	}
+
+	item, err := s.imageSvc.Upload(r.Context(), productID, body)
+	switch err {
+	case nil:
+	case images.ErrNotFound:
+		s.errorWriter(w, r, http.StatusNotFound, err)
+		return
+	case images.ErrTooLarge:
+		s.errorWriter(w, r, http.StatusRequestEntityTooLarge, err)
+		return
+	case images.ErrUnsupportedType:
+		s.errorWriter(w, r, http.StatusUnsupportedMediaType, err)
+		return
+	case images.ErrInvalidImage:
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	default:
+		s.errorWriter(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	s.respondJSON(w, r, item)
+}
+
+func (s *Server) handleManagerGetProductImages(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if id == 0 {
+		s.errorWriter(w, r, http.StatusForbidden, err)
+		return
+	}
+
+	productID, _, err := productImageParams(r)
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	byProduct, err := s.imageSvc.ForProducts(r.Context(), []int64{productID})
+	if err != nil {
+		s.errorWriter(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	items := byProduct[productID]
+	if items == nil {
+		items = make([]*images.Image, 0)
+	}
+
+	s.respondJSON(w, r, items)
+}
+
+func (s *Server) handleManagerRemoveProductImage(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if id == 0 {
+		s.errorWriter(w, r, http.StatusForbidden, err)
+		return
+	}
+
+	productID, imageID, err := productImageParams(r)
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	err = s.imageSvc.Remove(r.Context(), productID, imageID)
+	switch err {
+	case nil:
+	case images.ErrNotFound:
+		s.errorWriter(w, r, http.StatusNotFound, err)
+	default:
+		s.errorWriter(w, r, http.StatusInternalServerError, err)
+	}
+}
@@ -0,0 +1,140 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"github.com/ehsontjk/crud/cmd/app/middleware"
+	"github.com/ehsontjk/crud/pkg/inventory"
+)
+
+func (s *Server) handleManagerPostMovement(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if id == 0 {
+		s.errorWriter(w, r, http.StatusForbidden, err)
+		return
+	}
+
+	idParam, ok := mux.Vars(r)["id"]
+	if !ok {
+		s.errorWriter(w, r, http.StatusBadRequest, errors.New("Missing id"))
+		return
+	}
+	productID, err := strconv.ParseInt(idParam, 10, 64)
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	var item struct {
+		Kind    inventory.Kind `json:"kind"`
+		Qty     int            `json:"qty"`
+		StoreID int64          `json:"store_id"`
+		Reason  string         `json:"reason"`
+	}
+	if err = json.NewDecoder(r.Body).Decode(&item); err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+	// without a store the movement goes to the manager's own store
+	if item.StoreID == 0 {
+		if item.StoreID, err = s.managerSvc.StoreID(r.Context(), id); err != nil {
+			s.errorWriter(w, r, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	// write-offs are posted as a positive quantity to take away
+	qty := item.Qty
+	if item.Kind == inventory.WriteOff {
+		qty = -qty
+	}
+
+	movement, err := s.inventorySvc.Post(r.Context(), &inventory.Movement{
+		ProductID: productID,
+		Kind:      item.Kind,
+		Qty:       qty,
+		StoreID:   item.StoreID,
+		ManagerID: id,
+		Reason:    item.Reason,
+	})
+	switch err {
+	case nil:
+	case inventory.ErrNotFound:
+		s.errorWriter(w, r, http.StatusNotFound, err)
+		return
+	case inventory.ErrInvalidMovement, inventory.ErrReasonRequired, inventory.ErrInsufficientStock:
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	default:
+		s.errorWriter(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	s.respondJSON(w, r, movement)
+}
+
+func (s *Server) handleManagerGetMovements(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if id == 0 {
+		s.errorWriter(w, r, http.StatusForbidden, err)
+		return
+	}
+
+	idParam, ok := mux.Vars(r)["id"]
+	if !ok {
+		s.errorWriter(w, r, http.StatusBadRequest, errors.New("Missing id"))
+		return
+	}
+	productID, err := strconv.ParseInt(idParam, 10, 64)
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	items, err := s.inventorySvc.History(r.Context(), productID)
+	if err != nil {
+		s.errorWriter(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	s.respondJSON(w, r, items)
+}
+
+func (s *Server) handleManagerGetLowStock(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if id == 0 {
+		s.errorWriter(w, r, http.StatusForbidden, err)
+		return
+	}
+
+	categoryIDs, err := s.categoryFilter(r)
+	if err != nil {
+		s.categoryFilterError(w, r, err)
+		return
+	}
+
+	items, err := s.inventorySvc.LowStock(r.Context(), categoryIDs)
+	if err != nil {
+		s.errorWriter(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	s.respondJSON(w, r, items)
+}
@@ -0,0 +1,122 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"github.com/ehsontjk/crud/cmd/app/middleware"
+	"github.com/ehsontjk/crud/pkg/loyalty"
+)
+
+func (s *Server) respondLoyalty(w http.ResponseWriter, r *http.Request, item interface{}, err error) {
+	switch err {
+	case nil:
+		s.respondJSON(w, r, item)
+	case loyalty.ErrNotFound:
+		s.errorWriter(w, r, http.StatusNotFound, err)
+	case loyalty.ErrInvalidPoints, loyalty.ErrReasonRequired, loyalty.ErrInsufficientPoints:
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+	default:
+		s.errorWriter(w, r, http.StatusInternalServerError, err)
+	}
+}
+
+func (s *Server) handleCustomerGetLoyalty(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if id == 0 {
+		s.errorWriter(w, r, http.StatusForbidden, err)
+		return
+	}
+
+	item, err := s.loyaltySvc.Balance(r.Context(), id)
+	s.respondLoyalty(w, r, item, err)
+}
+
+func (s *Server) handleCustomerGetLoyaltyHistory(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if id == 0 {
+		s.errorWriter(w, r, http.StatusForbidden, err)
+		return
+	}
+
+	items, err := s.loyaltySvc.History(r.Context(), id)
+	s.respondLoyalty(w, r, items, err)
+}
+
+func (s *Server) handleManagerGetLoyalty(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if id == 0 {
+		s.errorWriter(w, r, http.StatusForbidden, err)
+		return
+	}
+
+	idParam, ok := mux.Vars(r)["id"]
+	if !ok {
+		s.errorWriter(w, r, http.StatusBadRequest, errors.New("Missing id"))
+		return
+	}
+	customerID, err := strconv.ParseInt(idParam, 10, 64)
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	balance, err := s.loyaltySvc.Balance(r.Context(), customerID)
+	if err != nil {
+		s.respondLoyalty(w, r, nil, err)
+		return
+	}
+	items, err := s.loyaltySvc.History(r.Context(), customerID)
+	s.respondLoyalty(w, r, &loyaltyResponse{Balance: balance, History: items}, err)
+}
+
+func (s *Server) handleManagerAdjustLoyalty(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if id == 0 || !s.managerSvc.IsAdmin(r.Context(), id) {
+		s.errorWriter(w, r, http.StatusForbidden, err)
+		return
+	}
+
+	idParam, ok := mux.Vars(r)["id"]
+	if !ok {
+		s.errorWriter(w, r, http.StatusBadRequest, errors.New("Missing id"))
+		return
+	}
+	customerID, err := strconv.ParseInt(idParam, 10, 64)
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	var item struct {
+		Points int    `json:"points"`
+		Reason string `json:"reason"`
+	}
+	if err = json.NewDecoder(r.Body).Decode(&item); err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	entry, err := s.loyaltySvc.Adjust(r.Context(), id, customerID, item.Points, item.Reason)
+	s.respondLoyalty(w, r, entry, err)
+}
@@ -3,13 +3,15 @@ package app
 import (
 	"encoding/json"
 	"errors"
-	"fmt"
 	"net/http"
 	"strconv"
 
 	"github.com/gorilla/mux"
 	"github.com/ehsontjk/crud/cmd/app/middleware"
+	"github.com/ehsontjk/crud/pkg/inventory"
+	"github.com/ehsontjk/crud/pkg/loyalty"
 	"github.com/ehsontjk/crud/pkg/managers"
+	"github.com/ehsontjk/crud/pkg/promotions"
 )
 
 
@@ -20,39 +22,43 @@ func (s *Server) handleManagerRegistration(w http.ResponseWriter, r *http.Reques
 	id, err := middleware.Authentication(r.Context())
 	if err != nil {
 		
-		errorWriter(w, http.StatusBadRequest, err)
+		s.errorWriter(w, r, http.StatusBadRequest, err)
 		return
 	}
 	if id == 0 {
 	
-		errorWriter(w, http.StatusForbidden, err)
+		s.errorWriter(w, r, http.StatusForbidden, err)
 		return
 	}
 
 	if !s.managerSvc.IsAdmin(r.Context(), id) {
 		
-		errorWriter(w, http.StatusForbidden, err)
+		s.errorWriter(w, r, http.StatusForbidden, err)
 		return
 	}
 
 	var regItem struct {
-		ID    int64    `json:"id"`
-		Name  string   `json:"name"`
-		Phone string   `json:"phone"`
-		Roles []string `json:"roles"`
+		ID            int64    `json:"id"`
+		Name          string   `json:"name"`
+		Phone         string   `json:"phone"`
+		Roles         []string `json:"roles"`
+		DiscountLimit int      `json:"discount_limit"`
+		StoreID       int64    `json:"store_id"`
 	}
 
 	err = json.NewDecoder(r.Body).Decode(&regItem)
 
 	if err != nil {
 		
-		errorWriter(w, http.StatusInternalServerError, err)
+		s.errorWriter(w, r, http.StatusInternalServerError, err)
 		return
 	}
 	item := &managers.Manager{
-		ID:    regItem.ID,
-		Name:  regItem.Name,
-		Phone: regItem.Phone,
+		ID:            regItem.ID,
+		Name:          regItem.Name,
+		Phone:         regItem.Phone,
+		DiscountLimit: regItem.DiscountLimit,
+		StoreID:       regItem.StoreID,
 	}
 
 	for _, role := range regItem.Roles {
@@ -64,34 +70,42 @@ func (s *Server) handleManagerRegistration(w http.ResponseWriter, r *http.Reques
 
 	tkn, err := s.managerSvc.Create(r.Context(), item)
 
+	if err == managers.ErrInvalidDiscountLimit {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if err == managers.ErrPhoneUsed {
+		s.errorWriter(w, r, http.StatusConflict, err)
+		return
+	}
 	if err != nil {
 		
-		errorWriter(w, http.StatusInternalServerError, err)
+		s.errorWriter(w, r, http.StatusInternalServerError, err)
 		return
 	}
 
-	respondJSON(w, map[string]interface{}{"token": tkn})
+	s.respondJSON(w, r, &tokenResponse{Token: tkn})
 
 }
 
 func (s *Server) handleManagerGetToken(w http.ResponseWriter, r *http.Request) {
 
-	var manager *managers.Manager
+	var manager *tokenRequest
 	err := json.NewDecoder(r.Body).Decode(&manager)
 
 	if err != nil {
 		
-		errorWriter(w, http.StatusInternalServerError, err)
+		s.errorWriter(w, r, http.StatusInternalServerError, err)
 		return
 	}
 
 	tkn, err := s.managerSvc.Token(r.Context(), manager.Phone, manager.Password)
 	if err != nil {
 		
-		errorWriter(w, http.StatusBadRequest, err)
+		s.errorWriter(w, r, http.StatusBadRequest, err)
 		return
 	}
-	respondJSON(w, map[string]interface{}{"token": tkn})
+	s.respondJSON(w, r, &tokenResponse{Token: tkn})
 
 }
 
@@ -99,63 +113,109 @@ func (s *Server) handleManagerChangeProducts(w http.ResponseWriter, r *http.Requ
 	id, err := middleware.Authentication(r.Context())
 	if err != nil {
 		
-		errorWriter(w, http.StatusBadRequest, err)
+		s.errorWriter(w, r, http.StatusBadRequest, err)
 		return
 	}
 	if id == 0 {
 		
-		errorWriter(w, http.StatusForbidden, err)
+		s.errorWriter(w, r, http.StatusForbidden, err)
 		return
 	}
+	// v1 clients that do not know about skus leave them out of updates, so
+	// a missing sku keeps the stored one and only an empty one clears it
+	var request struct {
+		*managers.Product
+		SKU *string `json:"sku"`
+	}
 	product := &managers.Product{}
-	err = json.NewDecoder(r.Body).Decode(&product)
-	fmt.Print(product)
+	request.Product = product
+	err = json.NewDecoder(r.Body).Decode(&request)
 	if err != nil {
 		
-		errorWriter(w, http.StatusInternalServerError, err)
+		s.errorWriter(w, r, http.StatusInternalServerError, err)
 		return
 	}
+	if request.SKU != nil {
+		product.SKU = *request.SKU
+	} else if product.ID != 0 {
+		stored, err := s.managerSvc.Product(r.Context(), product.ID)
+		if err == managers.ErrNotFound {
+			s.errorWriter(w, r, http.StatusNotFound, err)
+			return
+		}
+		if err != nil {
+			s.errorWriter(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		product.SKU = stored.SKU
+	}
 
-	product, err = s.managerSvc.SaveProduct(r.Context(), product)
+	product, err = s.managerSvc.SaveProduct(r.Context(), id, product)
+	if err == managers.ErrNotFound {
+		s.errorWriter(w, r, http.StatusNotFound, err)
+		return
+	}
+	if err == inventory.ErrInvalidMovement {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if err == managers.ErrSKUDuplicated {
+		s.errorWriter(w, r, http.StatusConflict, err)
+		return
+	}
+	if err == managers.ErrInvalidCategory {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
 	if err != nil {
 		
-		errorWriter(w, http.StatusInternalServerError, err)
+		s.errorWriter(w, r, http.StatusInternalServerError, err)
 		return
 	}
 
-	respondJSON(w, product)
+	s.respondJSON(w, r, product)
 }
 
 func (s *Server) handleManagerMakeSales(w http.ResponseWriter, r *http.Request) {
 	id, err := middleware.Authentication(r.Context())
 	if err != nil {
 		
-		errorWriter(w, http.StatusBadRequest, err)
+		s.errorWriter(w, r, http.StatusBadRequest, err)
 		return
 	}
 	if id == 0 {
 		
-		errorWriter(w, http.StatusForbidden, err)
+		s.errorWriter(w, r, http.StatusForbidden, err)
 		return
 	}
 	sale := &managers.Sale{}
-	sale.ManagerID = id
 	err = json.NewDecoder(r.Body).Decode(&sale)
 
 	if err != nil {
 	
-		errorWriter(w, http.StatusInternalServerError, err)
+		s.errorWriter(w, r, http.StatusBadRequest, err)
 		return
 	}
+	sale.ManagerID = id
+	sale.Channel = managers.ChannelPOS
+	sale.StoreID = 0
 
 	sale, err = s.managerSvc.MakeSale(r.Context(), sale)
+	if err == managers.ErrInternal || err == inventory.ErrInternal || err == promotions.ErrInternal || err == loyalty.ErrInternal {
+		s.errorWriter(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	if err == managers.ErrDiscountLimitExceeded {
+		s.errorWriter(w, r, http.StatusForbidden, err)
+		return
+	}
 	if err != nil {
 	
-		errorWriter(w, http.StatusBadRequest, err)
+		s.errorWriter(w, r, http.StatusBadRequest, err)
 		return
 	}
 
-	respondJSON(w, sale)
+	s.respondJSON(w, r, sale)
 
 }
 
@@ -163,35 +223,53 @@ func (s *Server) handleManagerGetSales(w http.ResponseWriter, r *http.Request) {
 	id, err := middleware.Authentication(r.Context())
 	if err != nil {
 		
-		errorWriter(w, http.StatusBadRequest, err)
+		s.errorWriter(w, r, http.StatusBadRequest, err)
 		return
 	}
 	if id == 0 {
 		
-		errorWriter(w, http.StatusForbidden, err)
+		s.errorWriter(w, r, http.StatusForbidden, err)
 		return
 	}
-	total, err := s.managerSvc.GetSales(r.Context(), id)
+	categoryIDs, err := s.categoryFilter(r)
+	if err != nil {
+		s.categoryFilterError(w, r, err)
+		return
+	}
+
+	total, err := s.managerSvc.GetSales(r.Context(), id, categoryIDs)
 	if err != nil {
 	
-		errorWriter(w, http.StatusBadRequest, err)
+		s.errorWriter(w, r, http.StatusBadRequest, err)
 		return
 	}
 
-	respondJSON(w, map[string]interface{}{"manager_id": id, "total": total})
+	s.respondJSON(w, r, &salesTotalsResponse{
+		ManagerID: id,
+		Gross:     total.Gross,
+		Discount:  total.Discount,
+		Refunded:  total.Refunded,
+		Total:     total.Net,
+	})
 
 }
 
 func (s *Server) handleManagerGetProducts(w http.ResponseWriter, r *http.Request) {
 
-	items, err := s.managerSvc.Products(r.Context())
+	categoryIDs, err := s.categoryFilter(r)
+	if err != nil {
+		s.categoryFilterError(w, r, err)
+		return
+	}
+
+	items, err := s.managerSvc.Products(r.Context(), categoryIDs)
 	if err != nil {
 		
-		errorWriter(w, http.StatusBadRequest, err)
+		s.errorWriter(w, r, http.StatusBadRequest, err)
 		return
 	}
 
-	respondJSON(w, items)
+	s.respondJSON(w, r, items)
 
 }
 
@@ -199,31 +277,31 @@ func (s *Server) handleManagerRemoveProductByID(w http.ResponseWriter, r *http.R
 	id, err := middleware.Authentication(r.Context())
 	if err != nil {
 		
-		errorWriter(w, http.StatusBadRequest, err)
+		s.errorWriter(w, r, http.StatusBadRequest, err)
 		return
 	}
 	if id == 0 {
 		
-		errorWriter(w, http.StatusForbidden, err)
+		s.errorWriter(w, r, http.StatusForbidden, err)
 		return
 	}
 
 	idParam, ok := mux.Vars(r)["id"]
 	if !ok {
 		
-		errorWriter(w, http.StatusBadRequest, errors.New("Missing id"))
+		s.errorWriter(w, r, http.StatusBadRequest, errors.New("Missing id"))
 		return
 	}
 	productID, err := strconv.ParseInt(idParam, 10, 64)
 	if err != nil {
 		
-		errorWriter(w, http.StatusBadRequest, err)
+		s.errorWriter(w, r, http.StatusBadRequest, err)
 		return
 	}
 	err = s.managerSvc.RemoveProductByID(r.Context(), productID)
 	if err != nil {
 		
-		errorWriter(w, http.StatusBadRequest, err)
+		s.errorWriter(w, r, http.StatusBadRequest, err)
 		return
 	}
 
@@ -233,31 +311,31 @@ func (s *Server) handleManagerRemoveCustomerByID(w http.ResponseWriter, r *http.
 	id, err := middleware.Authentication(r.Context())
 	if err != nil {
 		
-		errorWriter(w, http.StatusBadRequest, err)
+		s.errorWriter(w, r, http.StatusBadRequest, err)
 		return
 	}
 	if id == 0 {
 		
-		errorWriter(w, http.StatusForbidden, err)
+		s.errorWriter(w, r, http.StatusForbidden, err)
 		return
 	}
 
 	idParam, ok := mux.Vars(r)["id"]
 	if !ok {
 		
-		errorWriter(w, http.StatusBadRequest, errors.New("Missing id"))
+		s.errorWriter(w, r, http.StatusBadRequest, errors.New("Missing id"))
 		return
 	}
 	customerID, err := strconv.ParseInt(idParam, 10, 64)
 	if err != nil {
 		
-		errorWriter(w, http.StatusBadRequest, err)
+		s.errorWriter(w, r, http.StatusBadRequest, err)
 		return
 	}
 	err = s.managerSvc.RemoveCustomerByID(r.Context(), customerID)
 	if err != nil {
 	
-		errorWriter(w, http.StatusBadRequest, err)
+		s.errorWriter(w, r, http.StatusBadRequest, err)
 		return
 	}
 
@@ -267,23 +345,23 @@ func (s *Server) handleManagerGetCustomers(w http.ResponseWriter, r *http.Reques
 	id, err := middleware.Authentication(r.Context())
 	if err != nil {
 		
-		errorWriter(w, http.StatusBadRequest, err)
+		s.errorWriter(w, r, http.StatusBadRequest, err)
 		return
 	}
 	if id == 0 {
 		
-		errorWriter(w, http.StatusForbidden, err)
+		s.errorWriter(w, r, http.StatusForbidden, err)
 		return
 	}
 
 	items, err := s.managerSvc.Customers(r.Context())
 	if err != nil {
 	
-		errorWriter(w, http.StatusBadRequest, err)
+		s.errorWriter(w, r, http.StatusBadRequest, err)
 		return
 	}
 
-	respondJSON(w, items)
+	s.respondJSON(w, r, items)
 
 }
 
@@ -291,30 +369,29 @@ func (s *Server) handleManagerChangeCustomer(w http.ResponseWriter, r *http.Requ
 	id, err := middleware.Authentication(r.Context())
 	if err != nil {
 	
-		errorWriter(w, http.StatusBadRequest, err)
+		s.errorWriter(w, r, http.StatusBadRequest, err)
 		return
 	}
 	if id == 0 {
 		
-		errorWriter(w, http.StatusForbidden, err)
+		s.errorWriter(w, r, http.StatusForbidden, err)
 		return
 	}
 	customer := &managers.Customer{}
 	err = json.NewDecoder(r.Body).Decode(&customer)
-	fmt.Println(customer)
 	if err != nil {
 		
-		errorWriter(w, http.StatusInternalServerError, err)
+		s.errorWriter(w, r, http.StatusInternalServerError, err)
 		return
 	}
 
 	customer, err = s.managerSvc.ChangeCustomer(r.Context(), customer)
 	if err != nil {
 		
-		errorWriter(w, http.StatusBadRequest, err)
+		s.errorWriter(w, r, http.StatusBadRequest, err)
 		return
 	}
 
-	respondJSON(w, customer)
+	s.respondJSON(w, r, customer)
 
 }
\ No newline at end of file
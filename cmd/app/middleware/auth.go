@@ -3,8 +3,9 @@ package middleware
 import (
 	"context"
 	"errors"
-	"log"
 	"net/http"
+
+	"github.com/ehsontjk/crud/pkg/logger"
 )
 
 const (
@@ -27,14 +28,14 @@ func (c *contextKey) String() string {
 
 type IDFunc func(ctx context.Context, token string) (int64, error)
 
-func Authenticate(idFunc IDFunc) func(http.Handler) http.Handler {
+func Authenticate(idFunc IDFunc, log *logger.Logger) func(http.Handler) http.Handler {
 	return func(handler http.Handler) http.Handler {
 		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
 			token := request.Header.Get("Authorization")
 
 			id, err := idFunc(request.Context(), token)
 			if err != nil {
-				log.Print(err, "Auth")
+				log.Error(request.Context(), "authentication failed", "err", err)
 				http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 				return
 			}
@@ -0,0 +1,19 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Deprecated marks the responses of an API version that has a successor.
+// The Link header points at the same route under the successor's prefix.
+func Deprecated(prefix, successor string) func(handler http.Handler) http.Handler {
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			link := successor + strings.TrimPrefix(request.URL.Path, prefix)
+			writer.Header().Set("Deprecation", "true")
+			writer.Header().Set("Link", "<"+link+">; rel=\"successor-version\"")
+			handler.ServeHTTP(writer, request)
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/ehsontjk/crud/pkg/logger"
+)
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(data)
+	w.size += n
+	return n, err
+}
+
+// Flush lets streaming handlers push data through the logging wrapper.
+func (w *statusWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack lets handlers take over the connection, e.g. for WebSocket.
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+	w.status = http.StatusSwitchingProtocols
+	return hijacker.Hijack()
+}
+
+func Logging(log *logger.Logger) func(http.Handler) http.Handler {
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			start := time.Now()
+			sw := &statusWriter{ResponseWriter: writer}
+
+			handler.ServeHTTP(sw, request)
+
+			if sw.status == 0 {
+				sw.status = http.StatusOK
+			}
+			log.Info(request.Context(), "request",
+				"method", request.Method,
+				"path", request.URL.Path,
+				"status", sw.status,
+				"bytes", sw.size,
+				"duration_ms", time.Since(start).Milliseconds(),
+			)
+		})
+	}
+}
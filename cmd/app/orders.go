@@ -0,0 +1,132 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"github.com/ehsontjk/crud/cmd/app/middleware"
+	"github.com/ehsontjk/crud/pkg/inventory"
+	"github.com/ehsontjk/crud/pkg/orders"
+)
+
+func saleIDParam(r *http.Request) (int64, error) {
+	idParam, ok := mux.Vars(r)["id"]
+	if !ok {
+		return 0, errors.New("Missing id")
+	}
+	return strconv.ParseInt(idParam, 10, 64)
+}
+
+func (s *Server) respondOrder(w http.ResponseWriter, r *http.Request, order *orders.Order, err error) {
+	switch err {
+	case nil:
+		s.respondJSON(w, r, order)
+	case orders.ErrNotFound:
+		s.errorWriter(w, r, http.StatusNotFound, err)
+	case orders.ErrInvalidStatus, orders.ErrInvalidTransition:
+		s.errorWriter(w, r, http.StatusConflict, err)
+	case inventory.ErrInsufficientStock, inventory.ErrInvalidMovement:
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+	default:
+		s.errorWriter(w, r, http.StatusInternalServerError, err)
+	}
+}
+
+func (s *Server) handleManagerGetSaleStatus(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if id == 0 {
+		s.errorWriter(w, r, http.StatusForbidden, err)
+		return
+	}
+
+	saleID, err := saleIDParam(r)
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	order, err := s.orderSvc.Order(r.Context(), saleID)
+	s.respondOrder(w, r, order, err)
+}
+
+func (s *Server) handleManagerAdvanceSale(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if id == 0 {
+		s.errorWriter(w, r, http.StatusForbidden, err)
+		return
+	}
+
+	saleID, err := saleIDParam(r)
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	var item struct {
+		Status orders.Status `json:"status"`
+	}
+	if err = json.NewDecoder(r.Body).Decode(&item); err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if !item.Status.Valid() {
+		s.errorWriter(w, r, http.StatusBadRequest, orders.ErrInvalidStatus)
+		return
+	}
+
+	order, err := s.orderSvc.Advance(r.Context(), saleID, item.Status, orders.Actor{ManagerID: id})
+	s.respondOrder(w, r, order, err)
+}
+
+func (s *Server) handleCustomerGetOrders(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if id == 0 {
+		s.errorWriter(w, r, http.StatusForbidden, err)
+		return
+	}
+
+	items, err := s.orderSvc.CustomerOrders(r.Context(), id)
+	if err != nil {
+		s.errorWriter(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	s.respondJSON(w, r, items)
+}
+
+func (s *Server) handleCustomerGetOrder(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if id == 0 {
+		s.errorWriter(w, r, http.StatusForbidden, err)
+		return
+	}
+
+	saleID, err := saleIDParam(r)
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	order, err := s.orderSvc.CustomerOrder(r.Context(), id, saleID)
+	s.respondOrder(w, r, order, err)
+}
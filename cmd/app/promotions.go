@@ -0,0 +1,103 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"github.com/ehsontjk/crud/cmd/app/middleware"
+	"github.com/ehsontjk/crud/pkg/promotions"
+)
+
+func (s *Server) handleManagerGetPromotions(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if id == 0 {
+		s.errorWriter(w, r, http.StatusForbidden, err)
+		return
+	}
+
+	items, err := s.promotionSvc.All(r.Context())
+	if err != nil {
+		s.errorWriter(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	s.respondJSON(w, r, items)
+}
+
+func (s *Server) handleManagerSavePromotion(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if id == 0 || !s.managerSvc.IsAdmin(r.Context(), id) {
+		s.errorWriter(w, r, http.StatusForbidden, err)
+		return
+	}
+
+	item := &promotions.Promotion{}
+	if err = json.NewDecoder(r.Body).Decode(&item); err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	item, err = s.promotionSvc.Save(r.Context(), item)
+	switch err {
+	case nil:
+	case promotions.ErrNotFound:
+		s.errorWriter(w, r, http.StatusNotFound, err)
+		return
+	case promotions.ErrInvalidPromo:
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	case promotions.ErrCodeDuplicated:
+		s.errorWriter(w, r, http.StatusConflict, err)
+		return
+	default:
+		s.errorWriter(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	s.respondJSON(w, r, item)
+}
+
+func (s *Server) handleManagerRemovePromotionByID(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if id == 0 || !s.managerSvc.IsAdmin(r.Context(), id) {
+		s.errorWriter(w, r, http.StatusForbidden, err)
+		return
+	}
+
+	idParam, ok := mux.Vars(r)["id"]
+	if !ok {
+		s.errorWriter(w, r, http.StatusBadRequest, errors.New("Missing id"))
+		return
+	}
+	promotionID, err := strconv.ParseInt(idParam, 10, 64)
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	err = s.promotionSvc.Deactivate(r.Context(), promotionID)
+	if err == promotions.ErrNotFound {
+		s.errorWriter(w, r, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		s.errorWriter(w, r, http.StatusInternalServerError, err)
+		return
+	}
+}
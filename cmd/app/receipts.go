@@ -0,0 +1,87 @@
+package app
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/ehsontjk/crud/cmd/app/middleware"
+	"github.com/ehsontjk/crud/pkg/receipts"
+)
+
+func (s *Server) handleManagerGetReceipt(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if id == 0 {
+		s.errorWriter(w, r, http.StatusForbidden, err)
+		return
+	}
+
+	saleID, err := saleIDParam(r)
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	paperMM := 80
+	if param := r.URL.Query().Get("width"); param != "" {
+		if paperMM, err = strconv.Atoi(param); err != nil {
+			s.errorWriter(w, r, http.StatusBadRequest, err)
+			return
+		}
+	}
+	cols, err := receipts.Columns(paperMM)
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	switch format {
+	case "":
+		format = "text"
+	case "text", "escpos", "html", "pdf":
+	default:
+		s.errorWriter(w, r, http.StatusBadRequest, receipts.ErrUnsupportedFormat)
+		return
+	}
+
+	receipt, err := s.receiptSvc.ForSale(r.Context(), saleID)
+	if err == receipts.ErrNotFound {
+		s.errorWriter(w, r, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		s.errorWriter(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	var data []byte
+	switch format {
+	case "text":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		data = []byte(receipt.Text(cols))
+	case "escpos":
+		w.Header().Set("Content-Type", "application/octet-stream")
+		data = receipt.ESCPOS(cols)
+	case "html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err = receipt.HTML(w); err != nil {
+			s.log.Error(r.Context(), "write receipt", "err", err)
+		}
+		return
+	case "pdf":
+		if data, err = receipt.PDF(paperMM); err != nil {
+			s.errorWriter(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", "inline; filename=receipt-"+strconv.FormatInt(receipt.Number, 10)+".pdf")
+	}
+
+	if _, err = w.Write(data); err != nil {
+		s.log.Error(r.Context(), "write receipt", "err", err)
+	}
+}
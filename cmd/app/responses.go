@@ -0,0 +1,197 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ehsontjk/crud/pkg/catalog"
+	"github.com/ehsontjk/crud/pkg/categories"
+	"github.com/ehsontjk/crud/pkg/customers"
+	"github.com/ehsontjk/crud/pkg/images"
+	"github.com/ehsontjk/crud/pkg/inventory"
+	"github.com/ehsontjk/crud/pkg/loyalty"
+	"github.com/ehsontjk/crud/pkg/managers"
+	"github.com/ehsontjk/crud/pkg/orders"
+	"github.com/ehsontjk/crud/pkg/productcache"
+	"github.com/ehsontjk/crud/pkg/promotions"
+	"github.com/ehsontjk/crud/pkg/returns"
+	"github.com/ehsontjk/crud/pkg/stores"
+	"github.com/ehsontjk/crud/pkg/webhooks"
+)
+
+// Responses are written from the types below, or from domain types that
+// hold no credentials. marshalResponse refuses any type with a field that
+// looks like a credential, unless the field is tagged response:"issued": a
+// token or secret that is handed to its owner on purpose.
+const issuedTag = "issued"
+
+// sensitiveNames are the parts of field names that mark credentials.
+var sensitiveNames = []string{"password", "secret", "token", "hash"}
+
+// responseTypes are the types the handlers respond with. The tests check
+// them all, so that a credential added to one of them fails the build
+// rather than the request.
+var responseTypes = []interface{}{
+	(*tokenResponse)(nil),
+	(*customerTokenResponse)(nil),
+	(*customerResource)(nil),
+	(*productResource)(nil),
+	(*salesTotalsResponse)(nil),
+	(*loyaltyResponse)(nil),
+	(*subscriptionResponse)(nil),
+	(*customers.Cart)(nil),
+	([]*customers.Product)(nil),
+	(*managers.Sale)(nil),
+	(*managers.Product)(nil),
+	([]*managers.Product)(nil),
+	([]*managers.Customer)(nil),
+	(*managers.Customer)(nil),
+	(*catalog.ImportReport)(nil),
+	([]*categories.Category)(nil),
+	(*categories.Category)(nil),
+	(*images.Image)(nil),
+	([]*images.Image)(nil),
+	(*inventory.Movement)(nil),
+	(*productcache.Stats)(nil),
+	([]*inventory.Movement)(nil),
+	([]*inventory.LowStockProduct)(nil),
+	(*inventory.StoreTransfer)(nil),
+	([]*inventory.StoreStock)(nil),
+	(*loyalty.Balance)(nil),
+	(*loyalty.Entry)(nil),
+	([]*loyalty.Entry)(nil),
+	(*orders.Order)(nil),
+	([]*orders.Order)(nil),
+	(*promotions.Promotion)(nil),
+	([]*promotions.Promotion)(nil),
+	(*returns.Return)(nil),
+	([]*returns.Return)(nil),
+	(*stores.Store)(nil),
+	([]*stores.Store)(nil),
+	([]*webhooks.Delivery)(nil),
+}
+
+type tokenResponse struct {
+	Token string `json:"token" response:"issued"`
+}
+
+// customerTokenResponse is the v1 shape of a customer's token.
+type customerTokenResponse struct {
+	Status string `json:"status"`
+	Token  string `json:"token" response:"issued"`
+}
+
+// customerResource is a customer as both customers and managers see it. It
+// never carries the password.
+type customerResource struct {
+	ID      int64     `json:"id"`
+	Name    string    `json:"name"`
+	Phone   string    `json:"phone"`
+	Active  bool      `json:"active"`
+	Created time.Time `json:"created"`
+}
+
+func newCustomerResource(id int64, name, phone string, active bool, created time.Time) *customerResource {
+	return &customerResource{ID: id, Name: name, Phone: phone, Active: active, Created: created}
+}
+
+type salesTotalsResponse struct {
+	ManagerID int64 `json:"manager_id"`
+	Gross     int   `json:"gross"`
+	Discount  int   `json:"discount"`
+	Refunded  int   `json:"refunded"`
+	Total     int   `json:"total"`
+}
+
+type loyaltyResponse struct {
+	Balance *loyalty.Balance `json:"balance"`
+	History []*loyalty.Entry `json:"history"`
+}
+
+// subscriptionResponse is a webhook subscription. Its secret is only set
+// right after the subscription is saved.
+type subscriptionResponse struct {
+	ID      int64     `json:"id"`
+	URL     string    `json:"url"`
+	Events  []string  `json:"events"`
+	Secret  string    `json:"secret,omitempty" response:"issued"`
+	Active  bool      `json:"active"`
+	Created time.Time `json:"created"`
+}
+
+func newSubscriptionResponse(item *webhooks.Subscription) *subscriptionResponse {
+	return &subscriptionResponse{ID: item.ID, URL: item.URL, Events: item.Events, Secret: item.Secret, Active: item.Active, Created: item.Created}
+}
+
+// marshalResponse encodes v as a response body, or fails if v could leak a
+// credential.
+func marshalResponse(v interface{}) ([]byte, error) {
+	if fields := sensitiveFields(reflect.TypeOf(v)); len(fields) > 0 {
+		return nil, fmt.Errorf("response would leak %s", strings.Join(fields, ", "))
+	}
+	return json.Marshal(v)
+}
+
+var sensitiveCache sync.Map
+
+// sensitiveFields returns the fields of t, or of the types it holds, that
+// would put a credential into its JSON.
+func sensitiveFields(t reflect.Type) []string {
+	if t == nil {
+		return nil
+	}
+	if fields, ok := sensitiveCache.Load(t); ok {
+		return fields.([]string)
+	}
+	var fields []string
+	scanFields(t, make(map[reflect.Type]bool), &fields)
+	sensitiveCache.Store(t, fields)
+	return fields
+}
+
+func scanFields(t reflect.Type, seen map[reflect.Type]bool, fields *[]string) {
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+		scanFields(t.Elem(), seen, fields)
+		return
+	case reflect.Struct:
+	default:
+		return
+	}
+	if seen[t] {
+		return
+	}
+	seen[t] = true
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" && !field.Anonymous {
+			continue
+		}
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		if field.Tag.Get("response") != issuedTag && (sensitiveName(name) || sensitiveName(field.Name)) {
+			*fields = append(*fields, t.String()+"."+field.Name)
+		}
+		scanFields(field.Type, seen, fields)
+	}
+}
+
+func sensitiveName(name string) bool {
+	name = strings.ToLower(name)
+	for _, part := range sensitiveNames {
+		if strings.Contains(name, part) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,82 @@
+package app
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ehsontjk/crud/pkg/logger"
+	"github.com/ehsontjk/crud/pkg/webhooks"
+)
+
+func TestResponseTypesHoldNoCredentials(t *testing.T) {
+	for _, v := range responseTypes {
+		if fields := sensitiveFields(reflect.TypeOf(v)); len(fields) > 0 {
+			t.Errorf("%T would leak %s", v, strings.Join(fields, ", "))
+		}
+	}
+}
+
+func TestSensitiveFields(t *testing.T) {
+	type credentials struct {
+		Hash string `json:"h"`
+	}
+	tests := []struct {
+		name  string
+		v     interface{}
+		leaks bool
+	}{
+		{"plain", struct {
+			Name string `json:"name"`
+		}{}, false},
+		{"json name", struct {
+			Key string `json:"password"`
+		}{}, true},
+		{"field name", struct {
+			Password string `json:"p"`
+		}{}, true},
+		{"left out", struct {
+			Password string `json:"-"`
+		}{}, false},
+		{"issued", struct {
+			Token string `json:"token" response:"issued"`
+		}{}, false},
+		{"nested", struct {
+			Items []*credentials `json:"items"`
+		}{}, true},
+		{"webhook subscription", &webhooks.Subscription{}, true},
+	}
+	for _, test := range tests {
+		fields := sensitiveFields(reflect.TypeOf(test.v))
+		if leaks := len(fields) > 0; leaks != test.leaks {
+			t.Errorf("%s: leaks = %v (%v), want %v", test.name, leaks, fields, test.leaks)
+		}
+	}
+}
+
+func TestRespondRefusesCredentials(t *testing.T) {
+	s := &Server{log: logger.NewWithWriter(ioutil.Discard, logger.ERROR)}
+	item := &webhooks.Subscription{ID: 1, Secret: "s3cret"}
+
+	responders := map[string]func(w http.ResponseWriter, r *http.Request){
+		"respondJSON": func(w http.ResponseWriter, r *http.Request) {
+			s.respondJSON(w, r, item)
+		},
+		"respondCreated": func(w http.ResponseWriter, r *http.Request) {
+			s.respondCreated(w, r, "/api/v2/webhooks/1", item)
+		},
+	}
+	for name, respond := range responders {
+		w := httptest.NewRecorder()
+		respond(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		if w.Code != http.StatusInternalServerError {
+			t.Errorf("%s: status = %d, want %d", name, w.Code, http.StatusInternalServerError)
+		}
+		if strings.Contains(w.Body.String(), item.Secret) {
+			t.Errorf("%s: secret written to the response", name)
+		}
+	}
+}
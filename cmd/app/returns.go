@@ -0,0 +1,84 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/ehsontjk/crud/cmd/app/middleware"
+	"github.com/ehsontjk/crud/pkg/inventory"
+	"github.com/ehsontjk/crud/pkg/loyalty"
+	"github.com/ehsontjk/crud/pkg/returns"
+)
+
+func (s *Server) handleManagerMakeReturn(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if id == 0 {
+		s.errorWriter(w, r, http.StatusForbidden, err)
+		return
+	}
+
+	saleID, err := saleIDParam(r)
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	item := &returns.Return{}
+	if err = json.NewDecoder(r.Body).Decode(&item); err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+	item.ID = 0
+	item.SaleID = saleID
+	item.ManagerID = id
+	item.Refund = 0
+
+	item, err = s.returnSvc.Create(r.Context(), item)
+	switch err {
+	case nil:
+	case returns.ErrNotFound:
+		s.errorWriter(w, r, http.StatusNotFound, err)
+		return
+	case returns.ErrSaleCancelled, returns.ErrSaleNotPaid, returns.ErrNothingToReturn:
+		s.errorWriter(w, r, http.StatusConflict, err)
+		return
+	case returns.ErrInternal, inventory.ErrInternal, loyalty.ErrInternal:
+		s.errorWriter(w, r, http.StatusInternalServerError, err)
+		return
+	default:
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	s.respondJSON(w, r, item)
+}
+
+func (s *Server) handleManagerGetReturns(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if id == 0 {
+		s.errorWriter(w, r, http.StatusForbidden, err)
+		return
+	}
+
+	saleID, err := saleIDParam(r)
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	items, err := s.returnSvc.ForSale(r.Context(), saleID)
+	if err != nil {
+		s.errorWriter(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	s.respondJSON(w, r, items)
+}
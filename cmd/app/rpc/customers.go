@@ -0,0 +1,59 @@
+package rpc
+
+import (
+	"context"
+
+	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/ehsontjk/crud/api/crudv1"
+	"github.com/ehsontjk/crud/pkg/customers"
+)
+
+// customerServer is the Customers service of api/proto.
+type customerServer struct {
+	crudv1.UnimplementedCustomersServer
+	*Server
+}
+
+func (s *customerServer) Register(ctx context.Context, req *crudv1.RegisterCustomerRequest) (*crudv1.Customer, error) {
+
+	if req.Phone == "" || req.Password == "" {
+		return nil, status.Error(codes.InvalidArgument, "phone and password are required")
+	}
+	hashed, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	item, err := s.customerSvc.Save(ctx, &customers.Customer{Name: req.Name, Phone: req.Phone, Password: string(hashed)})
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return &crudv1.Customer{Id: item.ID, Name: item.Name, Phone: item.Phone, Active: item.Active, Created: timestamp(item.Created)}, nil
+}
+
+func (s *customerServer) Token(ctx context.Context, req *crudv1.TokenRequest) (*crudv1.TokenResponse, error) {
+	token, err := s.customerSvc.Token(ctx, req.Phone, req.Password)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return &crudv1.TokenResponse{Token: token}, nil
+}
+
+func (s *customerServer) Products(ctx context.Context, req *crudv1.ProductsRequest) (*crudv1.CatalogProducts, error) {
+	categoryIDs, err := s.categoryFilter(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	items, err := s.customerSvc.Products(ctx, categoryIDs)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	resp := &crudv1.CatalogProducts{}
+	for _, item := range items {
+		resp.Items = append(resp.Items, catalogProductMessage(item))
+	}
+	return resp, nil
+}
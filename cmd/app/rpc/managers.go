@@ -0,0 +1,174 @@
+package rpc
+
+import (
+	"context"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/ehsontjk/crud/api/crudv1"
+	"github.com/ehsontjk/crud/pkg/managers"
+)
+
+// adminRole in the roles of a new manager makes them an admin.
+const adminRole = "ADMIN"
+
+// managerServer is the Managers service of api/proto.
+type managerServer struct {
+	crudv1.UnimplementedManagersServer
+	*Server
+}
+
+func (s *managerServer) Token(ctx context.Context, req *crudv1.TokenRequest) (*crudv1.TokenResponse, error) {
+	token, err := s.managerSvc.Token(ctx, req.Phone, req.Password)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return &crudv1.TokenResponse{Token: token}, nil
+}
+
+func (s *managerServer) Register(ctx context.Context, req *crudv1.RegisterManagerRequest) (*crudv1.TokenResponse, error) {
+	id, err := authentication(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !s.managerSvc.IsAdmin(ctx, id) {
+		return nil, status.Error(codes.PermissionDenied, "admin only")
+	}
+
+	item := &managers.Manager{
+		Name:          req.Name,
+		Phone:         req.Phone,
+		DiscountLimit: int(req.DiscountLimit),
+		StoreID:       req.StoreId,
+	}
+	for _, role := range req.Roles {
+		if role == adminRole {
+			item.IsAdmin = true
+			break
+		}
+	}
+
+	token, err := s.managerSvc.Create(ctx, item)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return &crudv1.TokenResponse{Token: token}, nil
+}
+
+func (s *managerServer) Products(ctx context.Context, req *crudv1.ProductsRequest) (*crudv1.ProductList, error) {
+	if _, err := authentication(ctx); err != nil {
+		return nil, err
+	}
+	categoryIDs, err := s.categoryFilter(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	items, err := s.managerSvc.Products(ctx, categoryIDs)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	resp := &crudv1.ProductList{}
+	for _, item := range items {
+		resp.Items = append(resp.Items, productMessage(item))
+	}
+	return resp, nil
+}
+
+func (s *managerServer) SaveProduct(ctx context.Context, req *crudv1.Product) (*crudv1.Product, error) {
+	id, err := authentication(ctx)
+	if err != nil {
+		return nil, err
+	}
+	item, err := s.managerSvc.SaveProduct(ctx, id, productFromMessage(req))
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return productMessage(item), nil
+}
+
+func (s *managerServer) RemoveProduct(ctx context.Context, req *crudv1.IDRequest) (*crudv1.Empty, error) {
+	if _, err := authentication(ctx); err != nil {
+		return nil, err
+	}
+	if err := s.managerSvc.RemoveProductByID(ctx, req.Id); err != nil {
+		return nil, statusError(err)
+	}
+	return &crudv1.Empty{}, nil
+}
+
+func (s *managerServer) MakeSale(ctx context.Context, req *crudv1.Sale) (*crudv1.Sale, error) {
+	id, err := authentication(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	item := saleFromMessage(req)
+	item.ManagerID = id
+	item.Channel = managers.ChannelPOS
+	item.StoreID = 0
+
+	item, err = s.managerSvc.MakeSale(ctx, item)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return saleMessage(item), nil
+}
+
+func (s *managerServer) Sales(ctx context.Context, req *crudv1.ProductsRequest) (*crudv1.SalesTotals, error) {
+	id, err := authentication(ctx)
+	if err != nil {
+		return nil, err
+	}
+	categoryIDs, err := s.categoryFilter(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	totals, err := s.managerSvc.GetSales(ctx, id, categoryIDs)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return &crudv1.SalesTotals{
+		ManagerId: id,
+		Gross:     int64(totals.Gross),
+		Discount:  int64(totals.Discount),
+		Refunded:  int64(totals.Refunded),
+		Total:     int64(totals.Net),
+	}, nil
+}
+
+func (s *managerServer) Customers(ctx context.Context, req *crudv1.Empty) (*crudv1.CustomerList, error) {
+	if _, err := authentication(ctx); err != nil {
+		return nil, err
+	}
+	items, err := s.managerSvc.Customers(ctx)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	resp := &crudv1.CustomerList{}
+	for _, item := range items {
+		resp.Items = append(resp.Items, customerMessage(item))
+	}
+	return resp, nil
+}
+
+func (s *managerServer) SaveCustomer(ctx context.Context, req *crudv1.Customer) (*crudv1.Customer, error) {
+	if _, err := authentication(ctx); err != nil {
+		return nil, err
+	}
+	item, err := s.managerSvc.ChangeCustomer(ctx, customerFromMessage(req))
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return customerMessage(item), nil
+}
+
+func (s *managerServer) RemoveCustomer(ctx context.Context, req *crudv1.IDRequest) (*crudv1.Empty, error) {
+	if _, err := authentication(ctx); err != nil {
+		return nil, err
+	}
+	if err := s.managerSvc.RemoveCustomerByID(ctx, req.Id); err != nil {
+		return nil, statusError(err)
+	}
+	return &crudv1.Empty{}, nil
+}
@@ -0,0 +1,214 @@
+package rpc
+
+import (
+	"time"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/ehsontjk/crud/api/crudv1"
+	"github.com/ehsontjk/crud/pkg/customers"
+	"github.com/ehsontjk/crud/pkg/images"
+	"github.com/ehsontjk/crud/pkg/managers"
+	"github.com/ehsontjk/crud/pkg/promotions"
+)
+
+// Conversions between the messages generated from api/proto and the types
+// of the services. Messages of requests convert to service types, results
+// of the services back to messages.
+
+// timestamp leaves zero times out of messages.
+func timestamp(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
+
+func fromTimestamp(ts *timestamppb.Timestamp) time.Time {
+	if ts == nil {
+		return time.Time{}
+	}
+	return ts.AsTime()
+}
+
+func imageMessages(items []*images.Image) []*crudv1.Image {
+	var ms []*crudv1.Image
+	for _, item := range items {
+		ms = append(ms, &crudv1.Image{
+			Id:          item.ID,
+			ProductId:   item.ProductID,
+			ContentType: item.ContentType,
+			Width:       int64(item.Width),
+			Height:      int64(item.Height),
+			Url:         item.URL,
+			Thumbnails:  item.Thumbnails,
+			Created:     timestamp(item.Created),
+		})
+	}
+	return ms
+}
+
+func catalogProductMessage(item *customers.Product) *crudv1.CatalogProduct {
+	m := &crudv1.CatalogProduct{
+		Id:         item.ID,
+		CategoryId: item.CategoryID,
+		Name:       item.Name,
+		Price:      int64(item.Price),
+		Qty:        int64(item.Qty),
+		Images:     imageMessages(item.Images),
+	}
+	for _, a := range item.Availability {
+		m.Availability = append(m.Availability, &crudv1.Availability{
+			StoreId: a.StoreID,
+			Name:    a.Name,
+			Address: a.Address,
+			Qty:     int64(a.Qty),
+		})
+	}
+	return m
+}
+
+func productMessage(item *managers.Product) *crudv1.Product {
+	return &crudv1.Product{
+		Id:           item.ID,
+		Sku:          item.SKU,
+		CategoryId:   item.CategoryID,
+		Name:         item.Name,
+		Price:        int64(item.Price),
+		Qty:          int64(item.Qty),
+		ReorderLevel: int64(item.ReorderLevel),
+		Active:       item.Active,
+		Created:      timestamp(item.Created),
+		Images:       imageMessages(item.Images),
+	}
+}
+
+// productFromMessage leaves out the images, they are uploaded through the
+// REST API.
+func productFromMessage(m *crudv1.Product) *managers.Product {
+	return &managers.Product{
+		ID:           m.Id,
+		SKU:          m.Sku,
+		CategoryID:   m.CategoryId,
+		Name:         m.Name,
+		Price:        int(m.Price),
+		Qty:          int(m.Qty),
+		ReorderLevel: int(m.ReorderLevel),
+		Active:       m.Active,
+		Created:      fromTimestamp(m.Created),
+	}
+}
+
+func saleMessage(item *managers.Sale) *crudv1.Sale {
+	m := &crudv1.Sale{
+		Id:           item.ID,
+		ManagerId:    item.ManagerID,
+		CustomerId:   item.CustomerID,
+		Channel:      item.Channel,
+		StoreId:      item.StoreID,
+		Status:       item.Status,
+		Gross:        int64(item.Gross),
+		Discount:     int64(item.Discount),
+		Total:        int64(item.Total),
+		Created:      timestamp(item.Created),
+		PromoCodes:   item.PromoCodes,
+		RedeemPoints: int64(item.RedeemPoints),
+		PointsEarned: int64(item.PointsEarned),
+	}
+	for _, p := range item.Positions {
+		m.Positions = append(m.Positions, &crudv1.SalePosition{
+			Id:        p.ID,
+			ProductId: p.ProductID,
+			SaleId:    p.SaleID,
+			Price:     int64(p.Price),
+			Qty:       int64(p.Qty),
+			Created:   timestamp(p.Created),
+		})
+	}
+	for _, d := range item.Discounts {
+		m.Discounts = append(m.Discounts, &crudv1.SaleDiscount{
+			Id:        d.ID,
+			SaleId:    d.SaleID,
+			ProductId: d.ProductID,
+			Amount:    int64(d.Amount),
+			Reason:    d.Reason,
+			Created:   timestamp(d.Created),
+		})
+	}
+	for _, p := range item.Promotions {
+		m.Promotions = append(m.Promotions, &crudv1.AppliedPromotion{
+			PromotionId: p.PromotionID,
+			Code:        p.Code,
+			Name:        p.Name,
+			Amount:      int64(p.Amount),
+		})
+	}
+	return m
+}
+
+func saleFromMessage(m *crudv1.Sale) *managers.Sale {
+	item := &managers.Sale{
+		ID:           m.Id,
+		ManagerID:    m.ManagerId,
+		CustomerID:   m.CustomerId,
+		Channel:      m.Channel,
+		StoreID:      m.StoreId,
+		Status:       m.Status,
+		Gross:        int(m.Gross),
+		Discount:     int(m.Discount),
+		Total:        int(m.Total),
+		Created:      fromTimestamp(m.Created),
+		PromoCodes:   m.PromoCodes,
+		RedeemPoints: int(m.RedeemPoints),
+		PointsEarned: int(m.PointsEarned),
+	}
+	for _, p := range m.Positions {
+		item.Positions = append(item.Positions, &managers.SalePosition{
+			ID:        p.Id,
+			ProductID: p.ProductId,
+			SaleID:    p.SaleId,
+			Price:     int(p.Price),
+			Qty:       int(p.Qty),
+			Created:   fromTimestamp(p.Created),
+		})
+	}
+	for _, d := range m.Discounts {
+		item.Discounts = append(item.Discounts, &managers.SaleDiscount{
+			ID:        d.Id,
+			SaleID:    d.SaleId,
+			ProductID: d.ProductId,
+			Amount:    int(d.Amount),
+			Reason:    d.Reason,
+			Created:   fromTimestamp(d.Created),
+		})
+	}
+	for _, p := range m.Promotions {
+		item.Promotions = append(item.Promotions, &promotions.Applied{
+			PromotionID: p.PromotionId,
+			Code:        p.Code,
+			Name:        p.Name,
+			Amount:      int(p.Amount),
+		})
+	}
+	return item
+}
+
+func customerMessage(item *managers.Customer) *crudv1.Customer {
+	return &crudv1.Customer{
+		Id:      item.ID,
+		Name:    item.Name,
+		Phone:   item.Phone,
+		Active:  item.Active,
+		Created: timestamp(item.Created),
+	}
+}
+
+func customerFromMessage(m *crudv1.Customer) *managers.Customer {
+	return &managers.Customer{
+		ID:      m.Id,
+		Name:    m.Name,
+		Phone:   m.Phone,
+		Active:  m.Active,
+		Created: fromTimestamp(m.Created),
+	}
+}
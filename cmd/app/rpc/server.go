@@ -0,0 +1,153 @@
+package rpc
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/ehsontjk/crud/api/crudv1"
+	"github.com/ehsontjk/crud/pkg/categories"
+	"github.com/ehsontjk/crud/pkg/customers"
+	"github.com/ehsontjk/crud/pkg/inventory"
+	"github.com/ehsontjk/crud/pkg/logger"
+	"github.com/ehsontjk/crud/pkg/loyalty"
+	"github.com/ehsontjk/crud/pkg/managers"
+	"github.com/ehsontjk/crud/pkg/promotions"
+)
+
+// Full names of the services in api/proto.
+const (
+	CustomersService = "crud.v1.Customers"
+	ManagersService  = "crud.v1.Managers"
+)
+
+// authorizationKey is the metadata key of the token, as the Authorization
+// header of the REST API.
+const authorizationKey = "authorization"
+
+// Server is the gRPC API. It serves the same service instances as the REST
+// handlers of cmd/app.
+type Server struct {
+	grpc        *grpc.Server
+	customerSvc *customers.Service
+	managerSvc  *managers.Service
+	categorySvc *categories.Service
+	log         *logger.Logger
+}
+
+func NewServer(cSvc *customers.Service, mSvc *managers.Service, catgSvc *categories.Service, log *logger.Logger) *Server {
+	s := &Server{
+		customerSvc: cSvc,
+		managerSvc:  mSvc,
+		categorySvc: catgSvc,
+		log:         log,
+	}
+	s.grpc = grpc.NewServer(grpc.UnaryInterceptor(s.authenticate))
+	crudv1.RegisterCustomersServer(s.grpc, &customerServer{Server: s})
+	crudv1.RegisterManagersServer(s.grpc, &managerServer{Server: s})
+	return s
+}
+
+// Serve accepts connections on lis until Stop is called.
+func (s *Server) Serve(lis net.Listener) error {
+	return s.grpc.Serve(lis)
+}
+
+func (s *Server) Stop() {
+	s.grpc.GracefulStop()
+}
+
+var authenticationContextKey = &contextKey{"authentication context"}
+
+type contextKey struct {
+	name string
+}
+
+func (c *contextKey) String() string {
+	return c.name
+}
+
+// authenticate resolves the token of the call, if any, to the id of the
+// customer or manager, depending on the service called. As with the REST
+// API, the id is 0 for anonymous calls and the methods decide whether that
+// is enough.
+func (s *Server) authenticate(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+
+	token := ""
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(authorizationKey); len(values) > 0 {
+			token = values[0]
+		}
+	}
+
+	var id int64
+	var err error
+	switch {
+	case token == "":
+	case strings.HasPrefix(info.FullMethod, "/"+CustomersService+"/"):
+		id, err = s.customerSvc.IDByToken(ctx, token)
+	case strings.HasPrefix(info.FullMethod, "/"+ManagersService+"/"):
+		id, err = s.managerSvc.IDByToken(ctx, token)
+	}
+	if err != nil {
+		s.log.Error(ctx, "authentication failed", "method", info.FullMethod, "err", err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	resp, err := handler(context.WithValue(ctx, authenticationContextKey, id), req)
+	if err != nil {
+		s.log.Warn(ctx, "rpc failed", "method", info.FullMethod, "err", err)
+	}
+	return resp, err
+}
+
+// authentication returns the id of the caller, or an Unauthenticated error
+// for anonymous calls.
+func authentication(ctx context.Context) (int64, error) {
+	id, _ := ctx.Value(authenticationContextKey).(int64)
+	if id == 0 {
+		return 0, status.Error(codes.Unauthenticated, "missing or invalid token")
+	}
+	return id, nil
+}
+
+// statusError maps the errors of the services to gRPC status codes.
+func statusError(err error) error {
+	var code codes.Code
+	switch err {
+	case customers.ErrNotFound, managers.ErrNotFound, categories.ErrNotFound, inventory.ErrNotFound, promotions.ErrNotFound, loyalty.ErrNotFound:
+		code = codes.NotFound
+	case customers.ErrNoSuchUser, customers.ErrInvalidPassword, managers.ErrNoSuchUser, managers.ErrInvalidPassword:
+		code = codes.Unauthenticated
+	case customers.ErrPhoneUsed, managers.ErrPhoneUsed, managers.ErrSKUDuplicated:
+		code = codes.AlreadyExists
+	case managers.ErrDiscountLimitExceeded:
+		code = codes.PermissionDenied
+	case managers.ErrInvalidPosition, managers.ErrProductInactive, managers.ErrInvalidDiscount, managers.ErrDiscountReasonRequired,
+		managers.ErrInvalidChannel, managers.ErrInvalidCategory, managers.ErrInvalidDiscountLimit, inventory.ErrInvalidMovement, promotions.ErrInvalidCode, loyalty.ErrInvalidPoints:
+		code = codes.InvalidArgument
+	case inventory.ErrInsufficientStock, promotions.ErrCodeExhausted, loyalty.ErrInsufficientPoints, managers.ErrCustomerInUse:
+		code = codes.FailedPrecondition
+	default:
+		code = codes.Internal
+	}
+	return status.Error(code, err.Error())
+}
+
+// categoryFilter resolves the category of a products request like the
+// category query parameter of the REST API.
+func (s *Server) categoryFilter(ctx context.Context, req *crudv1.ProductsRequest) ([]int64, error) {
+	if req.Category == "" {
+		return nil, nil
+	}
+	ids, err := s.categorySvc.Subtree(ctx, req.Category)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return ids, nil
+}
@@ -1,31 +1,84 @@
 package app
 
 import (
-	"encoding/json"
-	"log"
 	"net/http"
 
 	"github.com/ehsontjk/crud/cmd/app/middleware"
 
 	"github.com/gorilla/mux"
 
+	"github.com/ehsontjk/crud/pkg/blobstore"
+	"github.com/ehsontjk/crud/pkg/catalog"
+	"github.com/ehsontjk/crud/pkg/categories"
 	"github.com/ehsontjk/crud/pkg/customers"
+	"github.com/ehsontjk/crud/pkg/export"
+	"github.com/ehsontjk/crud/pkg/idempotency"
+	"github.com/ehsontjk/crud/pkg/images"
+	"github.com/ehsontjk/crud/pkg/inventory"
+	"github.com/ehsontjk/crud/pkg/live"
+	"github.com/ehsontjk/crud/pkg/logger"
+	"github.com/ehsontjk/crud/pkg/loyalty"
 	"github.com/ehsontjk/crud/pkg/managers"
+	"github.com/ehsontjk/crud/pkg/orders"
+	"github.com/ehsontjk/crud/pkg/productcache"
+	"github.com/ehsontjk/crud/pkg/promotions"
+	"github.com/ehsontjk/crud/pkg/receipts"
+	"github.com/ehsontjk/crud/pkg/returns"
+	"github.com/ehsontjk/crud/pkg/stores"
+	"github.com/ehsontjk/crud/pkg/webhooks"
 )
 
 
+// mediaPrefix is where a local blob store is served.
+const mediaPrefix = "/media"
+
+
 type Server struct {
-	mux         *mux.Router
-	customerSvc *customers.Service
-	managerSvc  *managers.Service
+	mux            *mux.Router
+	customerSvc    *customers.Service
+	managerSvc     *managers.Service
+	idempotencySvc *idempotency.Service
+	inventorySvc   *inventory.Service
+	promotionSvc   *promotions.Service
+	orderSvc       *orders.Service
+	returnSvc      *returns.Service
+	receiptSvc     *receipts.Service
+	catalogSvc     *catalog.Service
+	exportSvc      *export.Service
+	categorySvc    *categories.Service
+	imageSvc       *images.Service
+	storeSvc       *stores.Service
+	loyaltySvc     *loyalty.Service
+	webhookSvc     *webhooks.Service
+	liveHub        *live.Hub
+	productCache   *productcache.Cache
+	blobStore      blobstore.BlobStore
+	log            *logger.Logger
 }
 
 
-func NewServer(m *mux.Router, cSvc *customers.Service, mSvc *managers.Service) *Server {
+func NewServer(m *mux.Router, cSvc *customers.Service, mSvc *managers.Service, iSvc *idempotency.Service, invSvc *inventory.Service, pSvc *promotions.Service, oSvc *orders.Service, rSvc *returns.Service, recSvc *receipts.Service, catSvc *catalog.Service, eSvc *export.Service, catgSvc *categories.Service, imgSvc *images.Service, stSvc *stores.Service, lSvc *loyalty.Service, whSvc *webhooks.Service, hub *live.Hub, cache *productcache.Cache, store blobstore.BlobStore, log *logger.Logger) *Server {
 	return &Server{
-		mux:         m,
-		customerSvc: cSvc,
-		managerSvc:  mSvc,
+		mux:            m,
+		customerSvc:    cSvc,
+		managerSvc:     mSvc,
+		idempotencySvc: iSvc,
+		inventorySvc:   invSvc,
+		promotionSvc:   pSvc,
+		orderSvc:       oSvc,
+		returnSvc:      rSvc,
+		receiptSvc:     recSvc,
+		catalogSvc:     catSvc,
+		exportSvc:      eSvc,
+		categorySvc:    catgSvc,
+		imageSvc:       imgSvc,
+		storeSvc:       stSvc,
+		loyaltySvc:     lSvc,
+		webhookSvc:     whSvc,
+		liveHub:        hub,
+		productCache:   cache,
+		blobStore:      store,
+		log:            log,
 	}
 }
 
@@ -37,48 +90,134 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 func (s *Server) Init() {
 
-	customersAuthenticateMd := middleware.Authenticate(s.customerSvc.IDByToken)
+	s.mux.Use(middleware.RequestID)
+	s.mux.Use(middleware.Logging(s.log))
+
+	// stores that keep files locally serve them from here, others hand out their own URLs
+	if files, ok := s.blobStore.(http.Handler); ok {
+		s.mux.PathPrefix(mediaPrefix + "/").Handler(http.StripPrefix(mediaPrefix, files)).Methods("GET", "HEAD")
+	}
+
+	// v1 keeps its shapes and POST updates, and points every response at v2
+	customersAuthenticateMd := middleware.Authenticate(s.customerSvc.IDByToken, s.log)
 	customersSubrouter := s.mux.PathPrefix("/api/customers").Subrouter()
+	customersSubrouter.Use(middleware.Deprecated("/api/customers", v2CustomersPrefix))
 	customersSubrouter.Use(customersAuthenticateMd)
-
 	customersSubrouter.HandleFunc("", s.handleCustomerRegistration).Methods("POST")
 	customersSubrouter.HandleFunc("/token", s.handleCustomerGetToken).Methods("POST")
 	customersSubrouter.HandleFunc("/products", s.handleCustomerGetProducts).Methods("GET")
+	s.customerRoutes(customersSubrouter)
 
-	managersAuthenticateMd := middleware.Authenticate(s.managerSvc.IDByToken)
+	customersV2 := s.mux.PathPrefix(v2CustomersPrefix).Subrouter()
+	customersV2.Use(customersAuthenticateMd)
+	customersV2.HandleFunc("", s.handleCustomerRegistrationV2).Methods("POST")
+	customersV2.HandleFunc("/token", s.handleTokenV2(s.customerSvc.Token)).Methods("POST")
+	customersV2.HandleFunc("/products", s.handleCustomerGetProductsV2).Methods("GET")
+	s.customerRoutes(customersV2)
+
+	managersAuthenticateMd := middleware.Authenticate(s.managerSvc.IDByToken, s.log)
 	managersSubRouter := s.mux.PathPrefix("/api/managers").Subrouter()
+	managersSubRouter.Use(middleware.Deprecated("/api/managers", v2ManagersPrefix))
 	managersSubRouter.Use(managersAuthenticateMd)
-	managersSubRouter.HandleFunc("", s.handleManagerRegistration).Methods("POST")
 	managersSubRouter.HandleFunc("/token", s.handleManagerGetToken).Methods("POST")
-	managersSubRouter.HandleFunc("/sales", s.handleManagerGetSales).Methods("GET")
-	managersSubRouter.HandleFunc("/sales", s.handleManagerMakeSales).Methods("POST")
 	managersSubRouter.HandleFunc("/products", s.handleManagerGetProducts).Methods("GET")
 	managersSubRouter.HandleFunc("/products", s.handleManagerChangeProducts).Methods("POST")
-	managersSubRouter.HandleFunc("/products/{id:[0-9]+}", s.handleManagerRemoveProductByID).Methods("DELETE")
 	managersSubRouter.HandleFunc("/customers", s.handleManagerGetCustomers).Methods("GET")
 	managersSubRouter.HandleFunc("/customers", s.handleManagerChangeCustomer).Methods("POST")
-	managersSubRouter.HandleFunc("/customers/{id:[0-9]+}", s.handleManagerRemoveCustomerByID).Methods("DELETE")
+	s.managerRoutes(managersSubRouter)
+
+	managersV2 := s.mux.PathPrefix(v2ManagersPrefix).Subrouter()
+	managersV2.Use(managersAuthenticateMd)
+	managersV2.HandleFunc("/token", s.handleTokenV2(s.managerSvc.Token)).Methods("POST")
+	managersV2.HandleFunc("/products", s.handleManagerGetProductsV2).Methods("GET")
+	managersV2.HandleFunc("/products", s.handleManagerSaveProductV2).Methods("POST")
+	managersV2.HandleFunc("/products/{id:[0-9]+}", s.handleManagerGetProductV2).Methods("GET")
+	managersV2.HandleFunc("/products/{id:[0-9]+}", s.handleManagerSaveProductV2).Methods("PUT")
+	managersV2.HandleFunc("/products/{id:[0-9]+}", s.handleManagerPatchProductV2).Methods("PATCH")
+	managersV2.HandleFunc("/customers", s.handleManagerGetCustomersV2).Methods("GET")
+	managersV2.HandleFunc("/customers/{id:[0-9]+}", s.handleManagerGetCustomerV2).Methods("GET")
+	managersV2.HandleFunc("/customers/{id:[0-9]+}", s.handleManagerSaveCustomerV2).Methods("PUT", "PATCH")
+	s.managerRoutes(managersV2)
+}
+
+// customerRoutes are the customer routes that v1 and v2 share.
+func (s *Server) customerRoutes(router *mux.Router) {
+	router.HandleFunc("/categories", s.handleCustomerGetCategories).Methods("GET")
+	router.HandleFunc("/cart", s.handleCustomerGetCart).Methods("GET")
+	router.HandleFunc("/cart/items", s.handleCustomerAddToCart).Methods("POST")
+	router.HandleFunc("/cart/items/{id:[0-9]+}", s.handleCustomerUpdateCartItem).Methods("PUT")
+	router.HandleFunc("/cart/items/{id:[0-9]+}", s.handleCustomerRemoveCartItem).Methods("DELETE")
+	router.HandleFunc("/cart/checkout", s.idempotent(checkoutIdempotencyScope, s.handleCustomerCheckout)).Methods("POST")
+	router.HandleFunc("/orders", s.handleCustomerGetOrders).Methods("GET")
+	router.HandleFunc("/orders/{id:[0-9]+}", s.handleCustomerGetOrder).Methods("GET")
+	router.HandleFunc("/loyalty", s.handleCustomerGetLoyalty).Methods("GET")
+	router.HandleFunc("/loyalty/history", s.handleCustomerGetLoyaltyHistory).Methods("GET")
+}
 
+// managerRoutes are the manager routes that v1 and v2 share.
+func (s *Server) managerRoutes(router *mux.Router) {
+	router.HandleFunc("", s.handleManagerRegistration).Methods("POST")
+	router.HandleFunc("/sales", s.handleManagerGetSales).Methods("GET")
+	router.HandleFunc("/sales", s.idempotent(salesIdempotencyScope, s.handleManagerMakeSales)).Methods("POST")
+	router.HandleFunc("/sales/export", s.handleManagerExportSales).Methods("GET")
+	router.HandleFunc("/sales/{id:[0-9]+}/status", s.handleManagerGetSaleStatus).Methods("GET")
+	router.HandleFunc("/sales/{id:[0-9]+}/status", s.handleManagerAdvanceSale).Methods("POST")
+	router.HandleFunc("/sales/{id:[0-9]+}/returns", s.handleManagerGetReturns).Methods("GET")
+	router.HandleFunc("/sales/{id:[0-9]+}/returns", s.handleManagerMakeReturn).Methods("POST")
+	router.HandleFunc("/sales/{id:[0-9]+}/receipt", s.handleManagerGetReceipt).Methods("GET")
+	router.HandleFunc("/stream", s.handleManagerStream).Methods("GET")
+	router.HandleFunc("/products/low-stock", s.handleManagerGetLowStock).Methods("GET")
+	router.HandleFunc("/products/cache", s.handleManagerGetCacheStats).Methods("GET")
+	router.HandleFunc("/products/import", s.handleManagerImportProducts).Methods("POST")
+	router.HandleFunc("/products/export", s.handleManagerExportProducts).Methods("GET")
+	router.HandleFunc("/products/{id:[0-9]+}", s.handleManagerRemoveProductByID).Methods("DELETE")
+	router.HandleFunc("/products/{id:[0-9]+}/images", s.handleManagerGetProductImages).Methods("GET")
+	router.HandleFunc("/products/{id:[0-9]+}/images", s.handleManagerUploadProductImage).Methods("POST")
+	router.HandleFunc("/products/{id:[0-9]+}/images/{imageID:[0-9]+}", s.handleManagerRemoveProductImage).Methods("DELETE")
+	router.HandleFunc("/products/{id:[0-9]+}/movements", s.handleManagerGetMovements).Methods("GET")
+	router.HandleFunc("/products/{id:[0-9]+}/movements", s.handleManagerPostMovement).Methods("POST")
+	router.HandleFunc("/products/{id:[0-9]+}/stock", s.handleManagerGetProductStock).Methods("GET")
+	router.HandleFunc("/stores", s.handleManagerGetStores).Methods("GET")
+	router.HandleFunc("/stores", s.handleManagerSaveStore).Methods("POST")
+	router.HandleFunc("/stores/{id:[0-9]+}/managers", s.handleManagerAssignStore).Methods("POST")
+	router.HandleFunc("/transfers", s.handleManagerMakeTransfer).Methods("POST")
+	router.HandleFunc("/categories", s.handleManagerGetCategories).Methods("GET")
+	router.HandleFunc("/categories", s.handleManagerSaveCategory).Methods("POST")
+	router.HandleFunc("/categories/{id:[0-9]+}", s.handleManagerRemoveCategoryByID).Methods("DELETE")
+	router.HandleFunc("/webhooks", s.handleManagerGetWebhooks).Methods("GET")
+	router.HandleFunc("/webhooks", s.handleManagerSaveWebhook).Methods("POST")
+	router.HandleFunc("/webhooks/{id:[0-9]+}", s.handleManagerRemoveWebhook).Methods("DELETE")
+	router.HandleFunc("/webhooks/{id:[0-9]+}/deliveries", s.handleManagerGetWebhookDeliveries).Methods("GET")
+	router.HandleFunc("/webhooks/deliveries/{deliveryID:[0-9]+}/redeliver", s.handleManagerRedeliverWebhook).Methods("POST")
+	router.HandleFunc("/promotions", s.handleManagerGetPromotions).Methods("GET")
+	router.HandleFunc("/promotions", s.handleManagerSavePromotion).Methods("POST")
+	router.HandleFunc("/promotions/{id:[0-9]+}", s.handleManagerRemovePromotionByID).Methods("DELETE")
+	router.HandleFunc("/customers/{id:[0-9]+}", s.handleManagerRemoveCustomerByID).Methods("DELETE")
+	router.HandleFunc("/customers/{id:[0-9]+}/loyalty", s.handleManagerGetLoyalty).Methods("GET")
+	router.HandleFunc("/customers/{id:[0-9]+}/loyalty/adjustments", s.handleManagerAdjustLoyalty).Methods("POST")
 }
 
 
-func errorWriter(w http.ResponseWriter, httpSts int, err error) {
+func (s *Server) errorWriter(w http.ResponseWriter, r *http.Request, httpSts int, err error) {
 
-	log.Print(err)
+	if httpSts >= http.StatusInternalServerError {
+		s.log.Error(r.Context(), "request failed", "status", httpSts, "err", err)
+	} else {
+		s.log.Warn(r.Context(), "request rejected", "status", httpSts, "err", err)
+	}
 	
 	http.Error(w, http.StatusText(httpSts), httpSts)
 }
 
 
-func respondJSON(w http.ResponseWriter, iData interface{}) {
+func (s *Server) respondJSON(w http.ResponseWriter, r *http.Request, iData interface{}) {
 
-	
-	data, err := json.Marshal(iData)
+	data, err := marshalResponse(iData)
 
 	
 	if err != nil {
 		
-		errorWriter(w, http.StatusInternalServerError, err)
+		s.errorWriter(w, r, http.StatusInternalServerError, err)
 		return
 	}
 	
@@ -88,6 +227,6 @@ func respondJSON(w http.ResponseWriter, iData interface{}) {
 	
 	if err != nil {
 		
-		log.Print(err)
+		s.log.Error(r.Context(), "write response", "err", err)
 	}
 }
\ No newline at end of file
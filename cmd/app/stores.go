@@ -0,0 +1,177 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"github.com/ehsontjk/crud/cmd/app/middleware"
+	"github.com/ehsontjk/crud/pkg/inventory"
+	"github.com/ehsontjk/crud/pkg/stores"
+)
+
+func (s *Server) handleManagerGetStores(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if id == 0 {
+		s.errorWriter(w, r, http.StatusForbidden, err)
+		return
+	}
+
+	items, err := s.storeSvc.All(r.Context())
+	if err != nil {
+		s.errorWriter(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	s.respondJSON(w, r, items)
+}
+
+func (s *Server) handleManagerSaveStore(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if id == 0 || !s.managerSvc.IsAdmin(r.Context(), id) {
+		s.errorWriter(w, r, http.StatusForbidden, err)
+		return
+	}
+
+	item := &stores.Store{Active: true}
+	if err = json.NewDecoder(r.Body).Decode(&item); err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	item, err = s.storeSvc.Save(r.Context(), item)
+	switch err {
+	case nil:
+	case stores.ErrNotFound:
+		s.errorWriter(w, r, http.StatusNotFound, err)
+		return
+	case stores.ErrInvalidStore:
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	case stores.ErrDefaultStore:
+		s.errorWriter(w, r, http.StatusConflict, err)
+		return
+	default:
+		s.errorWriter(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	s.respondJSON(w, r, item)
+}
+
+func (s *Server) handleManagerAssignStore(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if id == 0 || !s.managerSvc.IsAdmin(r.Context(), id) {
+		s.errorWriter(w, r, http.StatusForbidden, err)
+		return
+	}
+
+	idParam, ok := mux.Vars(r)["id"]
+	if !ok {
+		s.errorWriter(w, r, http.StatusBadRequest, errors.New("Missing id"))
+		return
+	}
+	storeID, err := strconv.ParseInt(idParam, 10, 64)
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	var item struct {
+		ManagerID int64 `json:"manager_id"`
+	}
+	if err = json.NewDecoder(r.Body).Decode(&item); err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	err = s.storeSvc.AssignManager(r.Context(), storeID, item.ManagerID)
+	switch err {
+	case nil:
+	case stores.ErrNotFound:
+		s.errorWriter(w, r, http.StatusNotFound, err)
+	default:
+		s.errorWriter(w, r, http.StatusInternalServerError, err)
+	}
+}
+
+func (s *Server) handleManagerGetProductStock(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if id == 0 {
+		s.errorWriter(w, r, http.StatusForbidden, err)
+		return
+	}
+
+	idParam, ok := mux.Vars(r)["id"]
+	if !ok {
+		s.errorWriter(w, r, http.StatusBadRequest, errors.New("Missing id"))
+		return
+	}
+	productID, err := strconv.ParseInt(idParam, 10, 64)
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	items, err := s.inventorySvc.Stock(r.Context(), productID)
+	if err != nil {
+		s.errorWriter(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	s.respondJSON(w, r, items)
+}
+
+func (s *Server) handleManagerMakeTransfer(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if id == 0 {
+		s.errorWriter(w, r, http.StatusForbidden, err)
+		return
+	}
+
+	item := &inventory.StoreTransfer{}
+	if err = json.NewDecoder(r.Body).Decode(&item); err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+	item.ManagerID = id
+
+	item, err = s.inventorySvc.MoveBetweenStores(r.Context(), item)
+	switch err {
+	case nil:
+	case inventory.ErrNotFound:
+		s.errorWriter(w, r, http.StatusNotFound, err)
+		return
+	case inventory.ErrInvalidTransfer, inventory.ErrInvalidMovement, inventory.ErrInsufficientStock:
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	default:
+		s.errorWriter(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	s.respondJSON(w, r, item)
+}
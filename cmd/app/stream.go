@@ -0,0 +1,149 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/ehsontjk/crud/cmd/app/middleware"
+	"github.com/ehsontjk/crud/pkg/live"
+)
+
+const streamWriteTimeout = 10 * time.Second
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	// the stream is authenticated by token, not by cookie, so other origins
+	// gain nothing from connecting
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// handleManagerStream pushes the live feed to the caller, over WebSocket when
+// the request asks for an upgrade and as server-sent events otherwise.
+// Browsers cannot set headers on either, so the token may also come in the
+// token query parameter.
+func (s *Server) handleManagerStream(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if id == 0 {
+		if token := r.URL.Query().Get("token"); token != "" {
+			id, err = s.managerSvc.IDByToken(r.Context(), token)
+			if err != nil {
+				s.errorWriter(w, r, http.StatusInternalServerError, err)
+				return
+			}
+		}
+	}
+	if id == 0 {
+		s.errorWriter(w, r, http.StatusForbidden, err)
+		return
+	}
+
+	viewer, err := s.liveHub.Viewer(r.Context(), id)
+	switch err {
+	case nil:
+	case live.ErrNotFound:
+		s.errorWriter(w, r, http.StatusForbidden, err)
+		return
+	default:
+		s.errorWriter(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	if websocket.IsWebSocketUpgrade(r) {
+		s.streamWebSocket(w, r, viewer)
+		return
+	}
+	s.streamEvents(w, r, viewer)
+}
+
+func (s *Server) streamEvents(w http.ResponseWriter, r *http.Request, viewer *live.Viewer) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		s.errorWriter(w, r, http.StatusInternalServerError, fmt.Errorf("streaming is not supported"))
+		return
+	}
+
+	sub := s.liveHub.Subscribe(viewer)
+	defer s.liveHub.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(live.HeartbeatGap)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		case e := <-sub.C:
+			data, err := json.Marshal(e)
+			if err != nil {
+				s.log.Error(r.Context(), "encode live event", "err", err)
+				continue
+			}
+			if _, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+func (s *Server) streamWebSocket(w http.ResponseWriter, r *http.Request, viewer *live.Viewer) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader has already answered the client
+		s.log.Warn(r.Context(), "websocket upgrade failed", "err", err)
+		return
+	}
+	defer conn.Close()
+
+	sub := s.liveHub.Subscribe(viewer)
+	defer s.liveHub.Unsubscribe(sub)
+
+	// the feed is one way, reading only notices when the client goes away
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(live.HeartbeatGap)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case <-ping.C:
+			if err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(streamWriteTimeout)); err != nil {
+				return
+			}
+		case e := <-sub.C:
+			conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+			if err = conn.WriteJSON(e); err != nil {
+				return
+			}
+		}
+	}
+}
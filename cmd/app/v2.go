@@ -0,0 +1,477 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/ehsontjk/crud/cmd/app/middleware"
+	"github.com/ehsontjk/crud/pkg/customers"
+	"github.com/ehsontjk/crud/pkg/images"
+	"github.com/ehsontjk/crud/pkg/inventory"
+	"github.com/ehsontjk/crud/pkg/managers"
+	"github.com/ehsontjk/crud/pkg/stores"
+)
+
+// The /api/v2 routes represent every resource the same way whoever asks
+// for it, create with POST, replace with PUT and change single fields with
+// PATCH. Routes whose shapes did not change are shared with v1.
+const (
+	v2CustomersPrefix = "/api/v2/customers"
+	v2ManagersPrefix  = "/api/v2/managers"
+)
+
+// productResource is a product in /api/v2. Qty, Active and Created are
+// read only: stock changes through movements and products are deactivated
+// by DELETE.
+type productResource struct {
+	ID           int64                  `json:"id"`
+	SKU          string                 `json:"sku"`
+	CategoryID   int64                  `json:"category_id"`
+	Name         string                 `json:"name"`
+	Price        int                    `json:"price"`
+	Qty          int                    `json:"qty"`
+	ReorderLevel int                    `json:"reorder_level"`
+	Active       bool                   `json:"active"`
+	Created      time.Time              `json:"created"`
+	Images       []*images.Image        `json:"images"`
+	Availability []*stores.Availability `json:"availability"`
+}
+
+// productPatch holds the fields a PATCH sets, nil ones stay as they are.
+type productPatch struct {
+	SKU          *string `json:"sku"`
+	CategoryID   *int64  `json:"category_id"`
+	Name         *string `json:"name"`
+	Price        *int    `json:"price"`
+	ReorderLevel *int    `json:"reorder_level"`
+}
+
+type customerPatch struct {
+	Name   *string `json:"name"`
+	Phone  *string `json:"phone"`
+	Active *bool   `json:"active"`
+}
+
+// tokenRequest is how customers and managers sign in to /api/v2.
+type tokenRequest struct {
+	Phone    string `json:"phone"`
+	Password string `json:"password"`
+}
+
+func (s *Server) productResources(ctx context.Context, items []*managers.Product) ([]*productResource, error) {
+	productIDs := make([]int64, 0, len(items))
+	for _, item := range items {
+		productIDs = append(productIDs, item.ID)
+	}
+	byStore, err := s.storeSvc.Availability(ctx, productIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	resources := make([]*productResource, 0, len(items))
+	for _, item := range items {
+		resource := &productResource{
+			ID:           item.ID,
+			SKU:          item.SKU,
+			CategoryID:   item.CategoryID,
+			Name:         item.Name,
+			Price:        item.Price,
+			Qty:          item.Qty,
+			ReorderLevel: item.ReorderLevel,
+			Active:       item.Active,
+			Created:      item.Created,
+			Images:       item.Images,
+			Availability: byStore[item.ID],
+		}
+		if resource.Images == nil {
+			resource.Images = make([]*images.Image, 0)
+		}
+		if resource.Availability == nil {
+			resource.Availability = make([]*stores.Availability, 0)
+		}
+		resources = append(resources, resource)
+	}
+	return resources, nil
+}
+
+// respondCreated answers a POST that created the resource at location.
+func (s *Server) respondCreated(w http.ResponseWriter, r *http.Request, location string, item interface{}) {
+	data, err := marshalResponse(item)
+	if err != nil {
+		s.errorWriter(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if location != "" {
+		w.Header().Set("Location", location)
+	}
+	w.WriteHeader(http.StatusCreated)
+	if _, err = w.Write(data); err != nil {
+		s.log.Error(r.Context(), "write response", "err", err)
+	}
+}
+
+func resourceIDParam(r *http.Request) (int64, error) {
+	idParam, ok := mux.Vars(r)["id"]
+	if !ok {
+		return 0, errors.New("Missing id")
+	}
+	return strconv.ParseInt(idParam, 10, 64)
+}
+
+func (s *Server) handleTokenV2(token func(ctx context.Context, phone, password string) (string, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var item tokenRequest
+		if err := json.NewDecoder(r.Body).Decode(&item); err != nil {
+			s.errorWriter(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		tkn, err := token(r.Context(), item.Phone, item.Password)
+		switch err {
+		case nil:
+		case customers.ErrNoSuchUser, customers.ErrInvalidPassword, managers.ErrNoSuchUser, managers.ErrInvalidPassword:
+			s.errorWriter(w, r, http.StatusUnauthorized, err)
+			return
+		default:
+			s.errorWriter(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		s.respondJSON(w, r, &tokenResponse{Token: tkn})
+	}
+}
+
+func (s *Server) handleCustomerRegistrationV2(w http.ResponseWriter, r *http.Request) {
+	var item struct {
+		Name     string `json:"name"`
+		Phone    string `json:"phone"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&item); err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if item.Password == "" {
+		s.errorWriter(w, r, http.StatusBadRequest, errors.New("password is required"))
+		return
+	}
+
+	hashed, err := bcrypt.GenerateFromPassword([]byte(item.Password), bcrypt.DefaultCost)
+	if err != nil {
+		s.errorWriter(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	customer, err := s.customerSvc.Save(r.Context(), &customers.Customer{Name: item.Name, Phone: item.Phone, Password: string(hashed)})
+	if err == customers.ErrPhoneUsed {
+		s.errorWriter(w, r, http.StatusConflict, err)
+		return
+	}
+	if err != nil {
+		s.errorWriter(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	s.respondCreated(w, r, "", newCustomerResource(customer.ID, customer.Name, customer.Phone, customer.Active, customer.Created))
+}
+
+// handleCustomerGetProductsV2 lists the catalog as customers see it: active
+// products without skus, reorder levels and other internal fields.
+func (s *Server) handleCustomerGetProductsV2(w http.ResponseWriter, r *http.Request) {
+	categoryIDs, err := s.categoryFilter(r)
+	if err != nil {
+		s.categoryFilterError(w, r, err)
+		return
+	}
+
+	items, err := s.customerSvc.Products(r.Context(), categoryIDs)
+	if err != nil {
+		s.errorWriter(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	s.respondJSON(w, r, items)
+}
+
+func (s *Server) handleManagerGetProductsV2(w http.ResponseWriter, r *http.Request) {
+	categoryIDs, err := s.categoryFilter(r)
+	if err != nil {
+		s.categoryFilterError(w, r, err)
+		return
+	}
+
+	items, err := s.managerSvc.Products(r.Context(), categoryIDs)
+	if err != nil {
+		s.errorWriter(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	resources, err := s.productResources(r.Context(), items)
+	if err != nil {
+		s.errorWriter(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	s.respondJSON(w, r, resources)
+}
+
+// respondProductV2 answers with the product as saved, or with the status of
+// the error of saving it.
+func (s *Server) respondProductV2(w http.ResponseWriter, r *http.Request, item *managers.Product, err error, created bool) {
+	switch err {
+	case nil:
+	case managers.ErrNotFound:
+		s.errorWriter(w, r, http.StatusNotFound, err)
+		return
+	case inventory.ErrInvalidMovement, managers.ErrInvalidCategory:
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	case managers.ErrSKUDuplicated:
+		s.errorWriter(w, r, http.StatusConflict, err)
+		return
+	default:
+		s.errorWriter(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	resources, err := s.productResources(r.Context(), []*managers.Product{item})
+	if err != nil {
+		s.errorWriter(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	if created {
+		s.respondCreated(w, r, v2ManagersPrefix+"/products/"+strconv.FormatInt(item.ID, 10), resources[0])
+		return
+	}
+	s.respondJSON(w, r, resources[0])
+}
+
+func (s *Server) handleManagerGetProductV2(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if id == 0 {
+		s.errorWriter(w, r, http.StatusForbidden, err)
+		return
+	}
+
+	productID, err := resourceIDParam(r)
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	item, err := s.managerSvc.Product(r.Context(), productID)
+	s.respondProductV2(w, r, item, err, false)
+}
+
+// handleManagerSaveProductV2 creates a product on POST and replaces the
+// writable fields of one on PUT.
+func (s *Server) handleManagerSaveProductV2(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if id == 0 {
+		s.errorWriter(w, r, http.StatusForbidden, err)
+		return
+	}
+
+	var productID int64
+	if r.Method == http.MethodPut {
+		if productID, err = resourceIDParam(r); err != nil {
+			s.errorWriter(w, r, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	var item productResource
+	if err = json.NewDecoder(r.Body).Decode(&item); err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+	product := &managers.Product{
+		ID:           productID,
+		SKU:          item.SKU,
+		CategoryID:   item.CategoryID,
+		Name:         item.Name,
+		Price:        item.Price,
+		ReorderLevel: item.ReorderLevel,
+	}
+	// new products may come with their initial stock
+	if productID == 0 {
+		product.Qty = item.Qty
+	}
+
+	product, err = s.managerSvc.SaveProduct(r.Context(), id, product)
+	s.respondProductV2(w, r, product, err, productID == 0)
+}
+
+func (s *Server) handleManagerPatchProductV2(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if id == 0 {
+		s.errorWriter(w, r, http.StatusForbidden, err)
+		return
+	}
+
+	productID, err := resourceIDParam(r)
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+	var patch productPatch
+	if err = json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	product, err := s.managerSvc.Product(r.Context(), productID)
+	if err != nil {
+		s.respondProductV2(w, r, nil, err, false)
+		return
+	}
+	if patch.SKU != nil {
+		product.SKU = *patch.SKU
+	}
+	if patch.CategoryID != nil {
+		product.CategoryID = *patch.CategoryID
+	}
+	if patch.Name != nil {
+		product.Name = *patch.Name
+	}
+	if patch.Price != nil {
+		product.Price = *patch.Price
+	}
+	if patch.ReorderLevel != nil {
+		product.ReorderLevel = *patch.ReorderLevel
+	}
+
+	product, err = s.managerSvc.SaveProduct(r.Context(), id, product)
+	s.respondProductV2(w, r, product, err, false)
+}
+
+func (s *Server) respondCustomerV2(w http.ResponseWriter, r *http.Request, item *managers.Customer, err error) {
+	switch err {
+	case nil:
+		s.respondJSON(w, r, newCustomerResource(item.ID, item.Name, item.Phone, item.Active, item.Created))
+	case managers.ErrNotFound:
+		s.errorWriter(w, r, http.StatusNotFound, err)
+	case managers.ErrPhoneUsed:
+		s.errorWriter(w, r, http.StatusConflict, err)
+	default:
+		s.errorWriter(w, r, http.StatusInternalServerError, err)
+	}
+}
+
+func (s *Server) handleManagerGetCustomersV2(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if id == 0 {
+		s.errorWriter(w, r, http.StatusForbidden, err)
+		return
+	}
+
+	items, err := s.managerSvc.Customers(r.Context())
+	if err != nil {
+		s.errorWriter(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	resources := make([]*customerResource, 0, len(items))
+	for _, item := range items {
+		resources = append(resources, newCustomerResource(item.ID, item.Name, item.Phone, item.Active, item.Created))
+	}
+	s.respondJSON(w, r, resources)
+}
+
+func (s *Server) handleManagerGetCustomerV2(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if id == 0 {
+		s.errorWriter(w, r, http.StatusForbidden, err)
+		return
+	}
+
+	customerID, err := resourceIDParam(r)
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	item, err := s.managerSvc.Customer(r.Context(), customerID)
+	s.respondCustomerV2(w, r, item, err)
+}
+
+// handleManagerSaveCustomerV2 replaces a customer on PUT and changes the
+// given fields on PATCH.
+func (s *Server) handleManagerSaveCustomerV2(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if id == 0 {
+		s.errorWriter(w, r, http.StatusForbidden, err)
+		return
+	}
+
+	customerID, err := resourceIDParam(r)
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	var customer *managers.Customer
+	if r.Method == http.MethodPatch {
+		var patch customerPatch
+		if err = json.NewDecoder(r.Body).Decode(&patch); err != nil {
+			s.errorWriter(w, r, http.StatusBadRequest, err)
+			return
+		}
+		if customer, err = s.managerSvc.Customer(r.Context(), customerID); err != nil {
+			s.respondCustomerV2(w, r, nil, err)
+			return
+		}
+		if patch.Name != nil {
+			customer.Name = *patch.Name
+		}
+		if patch.Phone != nil {
+			customer.Phone = *patch.Phone
+		}
+		if patch.Active != nil {
+			customer.Active = *patch.Active
+		}
+	} else {
+		var item customerResource
+		if err = json.NewDecoder(r.Body).Decode(&item); err != nil {
+			s.errorWriter(w, r, http.StatusBadRequest, err)
+			return
+		}
+		customer = &managers.Customer{Name: item.Name, Phone: item.Phone, Active: item.Active}
+	}
+	customer.ID = customerID
+
+	customer, err = s.managerSvc.ChangeCustomer(r.Context(), customer)
+	s.respondCustomerV2(w, r, customer, err)
+}
@@ -0,0 +1,147 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"github.com/ehsontjk/crud/cmd/app/middleware"
+	"github.com/ehsontjk/crud/pkg/webhooks"
+)
+
+func (s *Server) respondWebhook(w http.ResponseWriter, r *http.Request, item interface{}, err error) {
+	switch err {
+	case nil:
+		if item != nil {
+			s.respondJSON(w, r, item)
+		}
+	case webhooks.ErrNotFound:
+		s.errorWriter(w, r, http.StatusNotFound, err)
+	case webhooks.ErrInvalidURL, webhooks.ErrInvalidEvent:
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+	default:
+		s.errorWriter(w, r, http.StatusInternalServerError, err)
+	}
+}
+
+func webhookIDParam(r *http.Request, name string) (int64, error) {
+	idParam, ok := mux.Vars(r)[name]
+	if !ok {
+		return 0, errors.New("Missing id")
+	}
+	return strconv.ParseInt(idParam, 10, 64)
+}
+
+func (s *Server) handleManagerGetWebhooks(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if id == 0 || !s.managerSvc.IsAdmin(r.Context(), id) {
+		s.errorWriter(w, r, http.StatusForbidden, err)
+		return
+	}
+
+	items, err := s.webhookSvc.Subscriptions(r.Context())
+	if err != nil {
+		s.respondWebhook(w, r, nil, err)
+		return
+	}
+	resources := make([]*subscriptionResponse, 0, len(items))
+	for _, item := range items {
+		resources = append(resources, newSubscriptionResponse(item))
+	}
+	s.respondWebhook(w, r, resources, nil)
+}
+
+func (s *Server) handleManagerSaveWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if id == 0 || !s.managerSvc.IsAdmin(r.Context(), id) {
+		s.errorWriter(w, r, http.StatusForbidden, err)
+		return
+	}
+
+	item := &webhooks.Subscription{Active: true}
+	if err = json.NewDecoder(r.Body).Decode(&item); err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	item, err = s.webhookSvc.Save(r.Context(), item)
+	if err != nil {
+		s.respondWebhook(w, r, nil, err)
+		return
+	}
+	s.respondWebhook(w, r, newSubscriptionResponse(item), nil)
+}
+
+func (s *Server) handleManagerRemoveWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if id == 0 || !s.managerSvc.IsAdmin(r.Context(), id) {
+		s.errorWriter(w, r, http.StatusForbidden, err)
+		return
+	}
+
+	subscriptionID, err := webhookIDParam(r, "id")
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	err = s.webhookSvc.Remove(r.Context(), subscriptionID)
+	s.respondWebhook(w, r, nil, err)
+}
+
+func (s *Server) handleManagerGetWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if id == 0 || !s.managerSvc.IsAdmin(r.Context(), id) {
+		s.errorWriter(w, r, http.StatusForbidden, err)
+		return
+	}
+
+	subscriptionID, err := webhookIDParam(r, "id")
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	items, err := s.webhookSvc.Deliveries(r.Context(), subscriptionID)
+	s.respondWebhook(w, r, items, err)
+}
+
+func (s *Server) handleManagerRedeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := middleware.Authentication(r.Context())
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if id == 0 || !s.managerSvc.IsAdmin(r.Context(), id) {
+		s.errorWriter(w, r, http.StatusForbidden, err)
+		return
+	}
+
+	deliveryID, err := webhookIDParam(r, "deliveryID")
+	if err != nil {
+		s.errorWriter(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	err = s.webhookSvc.Redeliver(r.Context(), deliveryID)
+	s.respondWebhook(w, r, nil, err)
+}
@@ -0,0 +1,452 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ehsontjk/crud/pkg/catalog"
+	"github.com/ehsontjk/crud/pkg/customers"
+	"github.com/ehsontjk/crud/pkg/export"
+	"github.com/ehsontjk/crud/pkg/managers"
+	"github.com/ehsontjk/crud/pkg/migrations"
+	"github.com/ehsontjk/crud/pkg/seed"
+)
+
+const dateLayout = "2006-01-02"
+
+var (
+	errAdminExists  = errors.New("an admin already exists, use managers create -admin")
+	errImportFailed = errors.New("import rejected, nothing was changed")
+)
+
+// createdManager is what the create commands print. The token lets the new
+// manager sign in right away, with or without a password.
+type createdManager struct {
+	ID      int64  `json:"id"`
+	Name    string `json:"name"`
+	Phone   string `json:"phone"`
+	IsAdmin bool   `json:"is_admin"`
+	Token   string `json:"token"`
+}
+
+func (c *cli) createManager(ctx context.Context, item *managers.Manager) error {
+	return c.container.Invoke(func(managerSvc *managers.Service) error {
+		token, err := managerSvc.Create(ctx, item)
+		if err != nil {
+			return err
+		}
+		created := &createdManager{ID: item.ID, Name: item.Name, Phone: item.Phone, IsAdmin: item.IsAdmin, Token: token}
+		return c.print(created, func(w io.Writer) {
+			fmt.Fprintf(w, "created manager %d (%s, %s), admin: %s\n", created.ID, created.Name, created.Phone, yesNo(created.IsAdmin))
+			fmt.Fprintf(w, "token: %s\n", created.Token)
+		})
+	})
+}
+
+func adminCreate(ctx context.Context, c *cli, args []string) error {
+	flags := c.flags("admin create")
+	name := flags.String("name", "", "")
+	phone := flags.String("phone", "", "")
+	password := flags.String("password", "", "")
+	if rest, err := parse(flags, args); err != nil || len(rest) > 0 || *name == "" || *phone == "" {
+		return errUsage
+	}
+
+	return c.container.Invoke(func(managerSvc *managers.Service) error {
+		exists, err := managerSvc.HasAdmin(ctx)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return errAdminExists
+		}
+		if *password, err = c.password(*password); err != nil {
+			return err
+		}
+		return c.createManager(ctx, &managers.Manager{Name: *name, Phone: *phone, Password: *password, IsAdmin: true})
+	})
+}
+
+func managersList(ctx context.Context, c *cli, args []string) error {
+	flags := c.flags("managers list")
+	if rest, err := parse(flags, args); err != nil || len(rest) > 0 {
+		return errUsage
+	}
+
+	return c.container.Invoke(func(managerSvc *managers.Service) error {
+		items, err := managerSvc.Managers(ctx)
+		if err != nil {
+			return err
+		}
+		return c.print(items, func(w io.Writer) {
+			fmt.Fprintln(w, "ID\tNAME\tPHONE\tADMIN\tACTIVE\tSTORE\tDISCOUNT LIMIT\tCREATED")
+			for _, item := range items {
+				fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%d\t%d%%\t%s\n", item.ID, item.Name, item.Phone, yesNo(item.IsAdmin),
+					yesNo(item.Active), item.StoreID, item.DiscountLimit, item.Created.Format(dateLayout))
+			}
+		})
+	})
+}
+
+func managersCreate(ctx context.Context, c *cli, args []string) error {
+	flags := c.flags("managers create")
+	item := &managers.Manager{}
+	flags.StringVar(&item.Name, "name", "", "")
+	flags.StringVar(&item.Phone, "phone", "", "")
+	flags.StringVar(&item.Password, "password", "", "")
+	flags.BoolVar(&item.IsAdmin, "admin", false, "")
+	flags.IntVar(&item.DiscountLimit, "discount-limit", 0, "")
+	flags.Int64Var(&item.StoreID, "store", 0, "")
+	if rest, err := parse(flags, args); err != nil || len(rest) > 0 || item.Name == "" || item.Phone == "" {
+		return errUsage
+	}
+	return c.createManager(ctx, item)
+}
+
+// idArg returns the single positional id of a command.
+func idArg(rest []string) (int64, error) {
+	if len(rest) != 1 {
+		return 0, errUsage
+	}
+	id, err := strconv.ParseInt(rest[0], 10, 64)
+	if err != nil || id <= 0 {
+		return 0, errUsage
+	}
+	return id, nil
+}
+
+// done is printed by commands that only change something.
+type done struct {
+	ID      int64  `json:"id"`
+	Message string `json:"message"`
+}
+
+func (c *cli) done(id int64, message string) error {
+	return c.print(&done{ID: id, Message: message}, func(w io.Writer) {
+		fmt.Fprintln(w, message)
+	})
+}
+
+func setManagerActive(ctx context.Context, c *cli, args []string, active bool) error {
+	flags := c.flags("managers")
+	rest, err := parse(flags, args)
+	if err != nil {
+		return err
+	}
+	id, err := idArg(rest)
+	if err != nil {
+		return err
+	}
+
+	return c.container.Invoke(func(managerSvc *managers.Service) error {
+		if err := managerSvc.SetActive(ctx, id, active); err != nil {
+			return err
+		}
+		if active {
+			return c.done(id, fmt.Sprintf("manager %d activated", id))
+		}
+		return c.done(id, fmt.Sprintf("manager %d deactivated, tokens revoked", id))
+	})
+}
+
+func managersDeactivate(ctx context.Context, c *cli, args []string) error {
+	return setManagerActive(ctx, c, args, false)
+}
+
+func managersActivate(ctx context.Context, c *cli, args []string) error {
+	return setManagerActive(ctx, c, args, true)
+}
+
+// passwordArgs reads the id and the password of the password commands.
+func (c *cli) passwordArgs(name string, args []string) (int64, string, error) {
+	flags := c.flags(name)
+	password := flags.String("password", "", "")
+	rest, err := parse(flags, args)
+	if err != nil {
+		return 0, "", err
+	}
+	id, err := idArg(rest)
+	if err != nil {
+		return 0, "", err
+	}
+	given, err := c.password(*password)
+	return id, given, err
+}
+
+func managersPassword(ctx context.Context, c *cli, args []string) error {
+	id, password, err := c.passwordArgs("managers password", args)
+	if err != nil {
+		return err
+	}
+	return c.container.Invoke(func(managerSvc *managers.Service) error {
+		if err := managerSvc.SetPassword(ctx, id, password); err != nil {
+			return err
+		}
+		return c.done(id, fmt.Sprintf("password of manager %d changed", id))
+	})
+}
+
+func customersPassword(ctx context.Context, c *cli, args []string) error {
+	id, password, err := c.passwordArgs("customers password", args)
+	if err != nil {
+		return err
+	}
+	return c.container.Invoke(func(customerSvc *customers.Service) error {
+		if err := customerSvc.SetPassword(ctx, id, password); err != nil {
+			return err
+		}
+		return c.done(id, fmt.Sprintf("password of customer %d changed", id))
+	})
+}
+
+type revoked struct {
+	ManagerID  int64 `json:"manager_id,omitempty"`
+	CustomerID int64 `json:"customer_id,omitempty"`
+	Revoked    int64 `json:"revoked"`
+}
+
+func tokensRevoke(ctx context.Context, c *cli, args []string) error {
+	flags := c.flags("tokens revoke")
+	result := &revoked{}
+	flags.Int64Var(&result.ManagerID, "manager", 0, "")
+	flags.Int64Var(&result.CustomerID, "customer", 0, "")
+	if rest, err := parse(flags, args); err != nil || len(rest) > 0 {
+		return errUsage
+	}
+	if (result.ManagerID == 0) == (result.CustomerID == 0) {
+		return errUsage
+	}
+
+	return c.container.Invoke(func(managerSvc *managers.Service, customerSvc *customers.Service) (err error) {
+		who := ""
+		if result.ManagerID != 0 {
+			result.Revoked, err = managerSvc.RevokeTokens(ctx, result.ManagerID)
+			who = fmt.Sprintf("manager %d", result.ManagerID)
+		} else {
+			result.Revoked, err = customerSvc.RevokeTokens(ctx, result.CustomerID)
+			who = fmt.Sprintf("customer %d", result.CustomerID)
+		}
+		if err != nil {
+			return err
+		}
+		return c.print(result, func(w io.Writer) {
+			fmt.Fprintf(w, "revoked %d tokens of %s\n", result.Revoked, who)
+		})
+	})
+}
+
+func (c *cli) printMigrations(items []*migrations.Migration, empty string) error {
+	return c.print(items, func(w io.Writer) {
+		if len(items) == 0 {
+			fmt.Fprintln(w, empty)
+			return
+		}
+		fmt.Fprintln(w, "NAME\tAPPLIED\tNOTE")
+		for _, item := range items {
+			applied, note := "pending", ""
+			if item.Applied != nil {
+				applied = item.Applied.Format(time.RFC3339)
+			}
+			if item.Changed {
+				note = "changed since applied"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", item.Name, applied, note)
+		}
+	})
+}
+
+func migrate(ctx context.Context, c *cli, args []string) error {
+	flags := c.flags("migrate")
+	dir := flags.String("dir", migrations.DefaultDir, "")
+	if rest, err := parse(flags, args); err != nil || len(rest) > 0 {
+		return errUsage
+	}
+	return c.container.Invoke(func(migrationSvc *migrations.Service) error {
+		items, err := migrationSvc.Apply(ctx, *dir)
+		if printErr := c.printMigrations(items, "nothing to apply"); err == nil {
+			err = printErr
+		}
+		return err
+	})
+}
+
+func migrateStatus(ctx context.Context, c *cli, args []string) error {
+	flags := c.flags("migrate status")
+	dir := flags.String("dir", migrations.DefaultDir, "")
+	if rest, err := parse(flags, args); err != nil || len(rest) > 0 {
+		return errUsage
+	}
+	return c.container.Invoke(func(migrationSvc *migrations.Service) error {
+		items, err := migrationSvc.List(ctx, *dir)
+		if err != nil {
+			return err
+		}
+		return c.printMigrations(items, "no migrations")
+	})
+}
+
+func importProducts(ctx context.Context, c *cli, args []string) error {
+	flags := c.flags("import products")
+	path := flags.String("file", "", "")
+	format := flags.String("format", "", "")
+	dryRun := flags.Bool("dry-run", false, "")
+	managerID := flags.Int64("manager", 0, "")
+	if rest, err := parse(flags, args); err != nil || len(rest) > 0 || *path == "" {
+		return errUsage
+	}
+	if *format == "" {
+		*format = catalog.FormatCSV
+		if strings.EqualFold(filepath.Ext(*path), ".xlsx") {
+			*format = catalog.FormatXLSX
+		}
+	}
+
+	file, err := os.Open(*path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	rows, err := catalog.ReadRows(*format, file, info.Size())
+	if err != nil {
+		return err
+	}
+
+	return c.container.Invoke(func(catalogSvc *catalog.Service) error {
+		report, err := catalogSvc.Import(ctx, *managerID, rows, *dryRun)
+		if err != nil {
+			return err
+		}
+		err = c.print(report, func(w io.Writer) {
+			if report.DryRun {
+				fmt.Fprintln(w, "dry run, nothing was changed")
+			}
+			fmt.Fprintf(w, "rows:\t%d\n", report.Rows)
+			fmt.Fprintf(w, "created:\t%d\n", report.Created)
+			fmt.Fprintf(w, "updated:\t%d\n", report.Updated)
+			fmt.Fprintf(w, "stock changed:\t%d\n", report.StockChanged)
+			for _, e := range report.Errors {
+				fmt.Fprintf(w, "line %d: %s %s\n", e.Line, e.Field, e.Message)
+			}
+		})
+		if err == nil && len(report.Errors) > 0 && !report.DryRun {
+			err = errImportFailed
+		}
+		return err
+	})
+}
+
+// output returns the file to write an export to, stdout when path is empty.
+func output(path string) (io.WriteCloser, error) {
+	if path == "" {
+		return os.Stdout, nil
+	}
+	return os.Create(path)
+}
+
+func exportProducts(ctx context.Context, c *cli, args []string) error {
+	flags := c.flags("export products")
+	format := flags.String("format", catalog.FormatCSV, "")
+	path := flags.String("o", "", "")
+	if rest, err := parse(flags, args); err != nil || len(rest) > 0 {
+		return errUsage
+	}
+
+	return c.container.Invoke(func(catalogSvc *catalog.Service) error {
+		out, err := output(*path)
+		if err != nil {
+			return err
+		}
+		if err = catalogSvc.Export(ctx, out, *format); err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+	})
+}
+
+func exportSales(ctx context.Context, c *cli, args []string) error {
+	flags := c.flags("export sales")
+	fromParam := flags.String("from", "", "")
+	toParam := flags.String("to", "", "")
+	format := flags.String("format", export.FormatCSV, "")
+	path := flags.String("o", "", "")
+	if rest, err := parse(flags, args); err != nil || len(rest) > 0 {
+		return errUsage
+	}
+
+	// dates cover whole days, as in the REST API
+	from, err := time.Parse(dateLayout, *fromParam)
+	if err != nil {
+		return errUsage
+	}
+	to, err := time.Parse(dateLayout, *toParam)
+	if err != nil {
+		return errUsage
+	}
+	to = to.AddDate(0, 0, 1)
+
+	return c.container.Invoke(func(exportSvc *export.Service) error {
+		out, err := output(*path)
+		if err != nil {
+			return err
+		}
+		if err = exportSvc.Sales(ctx, out, *format, from, to, nil); err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+	})
+}
+
+func seedData(ctx context.Context, c *cli, args []string) error {
+	flags := c.flags("seed")
+	cfg := seed.Config{}
+	flags.Int64Var(&cfg.Seed, "seed", seed.DefaultSeed, "")
+	flags.IntVar(&cfg.Customers, "customers", seed.DefaultCustomers, "")
+	flags.IntVar(&cfg.Managers, "managers", seed.DefaultManagers, "")
+	flags.IntVar(&cfg.Products, "products", seed.DefaultProducts, "")
+	flags.IntVar(&cfg.Sales, "sales", seed.DefaultSales, "")
+	flags.IntVar(&cfg.Stores, "stores", seed.DefaultStores, "")
+	flags.IntVar(&cfg.Years, "years", seed.DefaultYears, "")
+	flags.StringVar(&cfg.Password, "password", seed.DefaultPassword, "")
+	untilParam := flags.String("until", time.Now().UTC().Format(dateLayout), "")
+	if rest, err := parse(flags, args); err != nil || len(rest) > 0 {
+		return errUsage
+	}
+	until, err := time.Parse(dateLayout, *untilParam)
+	if err != nil {
+		return errUsage
+	}
+	cfg.Until = until
+
+	return c.container.Invoke(func(seedSvc *seed.Service) error {
+		report, err := seedSvc.Run(ctx, cfg)
+		if err != nil {
+			return err
+		}
+		names := make([]string, 0, len(report.Rows))
+		for name := range report.Rows {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return c.print(report, func(w io.Writer) {
+			fmt.Fprintf(w, "seed %d, sales from %s until %s\n", report.Seed, report.From.Format(dateLayout), report.Until.Format(dateLayout))
+			fmt.Fprintln(w, "TABLE\tROWS")
+			for _, name := range names {
+				fmt.Fprintf(w, "%s\t%d\n", name, report.Rows[name])
+			}
+		})
+	})
+}
@@ -0,0 +1,13 @@
+create table if not exists idempotency_keys
+(
+    scope        text not null,
+    owner_id     bigint not null,
+    key          text not null,
+    request_hash text not null,
+    status       integer,
+    content_type text not null default '',
+    response     bytea not null default '',
+    expire       timestamp not null,
+    created      timestamp not null default current_timestamp,
+    primary key (scope, owner_id, key)
+);
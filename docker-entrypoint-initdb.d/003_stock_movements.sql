@@ -0,0 +1,14 @@
+create table if not exists stock_movements
+(
+    id          bigserial primary key,
+    product_id  bigint not null references products,
+    kind        text not null check(kind in ('receipt', 'sale', 'return', 'adjustment', 'write_off')),
+    qty         integer not null check(qty <> 0),
+    balance     integer not null check(balance >= 0),
+    manager_id  bigint references managers,
+    sale_id     bigint references sales,
+    reason      text not null default '',
+    created     timestamp not null default current_timestamp
+);
+
+create index if not exists stock_movements_product_idx on stock_movements(product_id, id);
@@ -0,0 +1,2 @@
+alter table products add column if not exists reorder_level integer not null default 0 check(reorder_level >= 0);
+alter table products add column if not exists low_stock_notified boolean not null default false;
@@ -0,0 +1,12 @@
+alter table managers add column if not exists discount_limit integer not null default 0 check(discount_limit between 0 and 100);
+
+create table if not exists sale_discounts
+(
+    id          bigserial primary key,
+    sale_id     bigint not null references sales,
+    product_id  bigint references products,
+    amount      integer not null check(amount > 0),
+    reason      text not null check(reason <> ''),
+    manager_id  bigint references managers,
+    created     timestamp not null default current_timestamp
+);
@@ -0,0 +1,34 @@
+alter table sales add column if not exists gross integer not null default 0;
+alter table sales add column if not exists discount integer not null default 0;
+alter table sales add column if not exists total integer not null default 0;
+
+-- earlier sales were sold at the listed prices without discounts
+update sales s set gross = p.gross, total = p.gross
+from (select sale_id, sum(price * qty) as gross from sales_positions group by sale_id) p
+where p.sale_id = s.id and s.gross = 0 and s.total = 0;
+
+create table if not exists promotions
+(
+    id          bigserial primary key,
+    code        text unique,
+    name        text not null,
+    kind        text not null check(kind in ('percent', 'fixed', 'buy_x_get_y')),
+    value       integer not null default 0 check(value >= 0),
+    product_id  bigint references products,
+    buy_qty     integer not null default 0,
+    get_qty     integer not null default 0,
+    starts      timestamp,
+    ends        timestamp,
+    usage_limit integer check(usage_limit > 0),
+    used        integer not null default 0,
+    active      boolean not null default true,
+    created     timestamp not null default current_timestamp
+);
+
+create table if not exists sale_promotions
+(
+    sale_id      bigint not null references sales,
+    promotion_id bigint not null references promotions,
+    amount       integer not null check(amount > 0),
+    primary key (sale_id, promotion_id)
+);
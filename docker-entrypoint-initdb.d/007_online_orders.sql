@@ -0,0 +1,12 @@
+alter table sales alter column manager_id drop not null;
+alter table sales add column if not exists channel text not null default 'pos' check(channel in ('pos', 'online'));
+
+create table if not exists cart_items
+(
+    customer_id bigint not null references customers,
+    product_id  bigint not null references products,
+    qty         integer not null check(qty > 0),
+    created     timestamp not null default current_timestamp,
+    updated     timestamp not null default current_timestamp,
+    primary key (customer_id, product_id)
+);
@@ -0,0 +1,15 @@
+-- sales made before statuses existed were handed over at the counter
+alter table sales add column if not exists status text not null default 'completed' check(status in ('pending', 'paid', 'fulfilled', 'completed', 'cancelled'));
+alter table sales alter column status set default 'pending';
+
+create table if not exists sale_status_history
+(
+    id          bigserial primary key,
+    sale_id     bigint not null references sales,
+    status      text not null,
+    manager_id  bigint references managers,
+    customer_id bigint references customers,
+    created     timestamp not null default current_timestamp
+);
+
+create index if not exists sale_status_history_sale_idx on sale_status_history(sale_id, id);
@@ -0,0 +1,23 @@
+alter table sales add column if not exists refunded integer not null default 0;
+
+create table if not exists returns
+(
+    id          bigserial primary key,
+    sale_id     bigint not null references sales,
+    manager_id  bigint references managers,
+    reason      text not null,
+    refund      integer not null default 0 check(refund >= 0),
+    created     timestamp not null default current_timestamp
+);
+
+create table if not exists return_positions
+(
+    id          bigserial primary key,
+    return_id   bigint not null references returns,
+    position_id bigint not null references sales_positions,
+    product_id  bigint not null references products,
+    qty         integer not null check(qty > 0),
+    refund      integer not null default 0 check(refund >= 0)
+);
+
+create index if not exists return_positions_position_idx on return_positions(position_id);
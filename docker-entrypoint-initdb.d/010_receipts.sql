@@ -0,0 +1,8 @@
+alter table sales add column if not exists receipt_no bigint unique;
+
+create table if not exists receipt_counter
+(
+    last bigint not null default 0
+);
+
+insert into receipt_counter(last) select 0 where not exists (select 1 from receipt_counter);
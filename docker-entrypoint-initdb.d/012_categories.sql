@@ -0,0 +1,18 @@
+create table if not exists categories
+(
+    id          bigserial primary key,
+    parent_id   bigint references categories,
+    name        text not null,
+    slug        text not null unique,
+    position    integer not null default 0,
+    active      boolean not null default true,
+    created     timestamp not null default current_timestamp
+);
+
+create index if not exists categories_parent_idx on categories(parent_id);
+
+alter table products add column if not exists category_id bigint references categories;
+
+create index if not exists products_category_idx on products(category_id);
+
+alter table promotions add column if not exists category_id bigint references categories;
@@ -0,0 +1,11 @@
+create table if not exists product_images
+(
+    id           bigserial primary key,
+    product_id   bigint not null references products,
+    content_type text not null,
+    width        integer not null check(width > 0),
+    height       integer not null check(height > 0),
+    created      timestamp not null default current_timestamp
+);
+
+create index if not exists product_images_product_idx on product_images(product_id, id);
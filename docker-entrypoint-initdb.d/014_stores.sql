@@ -0,0 +1,52 @@
+create table if not exists stores
+(
+    id          bigserial primary key,
+    name        text not null,
+    address     text not null default '',
+    is_default  boolean not null default false,
+    active      boolean not null default true,
+    created     timestamp not null default current_timestamp
+);
+
+create unique index if not exists stores_default_idx on stores(is_default) where is_default;
+
+insert into stores(name, is_default) select 'Main store', true where not exists (select 1 from stores);
+
+alter table managers add column if not exists store_id bigint references stores;
+
+create table if not exists store_stock
+(
+    store_id    bigint not null references stores,
+    product_id  bigint not null references products,
+    qty         integer not null default 0 check(qty >= 0),
+    primary key (store_id, product_id)
+);
+
+create index if not exists store_stock_product_idx on store_stock(product_id);
+
+-- the stock counted so far is in the default store
+insert into store_stock(store_id, product_id, qty)
+select s.id, p.id, p.qty from products p, stores s
+where s.is_default and p.qty > 0 and not exists (select 1 from store_stock where product_id = p.id)
+on conflict do nothing;
+
+alter table sales add column if not exists store_id bigint references stores;
+
+create table if not exists store_transfers
+(
+    id            bigserial primary key,
+    from_store_id bigint not null references stores,
+    to_store_id   bigint not null references stores check(to_store_id <> from_store_id),
+    manager_id    bigint references managers,
+    reason        text not null default '',
+    created       timestamp not null default current_timestamp
+);
+
+-- earlier movements all happened in what is now the default store
+alter table stock_movements add column if not exists store_id bigint references stores;
+update stock_movements set store_id = (select id from stores where is_default) where store_id is null;
+alter table stock_movements alter column store_id set not null;
+alter table stock_movements add column if not exists transfer_id bigint references store_transfers;
+alter table stock_movements drop constraint if exists stock_movements_kind_check;
+alter table stock_movements add constraint stock_movements_kind_check
+    check(kind in ('receipt', 'sale', 'return', 'adjustment', 'write_off', 'transfer'));
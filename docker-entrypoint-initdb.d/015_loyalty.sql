@@ -0,0 +1,26 @@
+alter table sales add column if not exists points_redeemed integer not null default 0 check(points_redeemed >= 0);
+alter table sales add column if not exists points_earned integer not null default 0 check(points_earned >= 0);
+
+create table if not exists loyalty_accounts
+(
+    customer_id bigint primary key references customers on delete restrict,
+    points      integer not null default 0 check(points >= 0)
+);
+
+create table if not exists loyalty_entries
+(
+    id          bigserial primary key,
+    customer_id bigint not null references customers on delete restrict,
+    kind        text not null check(kind in ('earn', 'redeem', 'expire', 'adjustment', 'reversal')),
+    points      integer not null check(points <> 0),
+    balance     integer not null check(balance >= 0),
+    remaining   integer not null default 0 check(remaining >= 0),
+    expires     timestamp,
+    sale_id     bigint references sales,
+    manager_id  bigint references managers,
+    reason      text not null default '',
+    created     timestamp not null default current_timestamp
+);
+
+create index if not exists loyalty_entries_customer_idx on loyalty_entries(customer_id, id);
+create index if not exists loyalty_entries_expires_idx on loyalty_entries(expires) where remaining > 0;
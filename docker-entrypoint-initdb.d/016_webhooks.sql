@@ -0,0 +1,38 @@
+create table if not exists webhook_subscriptions
+(
+    id          bigserial primary key,
+    url         text not null,
+    events      text[] not null,
+    secret      text not null,
+    active      boolean not null default true,
+    created     timestamp not null default current_timestamp
+);
+
+create table if not exists webhook_deliveries
+(
+    id              bigserial primary key,
+    subscription_id bigint not null references webhook_subscriptions on delete cascade,
+    event           text not null,
+    body            text not null,
+    status          text not null default 'pending' check(status in ('pending', 'succeeded', 'failed')),
+    attempts        integer not null default 0,
+    next_attempt    timestamp not null default current_timestamp,
+    response_code   integer not null default 0,
+    created         timestamp not null default current_timestamp,
+    delivered       timestamp
+);
+
+create index if not exists webhook_deliveries_due_idx on webhook_deliveries(next_attempt) where status = 'pending';
+create index if not exists webhook_deliveries_subscription_idx on webhook_deliveries(subscription_id, id);
+
+create table if not exists webhook_attempts
+(
+    id              bigserial primary key,
+    delivery_id     bigint not null references webhook_deliveries on delete cascade,
+    response_code   integer not null default 0,
+    error           text not null default '',
+    duration_ms     integer not null default 0,
+    created         timestamp not null default current_timestamp
+);
+
+create index if not exists webhook_attempts_delivery_idx on webhook_attempts(delivery_id, id);
@@ -0,0 +1,15 @@
+create table if not exists outbox_events
+(
+    id           bigserial primary key,
+    type         text not null,
+    aggregate_id bigint not null,
+    payload      jsonb not null,
+    created      timestamp not null default current_timestamp
+);
+
+create table if not exists outbox_offsets
+(
+    consumer    text primary key,
+    last_id     bigint not null default 0,
+    updated     timestamp not null default current_timestamp
+);
@@ -0,0 +1,20 @@
+-- consumers read events in the order of the transactions that wrote them
+-- (Postgres 13 or later); earlier events keep their id order
+alter table outbox_events add column if not exists xid bigint not null default 0;
+alter table outbox_events alter column xid set default pg_current_xact_id()::text::bigint;
+
+create index if not exists outbox_events_position_idx on outbox_events(xid, id);
+
+alter table outbox_offsets add column if not exists last_xid bigint not null default 0;
+alter table outbox_offsets add column if not exists attempts integer not null default 0;
+alter table outbox_offsets add column if not exists retry_at timestamp not null default current_timestamp;
+
+create table if not exists outbox_parked
+(
+    consumer    text not null,
+    event_id    bigint not null references outbox_events,
+    attempts    integer not null,
+    error       text not null,
+    created     timestamp not null default current_timestamp,
+    primary key (consumer, event_id)
+);
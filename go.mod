@@ -3,11 +3,16 @@ module github.com/ehsontjk/crud
 go 1.15
 
 require (
+	github.com/golang/protobuf v1.4.2
 	github.com/gorilla/mux v1.8.0
+	github.com/gorilla/websocket v1.4.2
+	github.com/jackc/pgconn v1.7.2
 	github.com/jackc/pgx/v4 v4.9.2
-	
 	go.uber.org/dig v1.10.0
 	golang.org/x/crypto v0.0.0-20201217014255-9d1352758620
-	golang.org/x/text v0.3.4 // indirect
+	golang.org/x/image v0.0.0-20201208152932-35266b937fa6
+	golang.org/x/text v0.3.4
 	golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1 // indirect
+	google.golang.org/grpc v1.34.0
+	google.golang.org/protobuf v1.25.0
 )
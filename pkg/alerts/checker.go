@@ -0,0 +1,101 @@
+package alerts
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+
+	"github.com/ehsontjk/crud/pkg/logger"
+)
+
+const DefaultInterval = time.Minute
+
+// Checker looks for products whose stock crossed their reorder level. It runs
+// periodically and right after every stock change reported through Poke.
+type Checker struct {
+	db       *pgxpool.Pool
+	log      *logger.Logger
+	notifier Notifier
+	interval time.Duration
+	wake     chan struct{}
+}
+
+func NewChecker(db *pgxpool.Pool, log *logger.Logger, notifier Notifier) *Checker {
+	return &Checker{
+		db:       db,
+		log:      log,
+		notifier: notifier,
+		interval: DefaultInterval,
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// Poke schedules a check without blocking the caller.
+func (c *Checker) Poke() {
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (c *Checker) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		c.Check(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-c.wake:
+		}
+	}
+}
+
+func (c *Checker) Check(ctx context.Context) {
+
+	// products that were restocked become eligible for a new alert
+	_, err := c.db.Exec(ctx, `update products set low_stock_notified = false where low_stock_notified and qty > reorder_level`)
+	if err != nil {
+		c.log.Error(ctx, "reset low stock flags", "err", err)
+		return
+	}
+
+	sqlstmt := `update products set low_stock_notified = true
+	where active and not low_stock_notified and qty <= reorder_level
+	returning id, name, qty, reorder_level`
+	rows, err := c.db.Query(ctx, sqlstmt)
+	if err != nil {
+		c.log.Error(ctx, "find low stock products", "err", err)
+		return
+	}
+
+	items := make([]*Alert, 0)
+	for rows.Next() {
+		item := &Alert{Created: time.Now()}
+		if err = rows.Scan(&item.ProductID, &item.Name, &item.Qty, &item.ReorderLevel); err != nil {
+			c.log.Error(ctx, "scan low stock product", "err", err)
+			continue
+		}
+		items = append(items, item)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		c.log.Error(ctx, "find low stock products", "err", err)
+		return
+	}
+
+	for _, item := range items {
+		if err = c.notifier.Notify(ctx, item); err != nil {
+			c.log.Error(ctx, "send low stock alert", "product_id", item.ProductID, "err", err)
+			// let the next run try again
+			_, err = c.db.Exec(ctx, `update products set low_stock_notified = false where id = $1`, item.ProductID)
+			if err != nil {
+				c.log.Error(ctx, "reset low stock flag", "err", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package alerts
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/ehsontjk/crud/pkg/logger"
+)
+
+// Alert is raised once when a product's stock drops to or below its reorder
+// level and is raised again only after the stock has been replenished.
+type Alert struct {
+	ProductID    int64     `json:"product_id"`
+	Name         string    `json:"name"`
+	Qty          int       `json:"qty"`
+	ReorderLevel int       `json:"reorder_level"`
+	Created      time.Time `json:"created"`
+}
+
+type Notifier interface {
+	Notify(ctx context.Context, alert *Alert) error
+}
+
+type LogNotifier struct {
+	log *logger.Logger
+}
+
+func NewLogNotifier(log *logger.Logger) *LogNotifier {
+	return &LogNotifier{log: log}
+}
+
+func (n *LogNotifier) Notify(ctx context.Context, alert *Alert) error {
+	n.log.Warn(ctx, "low stock",
+		"product_id", alert.ProductID,
+		"name", alert.Name,
+		"qty", alert.Qty,
+		"reorder_level", alert.ReorderLevel,
+	)
+	return nil
+}
+
+// WebhookNotifier posts every alert as JSON to url.
+type WebhookNotifier struct {
+	url    string
+	client *http.Client
+}
+
+func NewWebhookNotifier(url string, client *http.Client) *WebhookNotifier {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &WebhookNotifier{url: url, client: client}
+}
+
+func (n *WebhookNotifier) Notify(ctx context.Context, alert *Alert) error {
+	data, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, n.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+	return nil
+}
+
+type EmailConfig struct {
+	Addr     string
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+// EmailNotifier sends a plain text mail per alert through an SMTP server.
+type EmailNotifier struct {
+	config EmailConfig
+	send   func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+}
+
+func NewEmailNotifier(config EmailConfig) *EmailNotifier {
+	return &EmailNotifier{config: config, send: smtp.SendMail}
+}
+
+func (n *EmailNotifier) Notify(ctx context.Context, alert *Alert) error {
+	var auth smtp.Auth
+	if n.config.Username != "" {
+		host := n.config.Addr
+		if i := strings.LastIndex(host, ":"); i >= 0 {
+			host = host[:i]
+		}
+		auth = smtp.PlainAuth("", n.config.Username, n.config.Password, host)
+	}
+
+	subject := fmt.Sprintf("Low stock: %s", alert.Name)
+	body := fmt.Sprintf("Product #%d %q has %d left, reorder level is %d.\r\n",
+		alert.ProductID, alert.Name, alert.Qty, alert.ReorderLevel)
+	msg := "From: " + n.config.From + "\r\n" +
+		"To: " + strings.Join(n.config.To, ", ") + "\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" + body
+
+	return n.send(n.config.Addr, auth, n.config.From, n.config.To, []byte(msg))
+}
+
+// MultiNotifier fans an alert out to several notifiers and reports the first
+// error after trying all of them.
+type MultiNotifier []Notifier
+
+func (m MultiNotifier) Notify(ctx context.Context, alert *Alert) error {
+	var first error
+	for _, n := range m {
+		if err := n.Notify(ctx, alert); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
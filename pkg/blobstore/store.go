@@ -0,0 +1,135 @@
+// Package blobstore keeps binary objects such as product images.
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	ErrNotFound   = errors.New("blob not found")
+	ErrInvalidKey = errors.New("invalid blob key")
+)
+
+// BlobStore stores objects under slash separated keys such as
+// "products/1/2/thumb.jpg" and tells where clients can download them.
+type BlobStore interface {
+	Put(ctx context.Context, key string, r io.Reader) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+	URL(key string) string
+}
+
+func validKey(key string) bool {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return false
+	}
+	return path.Clean(key) == key && !strings.HasPrefix(key, "../") && key != ".."
+}
+
+// Local is a BlobStore on the local filesystem. It also serves its objects
+// over HTTP, so it can be mounted under the prefix of its base URL.
+type Local struct {
+	root    string
+	baseURL string
+	files   http.Handler
+}
+
+func NewLocal(root, baseURL string) (*Local, error) {
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, err
+	}
+	return &Local{
+		root:    root,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		files:   http.FileServer(http.Dir(root)),
+	}, nil
+}
+
+func (l *Local) path(key string) (string, error) {
+	if !validKey(key) {
+		return "", ErrInvalidKey
+	}
+	return filepath.Join(l.root, filepath.FromSlash(key)), nil
+}
+
+// Put writes the object to a temporary file first, so readers never see a
+// partially written blob.
+func (l *Local) Put(ctx context.Context, key string, r io.Reader) error {
+	name, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(name), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+func (l *Local) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	name, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+func (l *Local) Delete(ctx context.Context, key string) error {
+	name, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(name)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (l *Local) URL(key string) string {
+	return l.baseURL + "/" + key
+}
+
+// ServeHTTP serves objects by key, the request path being the key.
+func (l *Local) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/")
+	// directory listings are not part of the store
+	if !validKey(key) || strings.HasSuffix(r.URL.Path, "/") || strings.HasPrefix(path.Base(key), ".") {
+		http.NotFound(w, r)
+		return
+	}
+	if contentType := mime.TypeByExtension(path.Ext(key)); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	l.files.ServeHTTP(w, r)
+}
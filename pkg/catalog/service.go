@@ -0,0 +1,461 @@
+package catalog
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+
+	"github.com/ehsontjk/crud/pkg/export"
+	"github.com/ehsontjk/crud/pkg/inventory"
+	"github.com/ehsontjk/crud/pkg/logger"
+	"github.com/ehsontjk/crud/pkg/outbox"
+	"github.com/ehsontjk/crud/pkg/xlsx"
+)
+
+var (
+	ErrInternal          = errors.New("internal error")
+	ErrUnsupportedFormat = export.ErrUnsupportedFormat
+	ErrEmptyFile         = errors.New("catalog file is empty")
+	ErrSKUConflict       = errors.New("sku is used by more than one product")
+)
+
+const (
+	FormatCSV  = export.FormatCSV
+	FormatXLSX = export.FormatXLSX
+)
+
+const uniqueViolation = "23505"
+
+// Columns is the layout of exported catalogs. Imports accept any subset of
+// them in any order as long as each row can be matched by id or sku.
+var Columns = []string{"id", "sku", "name", "price", "qty", "reorder_level", "active", "created"}
+
+// RowError describes why a line of an imported file was rejected. Line is
+// the 1-based line of the file, the header being line 1.
+type RowError struct {
+	Line    int    `json:"line"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+type ImportReport struct {
+	DryRun       bool        `json:"dry_run"`
+	Rows         int         `json:"rows"`
+	Created      int         `json:"created"`
+	Updated      int         `json:"updated"`
+	StockChanged int         `json:"stock_changed"`
+	Errors       []*RowError `json:"errors"`
+}
+
+// row is a parsed import line; nil fields were left blank and keep the
+// current value of an existing product.
+type row struct {
+	line         int
+	id           *int64
+	sku          string
+	name         *string
+	price        *int
+	qty          *int
+	reorderLevel *int
+	active       *bool
+}
+
+type Service struct {
+	db           *pgxpool.Pool
+	log          *logger.Logger
+	inventorySvc *inventory.Service
+}
+
+func NewService(db *pgxpool.Pool, log *logger.Logger, inventorySvc *inventory.Service) *Service {
+	return &Service{db: db, log: log, inventorySvc: inventorySvc}
+}
+
+// ReadRows decodes a CSV or XLSX file into rows of text.
+func ReadRows(format string, r io.ReaderAt, size int64) ([][]string, error) {
+	switch format {
+	case FormatCSV:
+		reader := csv.NewReader(io.NewSectionReader(r, 0, size))
+		reader.FieldsPerRecord = -1
+		reader.TrimLeadingSpace = true
+		rows, err := reader.ReadAll()
+		if err != nil {
+			return nil, err
+		}
+		// spreadsheets often save CSV with a byte order mark
+		if len(rows) > 0 && len(rows[0]) > 0 {
+			rows[0][0] = strings.TrimPrefix(rows[0][0], "\ufeff")
+		}
+		return rows, nil
+	case FormatXLSX:
+		return xlsx.ReadRows(r, size)
+	}
+	return nil, ErrUnsupportedFormat
+}
+
+func parse(rows [][]string) ([]*row, []*RowError) {
+	errs := make([]*RowError, 0)
+
+	header := make(map[string]int)
+	for i, name := range rows[0] {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		known := false
+		for _, column := range Columns {
+			known = known || column == name
+		}
+		if !known {
+			errs = append(errs, &RowError{Line: 1, Field: name, Message: "unknown column"})
+			continue
+		}
+		if _, ok := header[name]; ok {
+			errs = append(errs, &RowError{Line: 1, Field: name, Message: "duplicate column"})
+			continue
+		}
+		header[name] = i
+	}
+	_, hasID := header["id"]
+	_, hasSKU := header["sku"]
+	if !hasID && !hasSKU {
+		errs = append(errs, &RowError{Line: 1, Message: "either an id or a sku column is required"})
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	items := make([]*row, 0, len(rows)-1)
+	seenID := make(map[int64]int)
+	seenSKU := make(map[string]int)
+	for i, record := range rows[1:] {
+		line := i + 2
+		value := func(column string) string {
+			index, ok := header[column]
+			if !ok || index >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[index])
+		}
+		empty := true
+		for _, cell := range record {
+			empty = empty && strings.TrimSpace(cell) == ""
+		}
+		if empty {
+			continue
+		}
+
+		item := &row{line: line, sku: value("sku")}
+		rowErrs := make([]*RowError, 0)
+		fail := func(field, message string) {
+			rowErrs = append(rowErrs, &RowError{Line: line, Field: field, Message: message})
+		}
+		number := func(field string, min int) *int {
+			text := value(field)
+			if text == "" {
+				return nil
+			}
+			n, err := strconv.Atoi(text)
+			if err != nil {
+				fail(field, "must be a whole number")
+				return nil
+			}
+			if n < min {
+				fail(field, fmt.Sprintf("must be at least %d", min))
+				return nil
+			}
+			return &n
+		}
+
+		if text := value("id"); text != "" {
+			id, err := strconv.ParseInt(text, 10, 64)
+			if err != nil || id <= 0 {
+				fail("id", "must be a positive number")
+			} else {
+				item.id = &id
+			}
+		}
+		if name := value("name"); name != "" {
+			item.name = &name
+		}
+		item.price = number("price", 1)
+		item.qty = number("qty", 0)
+		item.reorderLevel = number("reorder_level", 0)
+		if text := value("active"); text != "" {
+			active, err := strconv.ParseBool(strings.ToLower(text))
+			if err != nil {
+				fail("active", "must be true or false")
+			} else {
+				item.active = &active
+			}
+		}
+
+		if item.id != nil {
+			if first, ok := seenID[*item.id]; ok {
+				fail("id", fmt.Sprintf("duplicates line %d", first))
+			}
+			seenID[*item.id] = line
+		}
+		if item.sku != "" {
+			if first, ok := seenSKU[item.sku]; ok {
+				fail("sku", fmt.Sprintf("duplicates line %d", first))
+			}
+			seenSKU[item.sku] = line
+		}
+
+		errs = append(errs, rowErrs...)
+		if len(rowErrs) == 0 {
+			items = append(items, item)
+		}
+	}
+	return items, errs
+}
+
+// Import upserts the catalog rows, matching products by id or, when no id
+// is given, by sku. Rows are staged with COPY and applied with a handful of
+// set-based statements in a single transaction, so a file is applied either
+// completely or not at all. Validation problems are returned in the report;
+// a dry run goes through the same steps and rolls back at the end.
+func (s *Service) Import(ctx context.Context, managerID int64, rows [][]string, dryRun bool) (*ImportReport, error) {
+
+	if len(rows) == 0 {
+		return nil, ErrEmptyFile
+	}
+
+	items, errs := parse(rows)
+	report := &ImportReport{DryRun: dryRun, Rows: len(rows) - 1, Errors: errs}
+	if len(errs) > 0 {
+		return report, nil
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		s.log.Error(ctx, "begin tx", "err", err)
+		return nil, ErrInternal
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `create temp table catalog_import (
+		line integer primary key,
+		id bigint,
+		sku text,
+		name text,
+		price integer,
+		qty integer,
+		reorder_level integer,
+		active boolean,
+		created boolean not null default false
+	) on commit drop`)
+	if err != nil {
+		s.log.Error(ctx, "create catalog import", "err", err)
+		return nil, ErrInternal
+	}
+
+	source := make([][]interface{}, 0, len(items))
+	for _, item := range items {
+		var sku interface{}
+		if item.sku != "" {
+			sku = item.sku
+		}
+		source = append(source, []interface{}{item.line, item.id, sku, item.name, item.price, item.qty, item.reorderLevel, item.active})
+	}
+	columns := []string{"line", "id", "sku", "name", "price", "qty", "reorder_level", "active"}
+	if _, err = tx.CopyFrom(ctx, pgx.Identifier{"catalog_import"}, columns, pgx.CopyFromRows(source)); err != nil {
+		s.log.Error(ctx, "copy catalog import", "err", err)
+		return nil, ErrInternal
+	}
+
+	checks := []struct {
+		field   string
+		message string
+		sqlstmt string
+	}{
+		{"id", "product not found", `select line from catalog_import i
+			where i.id is not null and not exists (select 1 from products p where p.id = i.id)`},
+		{"", "", `update catalog_import i set id = p.id from products p where i.id is null and p.sku = i.sku`},
+		{"sku", "already used by another product", `select i.line from catalog_import i
+			join products p on p.sku = i.sku and p.id <> i.id`},
+		{"", "", `update catalog_import set id = nextval('products_id_seq'), created = true where id is null`},
+		{"name", "is required for a new product", `select line from catalog_import where created and name is null`},
+		{"price", "is required for a new product", `select line from catalog_import where created and price is null`},
+	}
+	for _, check := range checks {
+		if check.message == "" {
+			if _, err = tx.Exec(ctx, check.sqlstmt); err != nil {
+				s.log.Error(ctx, "resolve catalog import", "err", err)
+				return nil, ErrInternal
+			}
+			continue
+		}
+		lines, err := s.lines(ctx, tx, check.sqlstmt)
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range lines {
+			report.Errors = append(report.Errors, &RowError{Line: line, Field: check.field, Message: check.message})
+		}
+	}
+	if len(report.Errors) > 0 {
+		return report, nil
+	}
+
+	tag, err := tx.Exec(ctx, `insert into products(id, sku, name, price, qty, reorder_level, active)
+	select id, sku, name, price, 0, coalesce(reorder_level, 0), coalesce(active, true)
+	from catalog_import where created order by id`)
+	if err == nil {
+		report.Created = int(tag.RowsAffected())
+		tag, err = tx.Exec(ctx, `update products p set
+			sku = coalesce(i.sku, p.sku),
+			name = coalesce(i.name, p.name),
+			price = coalesce(i.price, p.price),
+			reorder_level = coalesce(i.reorder_level, p.reorder_level),
+			active = coalesce(i.active, p.active)
+		from catalog_import i where p.id = i.id and not i.created`)
+	}
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+			return nil, ErrSKUConflict
+		}
+		s.log.Error(ctx, "upsert catalog import", "err", err)
+		return nil, ErrInternal
+	}
+	report.Updated = int(tag.RowsAffected())
+
+	counts, err := s.counts(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	if report.StockChanged, err = s.inventorySvc.ApplyCounts(ctx, tx, managerID, counts); err != nil {
+		return nil, err
+	}
+
+	// one event per created or updated product, as SaveProduct writes
+	_, err = outbox.WriteAll(ctx, tx, outbox.ProductChanged, `select p.id, jsonb_build_object(
+		'id', p.id, 'sku', coalesce(p.sku, ''), 'category_id', coalesce(p.category_id, 0), 'name', p.name, 'price', p.price,
+		'qty', p.qty, 'reorder_level', p.reorder_level, 'active', p.active, 'created', p.created)
+	from products p join catalog_import i on i.id = p.id order by p.id`)
+	if err != nil {
+		s.log.Error(ctx, "write product events", "err", err)
+		return nil, ErrInternal
+	}
+
+	if dryRun {
+		return report, nil
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		s.log.Error(ctx, "commit catalog import", "err", err)
+		return nil, ErrInternal
+	}
+	s.log.Info(ctx, "catalog imported", "manager_id", managerID, "created", report.Created, "updated", report.Updated,
+		"stock_changed", report.StockChanged)
+	s.inventorySvc.StockChanged(ctx)
+	return report, nil
+}
+
+func (s *Service) lines(ctx context.Context, tx pgx.Tx, sqlstmt string) ([]int, error) {
+
+	lines := make([]int, 0)
+	rows, err := tx.Query(ctx, sqlstmt+` order by 1 limit 1000`)
+	if err != nil {
+		s.log.Error(ctx, "check catalog import", "err", err)
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var line int
+		if err = rows.Scan(&line); err != nil {
+			s.log.Error(ctx, "scan catalog import", "err", err)
+			return nil, ErrInternal
+		}
+		lines = append(lines, line)
+	}
+	if err = rows.Err(); err != nil {
+		s.log.Error(ctx, "check catalog import", "err", err)
+		return nil, ErrInternal
+	}
+	return lines, nil
+}
+
+func (s *Service) counts(ctx context.Context, tx pgx.Tx) ([]*inventory.Count, error) {
+
+	counts := make([]*inventory.Count, 0)
+	rows, err := tx.Query(ctx, `select id, qty, created from catalog_import where qty is not null`)
+	if err != nil {
+		s.log.Error(ctx, "get catalog import stock", "err", err)
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		count := &inventory.Count{Kind: inventory.Adjustment, Reason: "catalog import"}
+		var created bool
+		if err = rows.Scan(&count.ProductID, &count.Qty, &created); err != nil {
+			s.log.Error(ctx, "scan catalog import stock", "err", err)
+			return nil, ErrInternal
+		}
+		if created {
+			count.Kind = inventory.Receipt
+			count.Reason = "initial stock"
+		}
+		counts = append(counts, count)
+	}
+	if err = rows.Err(); err != nil {
+		s.log.Error(ctx, "get catalog import stock", "err", err)
+		return nil, ErrInternal
+	}
+	return counts, nil
+}
+
+// Export streams every product, including inactive ones, in the given
+// format. The columns match what Import accepts.
+func (s *Service) Export(ctx context.Context, w io.Writer, format string) error {
+
+	if format != FormatCSV && format != FormatXLSX {
+		return ErrUnsupportedFormat
+	}
+	out, err := export.NewRowWriter(w, format, Columns, "Products")
+	if err != nil {
+		return err
+	}
+
+	sqlstmt := `select id, coalesce(sku, ''), name, price, qty, reorder_level, active, created from products order by id`
+	rows, err := s.db.Query(ctx, sqlstmt)
+	if err != nil {
+		s.log.Error(ctx, "export catalog", "err", err)
+		return ErrInternal
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			id                       int64
+			sku, name                string
+			price, qty, reorderLevel int
+			active                   bool
+			created                  time.Time
+		)
+		if err = rows.Scan(&id, &sku, &name, &price, &qty, &reorderLevel, &active, &created); err != nil {
+			s.log.Error(ctx, "scan catalog export", "err", err)
+			return ErrInternal
+		}
+		if err = out.WriteRow(id, sku, name, price, qty, reorderLevel, active, created); err != nil {
+			return err
+		}
+	}
+	if err = rows.Err(); err != nil {
+		s.log.Error(ctx, "export catalog", "err", err)
+		return ErrInternal
+	}
+	return out.Close()
+}
@@ -0,0 +1,282 @@
+package categories
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+
+	"github.com/ehsontjk/crud/pkg/logger"
+)
+
+var (
+	ErrNotFound        = errors.New("item not found")
+	ErrInternal        = errors.New("internal error")
+	ErrInvalidCategory = errors.New("invalid category")
+	ErrSlugDuplicated  = errors.New("category slug already exists")
+	ErrCycle           = errors.New("category cannot be moved under itself")
+	ErrInUse           = errors.New("category has subcategories, products or promotions")
+)
+
+const (
+	uniqueViolation     = "23505"
+	foreignKeyViolation = "23503"
+)
+
+// Category is a node of the catalog tree. Siblings are ordered by Position
+// and then by name. Children is only filled in trees.
+type Category struct {
+	ID       int64       `json:"id"`
+	ParentID int64       `json:"parent_id"`
+	Name     string      `json:"name"`
+	Slug     string      `json:"slug"`
+	Position int         `json:"position"`
+	Active   bool        `json:"active"`
+	Created  time.Time   `json:"created"`
+	Children []*Category `json:"children,omitempty"`
+}
+
+type Service struct {
+	db  *pgxpool.Pool
+	log *logger.Logger
+}
+
+func NewService(db *pgxpool.Pool, log *logger.Logger) *Service {
+	return &Service{db: db, log: log}
+}
+
+var translit = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "yo", 'ж': "zh", 'з': "z", 'и': "i",
+	'й': "y", 'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o", 'п': "p", 'р': "r", 'с': "s", 'т': "t",
+	'у': "u", 'ф': "f", 'х': "kh", 'ц': "ts", 'ч': "ch", 'ш': "sh", 'щ': "shch", 'ъ': "", 'ы': "y", 'ь': "",
+	'э': "e", 'ю': "yu", 'я': "ya", 'ғ': "gh", 'ӣ': "i", 'қ': "q", 'ӯ': "u", 'ҳ': "h", 'ҷ': "j",
+}
+
+// Slugify turns a name into a URL friendly slug, transliterating Cyrillic
+// letters and joining words with dashes.
+func Slugify(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		switch {
+		case r >= 'a' && r <= 'z' || r >= '0' && r <= '9':
+			b.WriteRune(r)
+			dash = false
+		case translit[r] != "":
+			b.WriteString(translit[r])
+			dash = false
+		default:
+			if _, skip := translit[r]; skip {
+				continue
+			}
+			if !dash && b.Len() > 0 {
+				b.WriteByte('-')
+				dash = true
+			}
+		}
+	}
+	return strings.TrimSuffix(b.String(), "-")
+}
+
+func validSlug(slug string) bool {
+	return slug != "" && Slugify(slug) == slug
+}
+
+const categoryColumns = `id, coalesce(parent_id, 0), name, slug, position, active, created`
+
+func scanCategory(row pgx.Row) (*Category, error) {
+	item := &Category{}
+	err := row.Scan(&item.ID, &item.ParentID, &item.Name, &item.Slug, &item.Position, &item.Active, &item.Created)
+	return item, err
+}
+
+func (s *Service) Save(ctx context.Context, item *Category) (*Category, error) {
+
+	item.Name = strings.TrimSpace(item.Name)
+	if item.Slug == "" {
+		item.Slug = Slugify(item.Name)
+	}
+	if item.Name == "" || !validSlug(item.Slug) || (item.ID != 0 && item.ParentID == item.ID) {
+		return nil, ErrInvalidCategory
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		s.log.Error(ctx, "begin tx", "err", err)
+		return nil, ErrInternal
+	}
+	defer tx.Rollback(ctx)
+
+	if item.ID != 0 && item.ParentID != 0 {
+		// moving a category under one of its own descendants would detach the
+		// subtree; the lock keeps concurrent moves from passing the check
+		// together and closing a cycle between them
+		if _, err = tx.Exec(ctx, `lock table categories in share row exclusive mode`); err != nil {
+			s.log.Error(ctx, "lock categories", "err", err)
+			return nil, ErrInternal
+		}
+		var cycle bool
+		sqlstmt := `with recursive subtree as (
+			select id from categories where id = $1
+			union
+			select c.id from categories c join subtree on c.parent_id = subtree.id
+		) select exists (select 1 from subtree where id = $2)`
+		if err = tx.QueryRow(ctx, sqlstmt, item.ID, item.ParentID).Scan(&cycle); err != nil {
+			s.log.Error(ctx, "check category cycle", "err", err)
+			return nil, ErrInternal
+		}
+		if cycle {
+			return nil, ErrCycle
+		}
+	}
+
+	var row pgx.Row
+	if item.ID == 0 {
+		sqlstmt := `insert into categories(parent_id, name, slug, position, active)
+		values (nullif($1, 0), $2, $3, $4, $5) returning ` + categoryColumns
+		row = tx.QueryRow(ctx, sqlstmt, item.ParentID, item.Name, item.Slug, item.Position, item.Active)
+	} else {
+		sqlstmt := `update categories set parent_id = nullif($1, 0), name = $2, slug = $3, position = $4, active = $5
+		where id = $6 returning ` + categoryColumns
+		row = tx.QueryRow(ctx, sqlstmt, item.ParentID, item.Name, item.Slug, item.Position, item.Active, item.ID)
+	}
+
+	saved, err := scanCategory(row)
+	if err == pgx.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+			return nil, ErrSlugDuplicated
+		}
+		if errors.As(err, &pgErr) && pgErr.Code == foreignKeyViolation {
+			return nil, ErrInvalidCategory
+		}
+		s.log.Error(ctx, "save category", "err", err)
+		return nil, ErrInternal
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		s.log.Error(ctx, "commit category", "err", err)
+		return nil, ErrInternal
+	}
+	return saved, nil
+}
+
+// Remove deletes a category that nothing refers to any more.
+func (s *Service) Remove(ctx context.Context, id int64) error {
+
+	tag, err := s.db.Exec(ctx, `delete from categories where id = $1`, id)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == foreignKeyViolation {
+			return ErrInUse
+		}
+		s.log.Error(ctx, "remove category", "err", err)
+		return ErrInternal
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *Service) all(ctx context.Context, activeOnly bool) ([]*Category, error) {
+
+	items := make([]*Category, 0)
+	sqlstmt := `select ` + categoryColumns + ` from categories where active or not $1 order by position, name, id`
+	rows, err := s.db.Query(ctx, sqlstmt, activeOnly)
+	if err != nil {
+		s.log.Error(ctx, "get categories", "err", err)
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		item, err := scanCategory(rows)
+		if err != nil {
+			s.log.Error(ctx, "scan category", "err", err)
+			return nil, ErrInternal
+		}
+		items = append(items, item)
+	}
+	if err = rows.Err(); err != nil {
+		s.log.Error(ctx, "get categories", "err", err)
+		return nil, ErrInternal
+	}
+	return items, nil
+}
+
+// Tree returns the root categories with their descendants. With activeOnly
+// an inactive category hides its whole subtree.
+func (s *Service) Tree(ctx context.Context, activeOnly bool) ([]*Category, error) {
+
+	items, err := s.all(ctx, activeOnly)
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[int64]*Category, len(items))
+	for _, item := range items {
+		byID[item.ID] = item
+	}
+
+	// items come ordered, so children are appended in display order
+	roots := make([]*Category, 0)
+	for _, item := range items {
+		if item.ParentID == 0 {
+			roots = append(roots, item)
+			continue
+		}
+		if parent, ok := byID[item.ParentID]; ok {
+			parent.Children = append(parent.Children, item)
+		}
+	}
+	return roots, nil
+}
+
+// Subtree resolves a category by id or slug and returns its id together with
+// the ids of all its descendants, for use as a listing filter.
+func (s *Service) Subtree(ctx context.Context, ref string) ([]int64, error) {
+
+	id, err := strconv.ParseInt(ref, 10, 64)
+	if err != nil {
+		id = 0
+	}
+
+	sqlstmt := `with recursive subtree as (
+		select id from categories where id = $1 or ($1 = 0 and slug = $2)
+		union
+		select c.id from categories c join subtree on c.parent_id = subtree.id
+	) select id from subtree`
+	rows, err := s.db.Query(ctx, sqlstmt, id, ref)
+	if err != nil {
+		s.log.Error(ctx, "get category subtree", "err", err)
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+
+	ids := make([]int64, 0)
+	for rows.Next() {
+		var id int64
+		if err = rows.Scan(&id); err != nil {
+			s.log.Error(ctx, "scan category subtree", "err", err)
+			return nil, ErrInternal
+		}
+		ids = append(ids, id)
+	}
+	if err = rows.Err(); err != nil {
+		s.log.Error(ctx, "get category subtree", "err", err)
+		return nil, ErrInternal
+	}
+	if len(ids) == 0 {
+		return nil, ErrNotFound
+	}
+	return ids, nil
+}
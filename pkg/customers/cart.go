@@ -0,0 +1,178 @@
+package customers
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v4"
+
+	"github.com/ehsontjk/crud/pkg/managers"
+)
+
+var (
+	ErrInvalidQty  = errors.New("invalid qty")
+	ErrCartEmpty   = errors.New("cart is empty")
+	ErrCartChanged = errors.New("cart changed during checkout")
+)
+
+// CartItem is a product in a customer's cart priced at the current catalog
+// price. Available tells whether the product can still be bought in this qty
+// from the default store, which serves online orders.
+type CartItem struct {
+	ProductID int64  `json:"product_id"`
+	Name      string `json:"name"`
+	Price     int    `json:"price"`
+	Qty       int    `json:"qty"`
+	Total     int    `json:"total"`
+	Available bool   `json:"available"`
+}
+
+type Cart struct {
+	CustomerID int64       `json:"customer_id"`
+	Items      []*CartItem `json:"items"`
+	Total      int         `json:"total"`
+}
+
+func (s *Service) Cart(ctx context.Context, customerID int64) (*Cart, error) {
+
+	cart := &Cart{CustomerID: customerID, Items: make([]*CartItem, 0)}
+
+	sqlStatement := `select c.product_id, p.name, p.price, c.qty, p.active and coalesce(ss.qty, 0) >= c.qty
+	from cart_items c join products p on p.id = c.product_id
+	left join store_stock ss on ss.product_id = p.id and ss.store_id = (select id from stores where is_default)
+	where c.customer_id = $1 order by c.created, c.product_id`
+	rows, err := s.db.Query(ctx, sqlStatement, customerID)
+	if err != nil {
+		s.log.Error(ctx, "get cart", "err", err)
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		item := &CartItem{}
+		err = rows.Scan(&item.ProductID, &item.Name, &item.Price, &item.Qty, &item.Available)
+		if err != nil {
+			s.log.Error(ctx, "scan cart item", "err", err)
+			return nil, ErrInternal
+		}
+		item.Total = item.Price * item.Qty
+		cart.Total += item.Total
+		cart.Items = append(cart.Items, item)
+	}
+	if err = rows.Err(); err != nil {
+		s.log.Error(ctx, "get cart", "err", err)
+		return nil, ErrInternal
+	}
+
+	return cart, nil
+}
+
+// AddToCart puts qty more of the product into the cart.
+func (s *Service) AddToCart(ctx context.Context, customerID, productID int64, qty int) (*Cart, error) {
+
+	if qty <= 0 {
+		return nil, ErrInvalidQty
+	}
+
+	sqlStatement := `insert into cart_items(customer_id, product_id, qty)
+	select $1, id, $3 from products where id = $2 and active
+	on conflict (customer_id, product_id) do update
+	set qty = cart_items.qty + excluded.qty, updated = current_timestamp`
+	tag, err := s.db.Exec(ctx, sqlStatement, customerID, productID, qty)
+	if err != nil {
+		s.log.Error(ctx, "add to cart", "err", err)
+		return nil, ErrInternal
+	}
+	if tag.RowsAffected() == 0 {
+		return nil, ErrNotFound
+	}
+
+	return s.Cart(ctx, customerID)
+}
+
+// SetCartQty replaces the qty of a product already in the cart, zero removes it.
+func (s *Service) SetCartQty(ctx context.Context, customerID, productID int64, qty int) (*Cart, error) {
+
+	if qty < 0 {
+		return nil, ErrInvalidQty
+	}
+	if qty == 0 {
+		return s.RemoveFromCart(ctx, customerID, productID)
+	}
+
+	sqlStatement := `update cart_items set qty = $3, updated = current_timestamp where customer_id = $1 and product_id = $2`
+	tag, err := s.db.Exec(ctx, sqlStatement, customerID, productID, qty)
+	if err != nil {
+		s.log.Error(ctx, "update cart item", "err", err)
+		return nil, ErrInternal
+	}
+	if tag.RowsAffected() == 0 {
+		return nil, ErrNotFound
+	}
+
+	return s.Cart(ctx, customerID)
+}
+
+func (s *Service) RemoveFromCart(ctx context.Context, customerID, productID int64) (*Cart, error) {
+
+	tag, err := s.db.Exec(ctx, `delete from cart_items where customer_id = $1 and product_id = $2`, customerID, productID)
+	if err != nil {
+		s.log.Error(ctx, "remove cart item", "err", err)
+		return nil, ErrInternal
+	}
+	if tag.RowsAffected() == 0 {
+		return nil, ErrNotFound
+	}
+
+	return s.Cart(ctx, customerID)
+}
+
+// Checkout turns the cart into an online sale using the same stock, pricing
+// and promotion rules as sales made by managers, optionally paying part of it
+// with loyalty points. The cart is emptied in the same transaction.
+func (s *Service) Checkout(ctx context.Context, customerID int64, promoCodes []string, redeemPoints int) (*managers.Sale, error) {
+
+	cart, err := s.Cart(ctx, customerID)
+	if err != nil {
+		return nil, err
+	}
+	if len(cart.Items) == 0 {
+		return nil, ErrCartEmpty
+	}
+
+	sale := &managers.Sale{
+		CustomerID:   customerID,
+		Channel:      managers.ChannelOnline,
+		PromoCodes:   promoCodes,
+		RedeemPoints: redeemPoints,
+	}
+	for _, item := range cart.Items {
+		sale.Positions = append(sale.Positions, &managers.SalePosition{ProductID: item.ProductID, Qty: item.Qty})
+	}
+
+	clearCart := func(ctx context.Context, tx pgx.Tx, sale *managers.Sale) error {
+		for _, item := range cart.Items {
+			sqlStatement := `delete from cart_items where customer_id = $1 and product_id = $2 and qty = $3`
+			tag, err := tx.Exec(ctx, sqlStatement, customerID, item.ProductID, item.Qty)
+			if err != nil {
+				s.log.Error(ctx, "clear cart", "err", err)
+				return ErrInternal
+			}
+			if tag.RowsAffected() == 0 {
+				return ErrCartChanged
+			}
+		}
+
+		var left int
+		if err := tx.QueryRow(ctx, `select count(*) from cart_items where customer_id = $1`, customerID).Scan(&left); err != nil {
+			s.log.Error(ctx, "clear cart", "err", err)
+			return ErrInternal
+		}
+		if left != 0 {
+			return ErrCartChanged
+		}
+		return nil
+	}
+
+	return s.managerSvc.MakeSaleWith(ctx, sale, clearCart)
+}
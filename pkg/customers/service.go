@@ -5,11 +5,18 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"errors"
-	"log"
 	"time"
 
 	"golang.org/x/crypto/bcrypt"
 
+	"github.com/ehsontjk/crud/pkg/images"
+	"github.com/ehsontjk/crud/pkg/logger"
+	"github.com/ehsontjk/crud/pkg/managers"
+	"github.com/ehsontjk/crud/pkg/outbox"
+	"github.com/ehsontjk/crud/pkg/productcache"
+	"github.com/ehsontjk/crud/pkg/stores"
+
+	"github.com/jackc/pgconn"
 	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 )
@@ -25,13 +32,20 @@ var (
 	ErrTokenExpired = errors.New("token expired")
 )
 
+const uniqueViolation = "23505"
+
 
 type Service struct {
-	db *pgxpool.Pool
+	db         *pgxpool.Pool
+	log        *logger.Logger
+	managerSvc *managers.Service
+	imageSvc   *images.Service
+	storeSvc   *stores.Service
+	cache      *productcache.Cache
 }
 
-func NewService(db *pgxpool.Pool) *Service {
-	return &Service{db: db}
+func NewService(db *pgxpool.Pool, log *logger.Logger, managerSvc *managers.Service, imageSvc *images.Service, storeSvc *stores.Service, cache *productcache.Cache) *Service {
+	return &Service{db: db, log: log, managerSvc: managerSvc, imageSvc: imageSvc, storeSvc: storeSvc, cache: cache}
 }
 
 
@@ -39,17 +53,20 @@ type Customer struct {
 	ID       int64     `json:"id"`
 	Name     string    `json:"name"`
 	Phone    string    `json:"phone"`
-	Password string    `json:"password"`
+	Password string    `json:"-"`
 	Active   bool      `json:"active"`
 	Created  time.Time `json:"created"`
 }
 
 
 type Product struct {
-	ID    int64  `json:"id"`
-	Name  string `json:"name"`
-	Price int    `json:"price"`
-	Qty   int    `json:"qty"`
+	ID           int64                  `json:"id"`
+	CategoryID   int64                  `json:"category_id"`
+	Name         string                 `json:"name"`
+	Price        int                    `json:"price"`
+	Qty          int                    `json:"qty"`
+	Images       []*images.Image        `json:"images"`
+	Availability []*stores.Availability `json:"availability"`
 }
 
 
@@ -74,7 +91,7 @@ func (s *Service) All(ctx context.Context) (cs []*Customer, err error) {
 			&item.Created,
 		)
 		if err != nil {
-			log.Println(err)
+			s.log.Error(ctx, "scan customer", "err", err)
 		}
 		cs = append(cs, item)
 	}
@@ -103,7 +120,7 @@ func (s *Service) AllActive(ctx context.Context) (cs []*Customer, err error) {
 			&item.Created,
 		)
 		if err != nil {
-			log.Println(err)
+			s.log.Error(ctx, "scan customer", "err", err)
 		}
 		cs = append(cs, item)
 	}
@@ -131,7 +148,7 @@ func (s *Service) ByID(ctx context.Context, id int64) (*Customer, error) {
 	}
 	
 	if err != nil {
-		log.Print(err)
+		s.log.Error(ctx, "get customer by id", "err", err)
 		return nil, ErrInternal
 	}
 	return item, nil
@@ -157,7 +174,7 @@ func (s *Service) ChangeActive(ctx context.Context, id int64, active bool) (*Cus
 	}
 	
 	if err != nil {
-		log.Print(err)
+		s.log.Error(ctx, "change customer active", "err", err)
 		return nil, ErrInternal
 	}
 	return item, nil
@@ -184,7 +201,7 @@ func (s *Service) Delete(ctx context.Context, id int64) (*Customer, error) {
 	}
 	
 	if err != nil {
-		log.Print(err)
+		s.log.Error(ctx, "delete customer", "err", err)
 		return nil, ErrInternal
 	}
 	return item, nil
@@ -195,12 +212,21 @@ func (s *Service) Delete(ctx context.Context, id int64) (*Customer, error) {
 func (s *Service) Save(ctx context.Context, customer *Customer) (c *Customer, err error) {
 	
 	item := &Customer{}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		s.log.Error(ctx, "begin tx", "err", err)
+		return nil, ErrInternal
+	}
+	defer tx.Rollback(ctx)
 	
+	event := outbox.CustomerChanged
 	if customer.ID == 0 {
+		event = outbox.CustomerRegistered
 		
 		sqlStatement := `insert into customers(name, phone, password) values($1, $2, $3) returning *`
 		
-		err = s.db.QueryRow(ctx, sqlStatement, customer.Name, customer.Phone, customer.Password).Scan(
+		err = tx.QueryRow(ctx, sqlStatement, customer.Name, customer.Phone, customer.Password).Scan(
 			&item.ID,
 			&item.Name,
 			&item.Phone,
@@ -213,7 +239,7 @@ func (s *Service) Save(ctx context.Context, customer *Customer) (c *Customer, er
 		
 		sqlStatement := `update customers set name=$1, phone=$2, password=$3 where id=$4 returning *`
 		
-		err = s.db.QueryRow(ctx, sqlStatement, customer.Name, customer.Phone, customer.Password, customer.ID).Scan(
+		err = tx.QueryRow(ctx, sqlStatement, customer.Name, customer.Phone, customer.Password, customer.ID).Scan(
 			&item.ID,
 			&item.Name,
 			&item.Phone,
@@ -223,8 +249,23 @@ func (s *Service) Save(ctx context.Context, customer *Customer) (c *Customer, er
 	}
 
 	
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+		return nil, ErrPhoneUsed
+	}
 	if err != nil {
-		log.Print(err)
+		s.log.Error(ctx, "save customer", "err", err)
+		return nil, ErrInternal
+	}
+
+	// the event carries no password hash
+	payload := &managers.Customer{ID: item.ID, Name: item.Name, Phone: item.Phone, Active: item.Active, Created: item.Created}
+	if err = outbox.Write(ctx, tx, event, item.ID, payload); err != nil {
+		s.log.Error(ctx, "write customer event", "err", err)
+		return nil, ErrInternal
+	}
+	if err = tx.Commit(ctx); err != nil {
+		s.log.Error(ctx, "commit customer", "err", err)
 		return nil, ErrInternal
 	}
 	return item, nil
@@ -271,12 +312,25 @@ func (s *Service) Token(ctx context.Context, phone, password string) (string, er
 }
 
 
-func (s *Service) Products(ctx context.Context) ([]*Product, error) {
+// Products lists active products, limited to the given categories unless
+// categoryIDs is nil. The list is cached and must not be changed.
+func (s *Service) Products(ctx context.Context, categoryIDs []int64) ([]*Product, error) {
+	items, err := s.cache.Load(productcache.Key("customers.products", categoryIDs), func() (interface{}, error) {
+		return s.products(ctx, categoryIDs)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items.([]*Product), nil
+}
+
+func (s *Service) products(ctx context.Context, categoryIDs []int64) ([]*Product, error) {
 
 	items := make([]*Product, 0)
 
-	sqlStatement := `select id, name, price, qty from products where active = true order by id limit 500`
-	rows, err := s.db.Query(ctx, sqlStatement)
+	sqlStatement := `select id, coalesce(category_id, 0), name, price, qty from products
+	where active = true and ($1::bigint[] is null or category_id = any($1)) order by id limit 500`
+	rows, err := s.db.Query(ctx, sqlStatement, categoryIDs)
 
 	if err != nil {
 		if err == pgx.ErrNoRows {
@@ -289,14 +343,37 @@ func (s *Service) Products(ctx context.Context) ([]*Product, error) {
 
 	for rows.Next() {
 		item := &Product{}
-		err = rows.Scan(&item.ID, &item.Name, &item.Price, &item.Qty)
+		err = rows.Scan(&item.ID, &item.CategoryID, &item.Name, &item.Price, &item.Qty)
 		if err != nil {
-			log.Print(err)
+			s.log.Error(ctx, "scan product", "err", err)
 			return nil, err
 		}
 		items = append(items, item)
 	}
 
+	productIDs := make([]int64, 0, len(items))
+	for _, item := range items {
+		productIDs = append(productIDs, item.ID)
+	}
+	byProduct, err := s.imageSvc.ForProducts(ctx, productIDs)
+	if err != nil {
+		return nil, ErrInternal
+	}
+	byStore, err := s.storeSvc.Availability(ctx, productIDs)
+	if err != nil {
+		return nil, ErrInternal
+	}
+	for _, item := range items {
+		item.Images = byProduct[item.ID]
+		if item.Images == nil {
+			item.Images = make([]*images.Image, 0)
+		}
+		item.Availability = byStore[item.ID]
+		if item.Availability == nil {
+			item.Availability = make([]*stores.Availability, 0)
+		}
+	}
+
 	return items, nil
 }
 
@@ -317,3 +394,39 @@ func (s *Service) IDByToken(ctx context.Context, token string) (int64, error) {
 
 	return id, nil
 }
+
+
+// SetPassword replaces the customer's password.
+func (s *Service) SetPassword(ctx context.Context, id int64, password string) error {
+
+	if password == "" {
+		return ErrInvalidPassword
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		s.log.Error(ctx, "hash customer password", "err", err)
+		return ErrInternal
+	}
+
+	tag, err := s.db.Exec(ctx, `update customers set password = $1 where id = $2`, string(hash), id)
+	if err != nil {
+		s.log.Error(ctx, "set customer password", "err", err)
+		return ErrInternal
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+
+// RevokeTokens signs the customer out everywhere and returns how many
+// tokens were revoked.
+func (s *Service) RevokeTokens(ctx context.Context, id int64) (int64, error) {
+	tag, err := s.db.Exec(ctx, `delete from customers_tokens where customer_id = $1`, id)
+	if err != nil {
+		s.log.Error(ctx, "revoke customer tokens", "err", err)
+		return 0, ErrInternal
+	}
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,138 @@
+package export
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+
+	"github.com/ehsontjk/crud/pkg/logger"
+)
+
+var (
+	ErrInternal     = errors.New("internal error")
+	ErrInvalidRange = errors.New("invalid date range")
+)
+
+// fetchSize is how many rows are pulled from the cursor at a time.
+const fetchSize = 1000
+
+// SalesColumns is the layout of the sales export: one row per sale position
+// with the totals of its sale repeated on every line.
+var SalesColumns = []string{
+	"sale_id", "created", "status", "channel",
+	"manager_id", "manager_name", "customer_id", "customer_name",
+	"position_id", "product_id", "product_sku", "product_name", "category_id", "category_name", "qty", "price", "amount",
+	"sale_gross", "sale_discount", "sale_total", "sale_refunded",
+}
+
+type Service struct {
+	db  *pgxpool.Pool
+	log *logger.Logger
+}
+
+func NewService(db *pgxpool.Pool, log *logger.Logger) *Service {
+	return &Service{db: db, log: log}
+}
+
+// Sales streams the positions of sales created in [from, to), limited to
+// products of the given categories unless categoryIDs is nil. Rows are read
+// through a server-side cursor so the export never holds more than one batch
+// in memory, inside a read only snapshot so the file is consistent.
+func (s *Service) Sales(ctx context.Context, w io.Writer, format string, from, to time.Time, categoryIDs []int64) error {
+
+	if !from.Before(to) {
+		return ErrInvalidRange
+	}
+
+	out, err := NewRowWriter(w, format, SalesColumns, "Sales")
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	if err != nil {
+		s.log.Error(ctx, "begin tx", "err", err)
+		return ErrInternal
+	}
+	defer tx.Rollback(ctx)
+
+	sqlstmt := `declare sales_export no scroll cursor for
+	select s.id, s.created, s.status, s.channel,
+		coalesce(s.manager_id, 0), coalesce(m.name, ''), s.customer_id, coalesce(c.name, ''),
+		coalesce(sp.id, 0), coalesce(sp.product_id, 0), coalesce(p.sku, ''), coalesce(p.name, ''),
+		coalesce(p.category_id, 0), coalesce(cat.name, ''),
+		coalesce(sp.qty, 0), coalesce(sp.price, 0), coalesce(sp.qty * sp.price, 0),
+		s.gross, s.discount, s.total, s.refunded
+	from sales s
+	left join managers m on m.id = s.manager_id
+	left join customers c on c.id = s.customer_id
+	left join sales_positions sp on sp.sale_id = s.id
+	left join products p on p.id = sp.product_id
+	left join categories cat on cat.id = p.category_id
+	where s.created >= $1 and s.created < $2 and ($3::bigint[] is null or p.category_id = any($3))
+	order by s.id, sp.id`
+	if _, err = tx.Exec(ctx, sqlstmt, from, to, categoryIDs); err != nil {
+		s.log.Error(ctx, "declare sales export", "err", err)
+		return ErrInternal
+	}
+
+	total := 0
+	for {
+		n, err := s.fetchSales(ctx, tx, out)
+		if err != nil {
+			return err
+		}
+		total += n
+		if n < fetchSize {
+			break
+		}
+	}
+
+	if err = out.Close(); err != nil {
+		return err
+	}
+	s.log.Info(ctx, "sales exported", "format", format, "from", from, "to", to, "rows", total)
+	return nil
+}
+
+func (s *Service) fetchSales(ctx context.Context, tx pgx.Tx, out RowWriter) (int, error) {
+
+	rows, err := tx.Query(ctx, "fetch forward "+strconv.Itoa(fetchSize)+" from sales_export")
+	if err != nil {
+		s.log.Error(ctx, "fetch sales export", "err", err)
+		return 0, ErrInternal
+	}
+	defer rows.Close()
+
+	n := 0
+	for rows.Next() {
+		var (
+			saleID, managerID, customerID, positionID, productID, categoryID    int64
+			created                                                             time.Time
+			status, channel, managerName, customerName, sku, name, categoryName string
+			qty, price, amount, gross, discount, total, refunded                int
+		)
+		err = rows.Scan(&saleID, &created, &status, &channel, &managerID, &managerName, &customerID, &customerName,
+			&positionID, &productID, &sku, &name, &categoryID, &categoryName, &qty, &price, &amount, &gross, &discount, &total, &refunded)
+		if err != nil {
+			s.log.Error(ctx, "scan sales export", "err", err)
+			return 0, ErrInternal
+		}
+		err = out.WriteRow(saleID, created, status, channel, managerID, managerName, customerID, customerName,
+			positionID, productID, sku, name, categoryID, categoryName, qty, price, amount, gross, discount, total, refunded)
+		if err != nil {
+			return 0, err
+		}
+		n++
+	}
+	if err = rows.Err(); err != nil {
+		s.log.Error(ctx, "fetch sales export", "err", err)
+		return 0, ErrInternal
+	}
+	return n, nil
+}
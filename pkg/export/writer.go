@@ -0,0 +1,141 @@
+// Package export streams tabular data as CSV, XLSX or NDJSON.
+package export
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/ehsontjk/crud/pkg/xlsx"
+)
+
+var ErrUnsupportedFormat = errors.New("unsupported export format")
+
+const (
+	FormatCSV    = "csv"
+	FormatXLSX   = "xlsx"
+	FormatNDJSON = "ndjson"
+)
+
+// ContentType returns the media type of a format.
+func ContentType(format string) string {
+	switch format {
+	case FormatCSV:
+		return "text/csv; charset=utf-8"
+	case FormatXLSX:
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	case FormatNDJSON:
+		return "application/x-ndjson"
+	}
+	return "application/octet-stream"
+}
+
+// RowWriter writes rows whose values follow the columns it was created with.
+// Close must be called to flush the output.
+type RowWriter interface {
+	WriteRow(values ...interface{}) error
+	Close() error
+}
+
+// NewRowWriter returns a writer for format. CSV and XLSX start with a header
+// row, NDJSON writes one object per row keyed by the column names.
+func NewRowWriter(w io.Writer, format string, columns []string, sheetName string) (RowWriter, error) {
+
+	var out RowWriter
+	switch format {
+	case FormatCSV:
+		out = &csvWriter{w: csv.NewWriter(w)}
+	case FormatXLSX:
+		writer, err := xlsx.NewWriter(w, sheetName)
+		if err != nil {
+			return nil, err
+		}
+		out = writer
+	case FormatNDJSON:
+		return &ndjsonWriter{w: bufio.NewWriter(w), columns: columns}, nil
+	default:
+		return nil, ErrUnsupportedFormat
+	}
+
+	header := make([]interface{}, len(columns))
+	for i, column := range columns {
+		header[i] = column
+	}
+	if err := out.WriteRow(header...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+type csvWriter struct {
+	w   *csv.Writer
+	buf []string
+}
+
+func (c *csvWriter) WriteRow(values ...interface{}) error {
+	c.buf = c.buf[:0]
+	for _, value := range values {
+		switch v := value.(type) {
+		case nil:
+			c.buf = append(c.buf, "")
+		case string:
+			c.buf = append(c.buf, v)
+		case time.Time:
+			c.buf = append(c.buf, v.Format(time.RFC3339))
+		default:
+			c.buf = append(c.buf, fmt.Sprint(v))
+		}
+	}
+	return c.w.Write(c.buf)
+}
+
+func (c *csvWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+type ndjsonWriter struct {
+	w       *bufio.Writer
+	columns []string
+}
+
+func (n *ndjsonWriter) WriteRow(values ...interface{}) error {
+	if len(values) != len(n.columns) {
+		return fmt.Errorf("export: %d values for %d columns", len(values), len(n.columns))
+	}
+
+	// written by hand to keep the column order of the export
+	if err := n.w.WriteByte('{'); err != nil {
+		return err
+	}
+	for i, value := range values {
+		if i > 0 {
+			if err := n.w.WriteByte(','); err != nil {
+				return err
+			}
+		}
+		key, err := json.Marshal(n.columns[i])
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		n.w.Write(key)
+		n.w.WriteByte(':')
+		if _, err = n.w.Write(data); err != nil {
+			return err
+		}
+	}
+	_, err := n.w.WriteString("}\n")
+	return err
+}
+
+func (n *ndjsonWriter) Close() error {
+	return n.w.Flush()
+}
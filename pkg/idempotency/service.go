@@ -0,0 +1,131 @@
+package idempotency
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+
+	"github.com/ehsontjk/crud/pkg/logger"
+)
+
+var (
+	ErrInternal        = errors.New("internal error")
+	ErrInProgress      = errors.New("request with this idempotency key is in progress")
+	ErrPayloadMismatch = errors.New("idempotency key reused with different payload")
+)
+
+const DefaultTTL = 24 * time.Hour
+
+// DefaultLease is how long a key stays reserved for a request that has not
+// finished. Once it runs out the key is taken to belong to a request that
+// died before it could complete or release it, and a retry may reclaim it.
+const DefaultLease = time.Minute
+
+type Service struct {
+	db    *pgxpool.Pool
+	log   *logger.Logger
+	ttl   time.Duration
+	lease time.Duration
+}
+
+func NewService(db *pgxpool.Pool, log *logger.Logger) *Service {
+	return &Service{db: db, log: log, ttl: DefaultTTL, lease: DefaultLease}
+}
+
+// Record is a stored response that is replayed for retries.
+type Record struct {
+	Status      int
+	ContentType string
+	Body        []byte
+}
+
+// Hash returns a fingerprint of a JSON payload that does not depend on
+// whitespace or key order. Payloads that are not valid JSON are hashed as is.
+func Hash(payload []byte) string {
+	var generic interface{}
+	if err := json.Unmarshal(payload, &generic); err == nil {
+		if canonical, err := json.Marshal(generic); err == nil {
+			payload = canonical
+		}
+	}
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
+
+// Begin reserves the key for the owner. It returns (nil, nil) when the caller
+// should process the request, or the stored record when it must be replayed.
+func (s *Service) Begin(ctx context.Context, scope string, ownerID int64, key, hash string) (*Record, error) {
+
+	sqlstmt := `delete from idempotency_keys where scope = $1 and owner_id = $2 and key = $3
+	and (expire < current_timestamp or (status is null and created < current_timestamp - $4 * interval '1 second'))`
+	_, err := s.db.Exec(ctx, sqlstmt, scope, ownerID, key, int64(s.lease/time.Second))
+	if err != nil {
+		s.log.Error(ctx, "purge idempotency key", "err", err)
+		return nil, ErrInternal
+	}
+
+	sqlstmt = `insert into idempotency_keys(scope, owner_id, key, request_hash, expire)
+	values ($1, $2, $3, $4, current_timestamp + $5 * interval '1 second')
+	on conflict do nothing`
+	tag, err := s.db.Exec(ctx, sqlstmt, scope, ownerID, key, hash, int64(s.ttl/time.Second))
+	if err != nil {
+		s.log.Error(ctx, "reserve idempotency key", "err", err)
+		return nil, ErrInternal
+	}
+	if tag.RowsAffected() == 1 {
+		return nil, nil
+	}
+
+	var storedHash string
+	var status *int
+	record := &Record{}
+	err = s.db.QueryRow(ctx, `select request_hash, status, content_type, response from idempotency_keys where scope = $1 and owner_id = $2 and key = $3`,
+		scope, ownerID, key).Scan(&storedHash, &status, &record.ContentType, &record.Body)
+	if err == pgx.ErrNoRows {
+		// released by a concurrent failed request between insert and select
+		return nil, ErrInProgress
+	}
+	if err != nil {
+		s.log.Error(ctx, "get idempotency key", "err", err)
+		return nil, ErrInternal
+	}
+
+	if storedHash != hash {
+		return nil, ErrPayloadMismatch
+	}
+	if status == nil {
+		return nil, ErrInProgress
+	}
+	record.Status = *status
+	return record, nil
+}
+
+func (s *Service) Complete(ctx context.Context, scope string, ownerID int64, key string, record *Record) error {
+
+	sqlstmt := `update idempotency_keys set status = $4, content_type = $5, response = $6
+	where scope = $1 and owner_id = $2 and key = $3`
+	_, err := s.db.Exec(ctx, sqlstmt, scope, ownerID, key, record.Status, record.ContentType, record.Body)
+	if err != nil {
+		s.log.Error(ctx, "complete idempotency key", "err", err)
+		return ErrInternal
+	}
+	return nil
+}
+
+// Release drops an unfinished reservation so that the client may retry.
+func (s *Service) Release(ctx context.Context, scope string, ownerID int64, key string) error {
+
+	_, err := s.db.Exec(ctx, `delete from idempotency_keys where scope = $1 and owner_id = $2 and key = $3 and status is null`,
+		scope, ownerID, key)
+	if err != nil {
+		s.log.Error(ctx, "release idempotency key", "err", err)
+		return ErrInternal
+	}
+	return nil
+}
@@ -0,0 +1,293 @@
+package images
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+
+	"github.com/ehsontjk/crud/pkg/blobstore"
+	"github.com/ehsontjk/crud/pkg/logger"
+	"github.com/ehsontjk/crud/pkg/productcache"
+)
+
+var (
+	ErrNotFound        = errors.New("item not found")
+	ErrInternal        = errors.New("internal error")
+	ErrTooLarge        = errors.New("image is too large")
+	ErrUnsupportedType = errors.New("unsupported image type")
+	ErrInvalidImage    = errors.New("invalid image")
+)
+
+const (
+	// MaxSize is the largest accepted upload in bytes.
+	MaxSize = 10 << 20
+	// maxPixels guards against small files that decode into huge bitmaps.
+	maxPixels = 40000000
+)
+
+// Size is a thumbnail variant that fits into a Max x Max box.
+type Size struct {
+	Name string
+	Max  int
+}
+
+var Sizes = []Size{
+	{Name: "thumb", Max: 150},
+	{Name: "small", Max: 400},
+	{Name: "medium", Max: 800},
+}
+
+var allowed = map[string]string{
+	"image/jpeg": "jpg",
+	"image/png":  "png",
+	"image/gif":  "gif",
+	"image/webp": "webp",
+}
+
+// Image is an uploaded product picture. URL points at the original file,
+// Thumbnails at the resized variants keyed by size name.
+type Image struct {
+	ID          int64             `json:"id"`
+	ProductID   int64             `json:"product_id"`
+	ContentType string            `json:"content_type"`
+	Width       int               `json:"width"`
+	Height      int               `json:"height"`
+	URL         string            `json:"url"`
+	Thumbnails  map[string]string `json:"thumbnails"`
+	Created     time.Time         `json:"created"`
+}
+
+type Service struct {
+	db    *pgxpool.Pool
+	log   *logger.Logger
+	store blobstore.BlobStore
+	cache *productcache.Cache
+}
+
+func NewService(db *pgxpool.Pool, log *logger.Logger, store blobstore.BlobStore, cache *productcache.Cache) *Service {
+	return &Service{db: db, log: log, store: store, cache: cache}
+}
+
+func originalKey(productID, imageID int64, contentType string) string {
+	return fmt.Sprintf("products/%d/%d/original.%s", productID, imageID, allowed[contentType])
+}
+
+// thumbnails keep transparency as PNG, everything else becomes JPEG
+func thumbnailExt(contentType string) string {
+	if contentType == "image/png" || contentType == "image/gif" {
+		return "png"
+	}
+	return "jpg"
+}
+
+func thumbnailKey(productID, imageID int64, contentType string, size Size) string {
+	return fmt.Sprintf("products/%d/%d/%s.%s", productID, imageID, size.Name, thumbnailExt(contentType))
+}
+
+func (s *Service) withURLs(item *Image) *Image {
+	item.URL = s.store.URL(originalKey(item.ProductID, item.ID, item.ContentType))
+	item.Thumbnails = make(map[string]string, len(Sizes))
+	for _, size := range Sizes {
+		item.Thumbnails[size.Name] = s.store.URL(thumbnailKey(item.ProductID, item.ID, item.ContentType, size))
+	}
+	return item
+}
+
+// Thumbnail scales img down to fit into a max x max box keeping its aspect
+// ratio. Smaller images are returned as they are.
+func Thumbnail(img image.Image, max int) image.Image {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w <= max && h <= max {
+		return img
+	}
+	if w >= h {
+		h, w = h*max/w, max
+	} else {
+		w, h = w*max/h, max
+	}
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Src, nil)
+	return dst
+}
+
+func encodeThumbnail(img image.Image, contentType string) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	if thumbnailExt(contentType) == "png" {
+		err = png.Encode(&buf, img)
+	} else {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85})
+	}
+	return buf.Bytes(), err
+}
+
+// Upload validates the picture, stores it with its thumbnails and attaches
+// it to the product. The size and type are checked on the content itself,
+// not on what the client claims.
+func (s *Service) Upload(ctx context.Context, productID int64, r io.Reader) (*Image, error) {
+
+	data, err := ioutil.ReadAll(io.LimitReader(r, MaxSize+1))
+	if err != nil {
+		return nil, ErrInvalidImage
+	}
+	if len(data) > MaxSize {
+		return nil, ErrTooLarge
+	}
+
+	contentType := http.DetectContentType(data)
+	if _, ok := allowed[contentType]; !ok {
+		return nil, ErrUnsupportedType
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || config.Width <= 0 || config.Height <= 0 {
+		return nil, ErrInvalidImage
+	}
+	if config.Width*config.Height > maxPixels {
+		return nil, ErrTooLarge
+	}
+
+	// only the first frame of an animation is used for thumbnails
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrInvalidImage
+	}
+
+	thumbnails := make([][]byte, len(Sizes))
+	for i, size := range Sizes {
+		if thumbnails[i], err = encodeThumbnail(Thumbnail(img, size.Max), contentType); err != nil {
+			s.log.Error(ctx, "encode thumbnail", "err", err)
+			return nil, ErrInternal
+		}
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		s.log.Error(ctx, "begin tx", "err", err)
+		return nil, ErrInternal
+	}
+	defer tx.Rollback(ctx)
+
+	item := &Image{ProductID: productID, ContentType: contentType, Width: config.Width, Height: config.Height}
+	sqlstmt := `insert into product_images(product_id, content_type, width, height)
+	select id, $2, $3, $4 from products where id = $1 returning id, created`
+	err = tx.QueryRow(ctx, sqlstmt, productID, contentType, config.Width, config.Height).Scan(&item.ID, &item.Created)
+	if err == pgx.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		s.log.Error(ctx, "insert product image", "err", err)
+		return nil, ErrInternal
+	}
+
+	// blobs are written before the row commits, a failure leaves at most
+	// unreferenced files behind and never a row without files
+	keys := []string{originalKey(productID, item.ID, contentType)}
+	blobs := [][]byte{data}
+	for i, size := range Sizes {
+		keys = append(keys, thumbnailKey(productID, item.ID, contentType, size))
+		blobs = append(blobs, thumbnails[i])
+	}
+	for i, key := range keys {
+		if err = s.store.Put(ctx, key, bytes.NewReader(blobs[i])); err != nil {
+			s.log.Error(ctx, "store image", "key", key, "err", err)
+			s.deleteBlobs(ctx, keys[:i])
+			return nil, ErrInternal
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		s.log.Error(ctx, "commit product image", "err", err)
+		s.deleteBlobs(ctx, keys)
+		return nil, ErrInternal
+	}
+	s.cache.Invalidate(ctx)
+
+	s.log.Info(ctx, "product image uploaded", "product_id", productID, "image_id", item.ID, "content_type", contentType)
+	return s.withURLs(item), nil
+}
+
+func (s *Service) deleteBlobs(ctx context.Context, keys []string) {
+	for _, key := range keys {
+		if err := s.store.Delete(ctx, key); err != nil {
+			s.log.Warn(ctx, "delete image blob", "key", key, "err", err)
+		}
+	}
+}
+
+func (s *Service) Remove(ctx context.Context, productID, imageID int64) error {
+
+	var contentType string
+	sqlstmt := `delete from product_images where id = $1 and product_id = $2 returning content_type`
+	err := s.db.QueryRow(ctx, sqlstmt, imageID, productID).Scan(&contentType)
+	if err == pgx.ErrNoRows {
+		return ErrNotFound
+	}
+	if err != nil {
+		s.log.Error(ctx, "remove product image", "err", err)
+		return ErrInternal
+	}
+	s.cache.Invalidate(ctx)
+
+	keys := []string{originalKey(productID, imageID, contentType)}
+	for _, size := range Sizes {
+		keys = append(keys, thumbnailKey(productID, imageID, contentType, size))
+	}
+	s.deleteBlobs(ctx, keys)
+	return nil
+}
+
+// ForProducts returns the images of the products in upload order, keyed by
+// product id.
+func (s *Service) ForProducts(ctx context.Context, productIDs []int64) (map[int64][]*Image, error) {
+
+	items := make(map[int64][]*Image)
+	if len(productIDs) == 0 {
+		return items, nil
+	}
+
+	sqlstmt := `select id, product_id, content_type, width, height, created from product_images
+	where product_id = any($1) order by product_id, id`
+	rows, err := s.db.Query(ctx, sqlstmt, productIDs)
+	if err != nil {
+		s.log.Error(ctx, "get product images", "err", err)
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		item := &Image{}
+		err = rows.Scan(&item.ID, &item.ProductID, &item.ContentType, &item.Width, &item.Height, &item.Created)
+		if err != nil {
+			s.log.Error(ctx, "scan product image", "err", err)
+			return nil, ErrInternal
+		}
+		items[item.ProductID] = append(items[item.ProductID], s.withURLs(item))
+	}
+	if err = rows.Err(); err != nil {
+		s.log.Error(ctx, "get product images", "err", err)
+		return nil, ErrInternal
+	}
+	return items, nil
+}
@@ -0,0 +1,504 @@
+package inventory
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+
+	"github.com/ehsontjk/crud/pkg/alerts"
+	"github.com/ehsontjk/crud/pkg/logger"
+	"github.com/ehsontjk/crud/pkg/productcache"
+)
+
+const (
+	foreignKeyViolation = "23503"
+	checkViolation      = "23514"
+)
+
+func isPgError(err error, code string) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == code
+}
+
+var (
+	ErrNotFound          = errors.New("item not found")
+	ErrInternal          = errors.New("internal error")
+	ErrInvalidMovement   = errors.New("invalid stock movement")
+	ErrReasonRequired    = errors.New("reason is required")
+	ErrInsufficientStock = errors.New("insufficient stock")
+	ErrInvalidTransfer   = errors.New("invalid store transfer")
+)
+
+type Kind string
+
+const (
+	Receipt    Kind = "receipt"
+	Sale       Kind = "sale"
+	Return     Kind = "return"
+	Adjustment Kind = "adjustment"
+	WriteOff   Kind = "write_off"
+	Transfer   Kind = "transfer"
+)
+
+// Movement is a single change of a product's stock in a store. Qty is
+// signed: receipts and returns are positive, sales and write-offs are
+// negative, adjustments and transfers may be either. Balance is the
+// product's qty in the store right after the movement; products.qty holds
+// the total over all stores.
+type Movement struct {
+	ID         int64     `json:"id"`
+	ProductID  int64     `json:"product_id"`
+	StoreID    int64     `json:"store_id"`
+	Kind       Kind      `json:"kind"`
+	Qty        int       `json:"qty"`
+	Balance    int       `json:"balance"`
+	ManagerID  int64     `json:"manager_id"`
+	SaleID     int64     `json:"sale_id"`
+	TransferID int64     `json:"transfer_id"`
+	Reason     string    `json:"reason"`
+	Created    time.Time `json:"created"`
+}
+
+type TransferItem struct {
+	ProductID int64 `json:"product_id"`
+	Qty       int   `json:"qty"`
+}
+
+// StoreTransfer moves goods from one store to another. Each item becomes a
+// pair of transfer movements, so the total stock does not change.
+type StoreTransfer struct {
+	ID          int64           `json:"id"`
+	FromStoreID int64           `json:"from_store_id"`
+	ToStoreID   int64           `json:"to_store_id"`
+	ManagerID   int64           `json:"manager_id"`
+	Reason      string          `json:"reason"`
+	Items       []*TransferItem `json:"items"`
+	Created     time.Time       `json:"created"`
+}
+
+// StoreStock is the qty of a product in one store.
+type StoreStock struct {
+	StoreID   int64  `json:"store_id"`
+	StoreName string `json:"store_name"`
+	Qty       int    `json:"qty"`
+}
+
+// LowStockProduct is an active product whose qty is at or below its reorder level.
+type LowStockProduct struct {
+	ID           int64  `json:"id"`
+	Name         string `json:"name"`
+	Qty          int    `json:"qty"`
+	ReorderLevel int    `json:"reorder_level"`
+}
+
+type Service struct {
+	db      *pgxpool.Pool
+	log     *logger.Logger
+	checker *alerts.Checker
+	cache   *productcache.Cache
+}
+
+func NewService(db *pgxpool.Pool, log *logger.Logger, checker *alerts.Checker, cache *productcache.Cache) *Service {
+	return &Service{db: db, log: log, checker: checker, cache: cache}
+}
+
+// StockChanged must be called after a transaction with movements commits.
+func (s *Service) StockChanged(ctx context.Context) {
+	s.checker.Poke()
+	s.cache.Invalidate(ctx)
+}
+
+func validate(m *Movement) error {
+	switch m.Kind {
+	case Receipt, Return:
+		if m.Qty <= 0 {
+			return ErrInvalidMovement
+		}
+	case Sale, WriteOff:
+		if m.Qty >= 0 {
+			return ErrInvalidMovement
+		}
+	case Adjustment, Transfer:
+		if m.Qty == 0 {
+			return ErrInvalidMovement
+		}
+	default:
+		return ErrInvalidMovement
+	}
+	if (m.Kind == Adjustment || m.Kind == WriteOff) && m.Reason == "" {
+		return ErrReasonRequired
+	}
+	return nil
+}
+
+func defaultStore(ctx context.Context, tx pgx.Tx) (int64, error) {
+	var id int64
+	err := tx.QueryRow(ctx, `select id from stores where is_default`).Scan(&id)
+	return id, err
+}
+
+// Apply records the movement and updates the store's and the product's
+// stock inside tx. Together with ApplyCounts it is the only place where
+// product stock changes. Without a StoreID the movement goes to the store of
+// its sale, or to the default store.
+func (s *Service) Apply(ctx context.Context, tx pgx.Tx, m *Movement) error {
+
+	if err := validate(m); err != nil {
+		return err
+	}
+
+	var err error
+	if m.StoreID == 0 && m.SaleID != 0 {
+		err = tx.QueryRow(ctx, `select coalesce(store_id, 0) from sales where id = $1`, m.SaleID).Scan(&m.StoreID)
+	}
+	if err == nil && m.StoreID == 0 {
+		m.StoreID, err = defaultStore(ctx, tx)
+	}
+	if err != nil {
+		s.log.Error(ctx, "resolve movement store", "err", err)
+		return ErrInternal
+	}
+
+	// the product row is locked first so that concurrent movements of a
+	// product in different stores keep the total consistent
+	var total int
+	err = tx.QueryRow(ctx, `select qty from products where id = $1 for update`, m.ProductID).Scan(&total)
+	if err == pgx.ErrNoRows {
+		return ErrNotFound
+	}
+	if err != nil {
+		s.log.Error(ctx, "lock product stock", "err", err)
+		return ErrInternal
+	}
+
+	var qty int
+	sqlstmt := `insert into store_stock(store_id, product_id) values ($1, $2)
+	on conflict (store_id, product_id) do update set qty = store_stock.qty returning qty`
+	err = tx.QueryRow(ctx, sqlstmt, m.StoreID, m.ProductID).Scan(&qty)
+	if isPgError(err, foreignKeyViolation) {
+		return ErrNotFound
+	}
+	if err != nil {
+		s.log.Error(ctx, "lock store stock", "err", err)
+		return ErrInternal
+	}
+
+	if qty+m.Qty < 0 {
+		return ErrInsufficientStock
+	}
+	m.Balance = qty + m.Qty
+
+	_, err = tx.Exec(ctx, `update store_stock set qty = $1 where store_id = $2 and product_id = $3`, m.Balance, m.StoreID, m.ProductID)
+	if err != nil {
+		s.log.Error(ctx, "update store qty", "err", err)
+		return ErrInternal
+	}
+	if _, err = tx.Exec(ctx, `update products set qty = $1 where id = $2`, total+m.Qty, m.ProductID); err != nil {
+		s.log.Error(ctx, "update product qty", "err", err)
+		return ErrInternal
+	}
+
+	sqlstmt = `insert into stock_movements(product_id, store_id, kind, qty, balance, manager_id, sale_id, transfer_id, reason)
+	values ($1, $2, $3, $4, $5, nullif($6, 0), nullif($7, 0), nullif($8, 0), $9) returning id, created`
+	err = tx.QueryRow(ctx, sqlstmt, m.ProductID, m.StoreID, m.Kind, m.Qty, m.Balance, m.ManagerID, m.SaleID, m.TransferID, m.Reason).
+		Scan(&m.ID, &m.Created)
+	if err != nil {
+		s.log.Error(ctx, "insert stock movement", "err", err)
+		return ErrInternal
+	}
+
+	return nil
+}
+
+// Count sets a product's total stock to an absolute quantity, e.g. from a
+// catalog import. Kind must be Receipt or Adjustment.
+type Count struct {
+	ProductID int64
+	Qty       int
+	Kind      Kind
+	Reason    string
+}
+
+// ApplyCounts brings every counted product to its total quantity inside tx
+// by moving the difference in or out of the default store, and records one
+// movement per product whose stock actually changed. It works set-based
+// through COPY so that large catalogs are applied in a few statements. It
+// returns the number of movements recorded.
+func (s *Service) ApplyCounts(ctx context.Context, tx pgx.Tx, managerID int64, counts []*Count) (int, error) {
+
+	if len(counts) == 0 {
+		return 0, nil
+	}
+
+	source := make([][]interface{}, 0, len(counts))
+	for _, c := range counts {
+		if c.Qty < 0 || (c.Kind != Receipt && c.Kind != Adjustment) {
+			return 0, ErrInvalidMovement
+		}
+		if c.Kind == Adjustment && c.Reason == "" {
+			return 0, ErrReasonRequired
+		}
+		source = append(source, []interface{}{c.ProductID, c.Qty, string(c.Kind), c.Reason})
+	}
+
+	storeID, err := defaultStore(ctx, tx)
+	if err != nil {
+		s.log.Error(ctx, "get default store", "err", err)
+		return 0, ErrInternal
+	}
+
+	_, err = tx.Exec(ctx, `create temp table stock_counts (
+		product_id bigint primary key, qty integer not null, kind text not null, reason text not null
+	) on commit drop`)
+	if err != nil {
+		s.log.Error(ctx, "create stock counts", "err", err)
+		return 0, ErrInternal
+	}
+	_, err = tx.CopyFrom(ctx, pgx.Identifier{"stock_counts"}, []string{"product_id", "qty", "kind", "reason"}, pgx.CopyFromRows(source))
+	if err != nil {
+		s.log.Error(ctx, "copy stock counts", "err", err)
+		return 0, ErrInvalidMovement
+	}
+
+	var locked, invalid int
+	err = tx.QueryRow(ctx, `select count(*) from (
+		select id from products where id in (select product_id from stock_counts) order by id for update
+	) p`).Scan(&locked)
+	if err != nil {
+		s.log.Error(ctx, "lock product stock", "err", err)
+		return 0, ErrInternal
+	}
+	if locked != len(counts) {
+		return 0, ErrNotFound
+	}
+
+	// a receipt can only add stock
+	err = tx.QueryRow(ctx, `select count(*) from stock_counts c join products p on p.id = c.product_id
+	where c.kind = 'receipt' and c.qty < p.qty`).Scan(&invalid)
+	if err != nil {
+		s.log.Error(ctx, "check stock counts", "err", err)
+		return 0, ErrInternal
+	}
+	if invalid > 0 {
+		return 0, ErrInvalidMovement
+	}
+
+	_, err = tx.Exec(ctx, `insert into store_stock(store_id, product_id) select $1, product_id from stock_counts
+	on conflict (store_id, product_id) do nothing`, storeID)
+	if err != nil {
+		s.log.Error(ctx, "create store stock", "err", err)
+		return 0, ErrInternal
+	}
+
+	sqlstmt := `with changed as (
+		select p.id, c.qty - p.qty as delta, c.kind, c.reason
+		from products p join stock_counts c on c.product_id = p.id
+		where p.qty <> c.qty
+	), totals as (
+		update products p set qty = p.qty + changed.delta from changed where p.id = changed.id
+	), stock as (
+		update store_stock ss set qty = ss.qty + changed.delta from changed
+		where ss.store_id = $2 and ss.product_id = changed.id
+		returning ss.product_id, ss.qty
+	)
+	insert into stock_movements(product_id, store_id, kind, qty, balance, manager_id, reason)
+	select changed.id, $2, changed.kind, changed.delta, stock.qty, nullif($1, 0), changed.reason
+	from changed join stock on stock.product_id = changed.id`
+	tag, err := tx.Exec(ctx, sqlstmt, managerID, storeID)
+	if isPgError(err, checkViolation) {
+		// other stores cannot give up stock on behalf of the default store
+		return 0, ErrInsufficientStock
+	}
+	if err != nil {
+		s.log.Error(ctx, "apply stock counts", "err", err)
+		return 0, ErrInternal
+	}
+	if _, err = tx.Exec(ctx, `drop table stock_counts`); err != nil {
+		s.log.Error(ctx, "drop stock counts", "err", err)
+		return 0, ErrInternal
+	}
+
+	return int(tag.RowsAffected()), nil
+}
+
+// Post applies a standalone movement such as a receipt or a write-off.
+func (s *Service) Post(ctx context.Context, m *Movement) (*Movement, error) {
+
+	if m.Kind == Sale || m.Kind == Return || m.Kind == Transfer {
+		return nil, ErrInvalidMovement
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		s.log.Error(ctx, "begin tx", "err", err)
+		return nil, ErrInternal
+	}
+	defer tx.Rollback(ctx)
+
+	if err = s.Apply(ctx, tx, m); err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		s.log.Error(ctx, "commit stock movement", "err", err)
+		return nil, ErrInternal
+	}
+
+	s.log.Info(ctx, "stock movement", "product_id", m.ProductID, "kind", m.Kind, "qty", m.Qty, "manager_id", m.ManagerID)
+	s.StockChanged(ctx)
+	return m, nil
+}
+
+func (s *Service) History(ctx context.Context, productID int64) ([]*Movement, error) {
+
+	items := make([]*Movement, 0)
+
+	sqlstmt := `select id, product_id, store_id, kind, qty, balance, coalesce(manager_id, 0), coalesce(sale_id, 0),
+		coalesce(transfer_id, 0), reason, created
+	from stock_movements where product_id = $1 order by id desc limit 500`
+	rows, err := s.db.Query(ctx, sqlstmt, productID)
+	if err != nil {
+		s.log.Error(ctx, "get stock movements", "err", err)
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		item := &Movement{}
+		err = rows.Scan(&item.ID, &item.ProductID, &item.StoreID, &item.Kind, &item.Qty, &item.Balance, &item.ManagerID, &item.SaleID,
+			&item.TransferID, &item.Reason, &item.Created)
+		if err != nil {
+			s.log.Error(ctx, "scan stock movement", "err", err)
+			return nil, ErrInternal
+		}
+		items = append(items, item)
+	}
+	if err = rows.Err(); err != nil {
+		s.log.Error(ctx, "get stock movements", "err", err)
+		return nil, ErrInternal
+	}
+
+	return items, nil
+}
+
+// LowStock lists products at or below their reorder level, limited to the
+// given categories unless categoryIDs is nil.
+func (s *Service) LowStock(ctx context.Context, categoryIDs []int64) ([]*LowStockProduct, error) {
+
+	items := make([]*LowStockProduct, 0)
+
+	sqlstmt := `select id, name, qty, reorder_level from products
+	where active = true and qty <= reorder_level and ($1::bigint[] is null or category_id = any($1))
+	order by qty - reorder_level, id limit 500`
+	rows, err := s.db.Query(ctx, sqlstmt, categoryIDs)
+	if err != nil {
+		s.log.Error(ctx, "get low stock products", "err", err)
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		item := &LowStockProduct{}
+		if err = rows.Scan(&item.ID, &item.Name, &item.Qty, &item.ReorderLevel); err != nil {
+			s.log.Error(ctx, "scan low stock product", "err", err)
+			return nil, ErrInternal
+		}
+		items = append(items, item)
+	}
+	if err = rows.Err(); err != nil {
+		s.log.Error(ctx, "get low stock products", "err", err)
+		return nil, ErrInternal
+	}
+
+	return items, nil
+}
+
+// MoveBetweenStores carries out the transfer in a single transaction.
+func (s *Service) MoveBetweenStores(ctx context.Context, t *StoreTransfer) (*StoreTransfer, error) {
+
+	if t.FromStoreID == 0 || t.ToStoreID == 0 || t.FromStoreID == t.ToStoreID || len(t.Items) == 0 {
+		return nil, ErrInvalidTransfer
+	}
+	for _, item := range t.Items {
+		if item.Qty <= 0 {
+			return nil, ErrInvalidTransfer
+		}
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		s.log.Error(ctx, "begin tx", "err", err)
+		return nil, ErrInternal
+	}
+	defer tx.Rollback(ctx)
+
+	sqlstmt := `insert into store_transfers(from_store_id, to_store_id, manager_id, reason)
+	select $1, $2, nullif($3, 0), $4 where (select count(*) from stores where id in ($1, $2) and active) = 2
+	returning id, created`
+	err = tx.QueryRow(ctx, sqlstmt, t.FromStoreID, t.ToStoreID, t.ManagerID, t.Reason).Scan(&t.ID, &t.Created)
+	if err == pgx.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		s.log.Error(ctx, "create store transfer", "err", err)
+		return nil, ErrInternal
+	}
+
+	for _, item := range t.Items {
+		out := &Movement{ProductID: item.ProductID, StoreID: t.FromStoreID, Kind: Transfer, Qty: -item.Qty,
+			ManagerID: t.ManagerID, TransferID: t.ID, Reason: t.Reason}
+		in := &Movement{ProductID: item.ProductID, StoreID: t.ToStoreID, Kind: Transfer, Qty: item.Qty,
+			ManagerID: t.ManagerID, TransferID: t.ID, Reason: t.Reason}
+		if err = s.Apply(ctx, tx, out); err != nil {
+			return nil, err
+		}
+		if err = s.Apply(ctx, tx, in); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		s.log.Error(ctx, "commit store transfer", "err", err)
+		return nil, ErrInternal
+	}
+	s.StockChanged(ctx)
+
+	s.log.Info(ctx, "store transfer", "transfer_id", t.ID, "from_store_id", t.FromStoreID, "to_store_id", t.ToStoreID,
+		"items", len(t.Items), "manager_id", t.ManagerID)
+	return t, nil
+}
+
+// Stock returns the product's qty in every active store.
+func (s *Service) Stock(ctx context.Context, productID int64) ([]*StoreStock, error) {
+
+	items := make([]*StoreStock, 0)
+
+	sqlstmt := `select st.id, st.name, coalesce(ss.qty, 0) from stores st
+	left join store_stock ss on ss.store_id = st.id and ss.product_id = $1
+	where st.active order by st.id`
+	rows, err := s.db.Query(ctx, sqlstmt, productID)
+	if err != nil {
+		s.log.Error(ctx, "get store stock", "err", err)
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		item := &StoreStock{}
+		if err = rows.Scan(&item.StoreID, &item.StoreName, &item.Qty); err != nil {
+			s.log.Error(ctx, "scan store stock", "err", err)
+			return nil, ErrInternal
+		}
+		items = append(items, item)
+	}
+	if err = rows.Err(); err != nil {
+		s.log.Error(ctx, "get store stock", "err", err)
+		return nil, ErrInternal
+	}
+
+	return items, nil
+}
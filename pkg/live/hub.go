@@ -0,0 +1,277 @@
+package live
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+
+	"github.com/ehsontjk/crud/pkg/alerts"
+	"github.com/ehsontjk/crud/pkg/logger"
+	"github.com/ehsontjk/crud/pkg/outbox"
+)
+
+var (
+	ErrNotFound = errors.New("item not found")
+	ErrInternal = errors.New("internal error")
+)
+
+// Event types pushed to the feed.
+const (
+	Sale     = "sale"
+	Return   = "return"
+	LowStock = "low_stock"
+)
+
+const (
+	// channel carries feed events between the app instances.
+	channel = "live_feed"
+	// buffer is how many events a slow subscriber may lag behind before
+	// events are dropped for it.
+	buffer       = 64
+	retryListen  = 5 * time.Second
+	HeartbeatGap = 30 * time.Second
+)
+
+// Event is an entry of the live feed. ManagerID is the manager whose sale
+// the event is about, 0 for online sales and stock events.
+type Event struct {
+	Type      string          `json:"type"`
+	ManagerID int64           `json:"manager_id"`
+	StoreID   int64           `json:"store_id"`
+	Data      json.RawMessage `json:"data"`
+	Created   time.Time       `json:"created"`
+}
+
+// Viewer decides which events a manager may see: admins see everything,
+// other managers the sales and returns of their team, that is their own and
+// those of everybody reporting to them directly or indirectly. Stock events
+// are shown to every manager.
+type Viewer struct {
+	ManagerID int64
+	IsAdmin   bool
+	Team      map[int64]bool
+}
+
+func (v *Viewer) Allows(e *Event) bool {
+	if v.IsAdmin || e.Type == LowStock {
+		return true
+	}
+	return v.Team[e.ManagerID]
+}
+
+// Subscription receives the events its viewer may see on C.
+type Subscription struct {
+	C      chan *Event
+	viewer *Viewer
+}
+
+// Hub fans feed events out to the streams open on this instance. Events
+// travel through Postgres NOTIFY, so every instance sees every event.
+type Hub struct {
+	db   *pgxpool.Pool
+	log  *logger.Logger
+	mu   sync.Mutex
+	subs map[*Subscription]bool
+}
+
+func NewHub(db *pgxpool.Pool, log *logger.Logger) *Hub {
+	return &Hub{db: db, log: log, subs: make(map[*Subscription]bool)}
+}
+
+// Viewer loads the manager's permissions and team.
+func (h *Hub) Viewer(ctx context.Context, managerID int64) (*Viewer, error) {
+
+	v := &Viewer{ManagerID: managerID, Team: make(map[int64]bool)}
+	err := h.db.QueryRow(ctx, `select is_admin from managers where id = $1 and active`, managerID).Scan(&v.IsAdmin)
+	if err == pgx.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		h.log.Error(ctx, "get feed viewer", "err", err)
+		return nil, ErrInternal
+	}
+
+	sqlstmt := `with recursive team as (
+		select id from managers where id = $1
+		union
+		select m.id from managers m join team on m.boss_id = team.id
+	) select id from team`
+	rows, err := h.db.Query(ctx, sqlstmt, managerID)
+	if err != nil {
+		h.log.Error(ctx, "get manager team", "err", err)
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		if err = rows.Scan(&id); err != nil {
+			h.log.Error(ctx, "scan manager team", "err", err)
+			return nil, ErrInternal
+		}
+		v.Team[id] = true
+	}
+	if err = rows.Err(); err != nil {
+		h.log.Error(ctx, "get manager team", "err", err)
+		return nil, ErrInternal
+	}
+	return v, nil
+}
+
+func (h *Hub) Subscribe(viewer *Viewer) *Subscription {
+	sub := &Subscription{C: make(chan *Event, buffer), viewer: viewer}
+	h.mu.Lock()
+	h.subs[sub] = true
+	h.mu.Unlock()
+	return sub
+}
+
+func (h *Hub) Unsubscribe(sub *Subscription) {
+	h.mu.Lock()
+	delete(h.subs, sub)
+	h.mu.Unlock()
+}
+
+func (h *Hub) broadcast(e *Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sub := range h.subs {
+		if !sub.viewer.Allows(e) {
+			continue
+		}
+		select {
+		case sub.C <- e:
+		default:
+		}
+	}
+}
+
+// Run listens for feed events until ctx is done.
+func (h *Hub) Run(ctx context.Context) {
+	for {
+		err := h.listen(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		h.log.Warn(ctx, "live feed listener stopped", "err", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retryListen):
+		}
+	}
+}
+
+func (h *Hub) listen(ctx context.Context) error {
+
+	conn, err := h.db.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+	// a listening connection must not go back to the pool
+	defer conn.Conn().Close(context.Background())
+
+	if _, err = conn.Exec(ctx, `listen `+channel); err != nil {
+		return err
+	}
+	for {
+		n, err := conn.Conn().WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		e := &Event{}
+		if err = json.Unmarshal([]byte(n.Payload), e); err != nil {
+			h.log.Warn(ctx, "invalid live feed event", "err", err)
+			continue
+		}
+		h.broadcast(e)
+	}
+}
+
+type querier interface {
+	Exec(ctx context.Context, sql string, arguments ...interface{}) (pgconn.CommandTag, error)
+}
+
+func (h *Hub) notify(ctx context.Context, q querier, e *Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = q.Exec(ctx, `select pg_notify($1, $2)`, channel, string(data))
+	return err
+}
+
+// Handle turns sales and returns from the outbox into feed events. It runs
+// as an outbox consumer, so the notification goes out once, on commit.
+// Payloads are kept small because NOTIFY limits them to 8000 bytes.
+func (h *Hub) Handle(ctx context.Context, tx pgx.Tx, e *outbox.Event) error {
+
+	var summary interface{}
+	feed := &Event{Created: e.Created}
+
+	switch e.Type {
+	case outbox.SaleCreated:
+		var sale struct {
+			ID         int64  `json:"id"`
+			ManagerID  int64  `json:"manager_id"`
+			CustomerID int64  `json:"customer_id"`
+			Channel    string `json:"channel"`
+			StoreID    int64  `json:"store_id"`
+			Gross      int    `json:"gross"`
+			Discount   int    `json:"discount"`
+			Total      int    `json:"total"`
+		}
+		if err := json.Unmarshal(e.Payload, &sale); err != nil {
+			h.log.Error(ctx, "decode sale event", "event_id", e.ID, "err", err)
+			return nil
+		}
+		feed.Type, feed.ManagerID, feed.StoreID, summary = Sale, sale.ManagerID, sale.StoreID, sale
+
+	case outbox.ReturnCreated:
+		var item struct {
+			ID        int64  `json:"id"`
+			SaleID    int64  `json:"sale_id"`
+			ManagerID int64  `json:"manager_id"`
+			Reason    string `json:"reason"`
+			Refund    int    `json:"refund"`
+		}
+		if err := json.Unmarshal(e.Payload, &item); err != nil {
+			h.log.Error(ctx, "decode return event", "event_id", e.ID, "err", err)
+			return nil
+		}
+		// a return belongs to the team that made the sale
+		sqlstmt := `select coalesce(manager_id, 0), coalesce(store_id, 0) from sales where id = $1`
+		if err := tx.QueryRow(ctx, sqlstmt, item.SaleID).Scan(&feed.ManagerID, &feed.StoreID); err != nil {
+			h.log.Error(ctx, "get returned sale", "err", err)
+			return ErrInternal
+		}
+		feed.Type, summary = Return, item
+
+	default:
+		return nil
+	}
+
+	data, err := json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+	feed.Data = data
+	return h.notify(ctx, tx, feed)
+}
+
+// Notify publishes a low stock alert to the feed, which makes the hub an
+// alerts.Notifier.
+func (h *Hub) Notify(ctx context.Context, alert *alerts.Alert) error {
+	data, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	return h.notify(ctx, h.db, &Event{Type: LowStock, Data: data, Created: alert.Created})
+}
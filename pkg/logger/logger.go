@@ -0,0 +1,195 @@
+package logger
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Level int
+
+const (
+	DEBUG Level = iota
+	INFO
+	WARN
+	ERROR
+)
+
+var levelNames = map[Level]string{
+	DEBUG: "debug",
+	INFO:  "info",
+	WARN:  "warn",
+	ERROR: "error",
+}
+
+func (l Level) String() string {
+	if name, ok := levelNames[l]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+func ParseLevel(s string) (Level, error) {
+	for level, name := range levelNames {
+		if strings.EqualFold(name, s) {
+			return level, nil
+		}
+	}
+	return INFO, fmt.Errorf("unknown log level %q", s)
+}
+
+const Redacted = "[REDACTED]"
+
+// sensitive holds key fragments whose values are never written to the log.
+var sensitive = []string{"password", "token", "secret", "authorization", "cookie"}
+
+var requestIDContextKey = &contextKey{"request id"}
+
+type contextKey struct {
+	name string
+}
+
+func (c *contextKey) String() string {
+	return c.name
+}
+
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDContextKey, id)
+}
+
+func RequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	if value, ok := ctx.Value(requestIDContextKey).(string); ok {
+		return value
+	}
+	return ""
+}
+
+// Logger writes one JSON object per line. Key/value pairs are passed as
+// alternating arguments, like in Info(ctx, "sale created", "sale_id", id).
+type Logger struct {
+	mu    sync.Mutex
+	out   io.Writer
+	level Level
+	now   func() time.Time
+}
+
+func New() *Logger {
+	level, err := ParseLevel(os.Getenv("LOG_LEVEL"))
+	if err != nil {
+		level = INFO
+	}
+	return NewWithWriter(os.Stdout, level)
+}
+
+func NewWithWriter(out io.Writer, level Level) *Logger {
+	return &Logger{out: out, level: level, now: time.Now}
+}
+
+func (l *Logger) Debug(ctx context.Context, msg string, kv ...interface{}) {
+	l.write(ctx, DEBUG, msg, kv)
+}
+
+func (l *Logger) Info(ctx context.Context, msg string, kv ...interface{}) {
+	l.write(ctx, INFO, msg, kv)
+}
+
+func (l *Logger) Warn(ctx context.Context, msg string, kv ...interface{}) {
+	l.write(ctx, WARN, msg, kv)
+}
+
+func (l *Logger) Error(ctx context.Context, msg string, kv ...interface{}) {
+	l.write(ctx, ERROR, msg, kv)
+}
+
+func (l *Logger) write(ctx context.Context, level Level, msg string, kv []interface{}) {
+	if l == nil || level < l.level {
+		return
+	}
+
+	entry := make(map[string]interface{}, len(kv)/2+4)
+	for i := 0; i < len(kv); i += 2 {
+		key := fmt.Sprint(kv[i])
+		var value interface{} = "(MISSING)"
+		if i+1 < len(kv) {
+			value = kv[i+1]
+		}
+		entry[key] = redact(key, value)
+	}
+	entry["time"] = l.now().UTC().Format(time.RFC3339Nano)
+	entry["level"] = level.String()
+	entry["msg"] = msg
+	if id := RequestID(ctx); id != "" {
+		entry["request_id"] = id
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		data = []byte(fmt.Sprintf(`{"level":"error","msg":"log marshal failed: %s"}`, err))
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, _ = l.out.Write(append(data, '\n'))
+}
+
+func IsSensitive(key string) bool {
+	key = strings.ToLower(key)
+	for _, s := range sensitive {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func redact(key string, value interface{}) interface{} {
+	if IsSensitive(key) {
+		return Redacted
+	}
+	switch v := value.(type) {
+	case nil, bool, string, int, int32, int64, uint, uint32, uint64, float32, float64:
+		return v
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	}
+
+	// structs and maps may carry credentials in nested fields, so they are
+	// flattened to their JSON form and scrubbed key by key
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	var generic interface{}
+	if err = json.Unmarshal(data, &generic); err != nil {
+		return fmt.Sprint(value)
+	}
+	return scrub(generic)
+}
+
+func scrub(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			if IsSensitive(key) {
+				v[key] = Redacted
+				continue
+			}
+			v[key] = scrub(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = scrub(item)
+		}
+	}
+	return value
+}
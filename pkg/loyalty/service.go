@@ -0,0 +1,467 @@
+package loyalty
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+
+	"github.com/ehsontjk/crud/pkg/logger"
+)
+
+var (
+	ErrNotFound           = errors.New("item not found")
+	ErrInternal           = errors.New("internal error")
+	ErrInvalidPoints      = errors.New("invalid loyalty points")
+	ErrInsufficientPoints = errors.New("insufficient loyalty points")
+	ErrReasonRequired     = errors.New("adjustment reason is required")
+)
+
+type Kind string
+
+const (
+	Earn       Kind = "earn"
+	Redeem     Kind = "redeem"
+	Expire     Kind = "expire"
+	Adjustment Kind = "adjustment"
+	Reversal   Kind = "reversal"
+)
+
+const (
+	DefaultEarnPercent = 1
+	DefaultExpiryDays  = 365
+	DefaultInterval    = time.Hour
+	// expiryNotice is how far ahead Balance reports points about to expire.
+	expiryNotice = 30 * 24 * time.Hour
+)
+
+// Config sets how many points a sale earns, as a percentage of the amount
+// paid, and how many days earned points stay valid. Zero ExpiryDays keeps
+// points forever. One point pays for one unit of money.
+type Config struct {
+	EarnPercent int
+	ExpiryDays  int
+}
+
+// Entry is a single change of a customer's points. Points is signed:
+// earnings are positive, redemptions and expiries are negative. Balance is
+// the customer's points right after the entry. ManagerID is set on entries
+// made by managers, which makes adjustments auditable.
+type Entry struct {
+	ID         int64      `json:"id"`
+	CustomerID int64      `json:"customer_id"`
+	Kind       Kind       `json:"kind"`
+	Points     int        `json:"points"`
+	Balance    int        `json:"balance"`
+	Expires    *time.Time `json:"expires,omitempty"`
+	SaleID     int64      `json:"sale_id"`
+	ManagerID  int64      `json:"manager_id"`
+	Reason     string     `json:"reason"`
+	Created    time.Time  `json:"created"`
+}
+
+type Balance struct {
+	CustomerID   int64      `json:"customer_id"`
+	Points       int        `json:"points"`
+	ExpiringSoon int        `json:"expiring_soon"`
+	NextExpiry   *time.Time `json:"next_expiry,omitempty"`
+}
+
+type Service struct {
+	db     *pgxpool.Pool
+	log    *logger.Logger
+	config Config
+}
+
+func NewService(db *pgxpool.Pool, log *logger.Logger, config Config) *Service {
+	return &Service{db: db, log: log, config: config}
+}
+
+// Points returns what a sale of the amount earns.
+func (s *Service) Points(amount int) int {
+	if amount <= 0 || s.config.EarnPercent <= 0 {
+		return 0
+	}
+	return amount * s.config.EarnPercent / 100
+}
+
+// lock opens the customer's account on first use and locks it for tx.
+func (s *Service) lock(ctx context.Context, tx pgx.Tx, customerID int64) (int, error) {
+	var balance int
+	sqlstmt := `insert into loyalty_accounts(customer_id) select id from customers where id = $1
+	on conflict (customer_id) do update set points = loyalty_accounts.points returning points`
+	err := tx.QueryRow(ctx, sqlstmt, customerID).Scan(&balance)
+	if err == pgx.ErrNoRows {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		s.log.Error(ctx, "lock loyalty balance", "err", err)
+		return 0, ErrInternal
+	}
+	return balance, nil
+}
+
+func (s *Service) record(ctx context.Context, tx pgx.Tx, e *Entry, remaining int) error {
+
+	if _, err := tx.Exec(ctx, `update loyalty_accounts set points = $1 where customer_id = $2`, e.Balance, e.CustomerID); err != nil {
+		s.log.Error(ctx, "update loyalty balance", "err", err)
+		return ErrInternal
+	}
+
+	sqlstmt := `insert into loyalty_entries(customer_id, kind, points, balance, remaining, expires, sale_id, manager_id, reason)
+	values ($1, $2, $3, $4, $5, $6, nullif($7, 0), nullif($8, 0), $9) returning id, created`
+	err := tx.QueryRow(ctx, sqlstmt, e.CustomerID, e.Kind, e.Points, e.Balance, remaining, e.Expires, e.SaleID, e.ManagerID, e.Reason).
+		Scan(&e.ID, &e.Created)
+	if err != nil {
+		s.log.Error(ctx, "insert loyalty entry", "err", err)
+		return ErrInternal
+	}
+	return nil
+}
+
+// expireLocked writes off the customer's points whose validity ended. The
+// customer's account must be locked.
+func (s *Service) expireLocked(ctx context.Context, tx pgx.Tx, customerID int64, balance int) (int, error) {
+
+	var expired int
+	sqlstmt := `with lots as (
+		select id, remaining from loyalty_entries
+		where customer_id = $1 and remaining > 0 and expires <= current_timestamp
+	), used as (
+		update loyalty_entries e set remaining = 0 from lots where e.id = lots.id
+	) select coalesce(sum(remaining), 0) from lots`
+	if err := tx.QueryRow(ctx, sqlstmt, customerID).Scan(&expired); err != nil {
+		s.log.Error(ctx, "expire loyalty points", "err", err)
+		return 0, ErrInternal
+	}
+	if expired == 0 {
+		return balance, nil
+	}
+
+	e := &Entry{CustomerID: customerID, Kind: Expire, Points: -expired, Balance: balance - expired}
+	if err := s.record(ctx, tx, e, 0); err != nil {
+		return 0, err
+	}
+	return e.Balance, nil
+}
+
+// apply records the entry inside tx. Positive entries become lots that
+// expire on their own, negative entries use up the lots that expire first.
+func (s *Service) apply(ctx context.Context, tx pgx.Tx, e *Entry) error {
+
+	balance, err := s.lock(ctx, tx, e.CustomerID)
+	if err != nil {
+		return err
+	}
+	if balance, err = s.expireLocked(ctx, tx, e.CustomerID, balance); err != nil {
+		return err
+	}
+	if balance+e.Points < 0 {
+		return ErrInsufficientPoints
+	}
+	e.Balance = balance + e.Points
+
+	remaining := 0
+	if e.Points > 0 {
+		remaining = e.Points
+		if s.config.ExpiryDays > 0 {
+			expires := time.Now().AddDate(0, 0, s.config.ExpiryDays)
+			e.Expires = &expires
+		}
+	} else {
+		sqlstmt := `with lots as (
+			select id, remaining, sum(remaining) over (order by expires nulls last, id) as running
+			from loyalty_entries where customer_id = $1 and remaining > 0
+		) update loyalty_entries e set remaining = greatest(0, least(lots.remaining, lots.running - $2))
+		from lots where e.id = lots.id and lots.running - lots.remaining < $2`
+		if _, err = tx.Exec(ctx, sqlstmt, e.CustomerID, -e.Points); err != nil {
+			s.log.Error(ctx, "use loyalty points", "err", err)
+			return ErrInternal
+		}
+	}
+
+	return s.record(ctx, tx, e, remaining)
+}
+
+// Earn credits the customer with the points for the amount paid in the
+// sale inside tx. Sales to unknown customers earn nothing.
+func (s *Service) Earn(ctx context.Context, tx pgx.Tx, customerID, saleID int64, amount int) (int, error) {
+
+	points := s.Points(amount)
+	if points == 0 || customerID == 0 {
+		return 0, nil
+	}
+
+	err := s.apply(ctx, tx, &Entry{CustomerID: customerID, Kind: Earn, Points: points, SaleID: saleID})
+	if err == ErrNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return points, nil
+}
+
+// Redeem pays part of the sale with the customer's points inside tx.
+func (s *Service) Redeem(ctx context.Context, tx pgx.Tx, customerID, saleID int64, points int) error {
+
+	if points <= 0 {
+		return ErrInvalidPoints
+	}
+	err := s.apply(ctx, tx, &Entry{CustomerID: customerID, Kind: Redeem, Points: -points, SaleID: saleID})
+	if err == ErrNotFound {
+		return ErrInsufficientPoints
+	}
+	return err
+}
+
+// Reasons of the reversals of sale points.
+const (
+	reasonCancelled = "sale cancelled"
+	reasonRefunded  = "sale refunded"
+)
+
+// salePoints sums up the entries of the sale: the points it earned and
+// redeemed, the points reversals already gave back (negative when they took
+// earned points away) and whether the sale was cancelled. customerID is 0
+// when the sale has no entries.
+func (s *Service) salePoints(ctx context.Context, tx pgx.Tx, saleID int64) (customerID int64, earned, redeemed, reversed int, cancelled bool, err error) {
+
+	sqlstmt := `select customer_id,
+		coalesce(sum(points) filter (where kind = 'earn'), 0),
+		coalesce(-sum(points) filter (where kind = 'redeem'), 0),
+		coalesce(sum(points) filter (where kind = 'reversal'), 0),
+		count(*) filter (where kind = 'reversal' and reason = $2) > 0
+	from loyalty_entries where sale_id = $1 group by customer_id`
+	err = tx.QueryRow(ctx, sqlstmt, saleID, reasonCancelled).Scan(&customerID, &earned, &redeemed, &reversed, &cancelled)
+	if err == pgx.ErrNoRows {
+		return 0, 0, 0, 0, false, nil
+	}
+	if err != nil {
+		s.log.Error(ctx, "get sale loyalty entries", "err", err)
+		return 0, 0, 0, 0, false, ErrInternal
+	}
+	return customerID, earned, redeemed, reversed, cancelled, nil
+}
+
+// reverse records a reversal of points for the sale. Points taken away are
+// limited to the customer's balance, those spent already are lost.
+func (s *Service) reverse(ctx context.Context, tx pgx.Tx, customerID, saleID int64, points int, reason string) error {
+
+	balance, err := s.lock(ctx, tx, customerID)
+	if err != nil {
+		return err
+	}
+	if balance, err = s.expireLocked(ctx, tx, customerID, balance); err != nil {
+		return err
+	}
+
+	if balance+points < 0 {
+		points = -balance
+	}
+	if points == 0 {
+		return nil
+	}
+	return s.apply(ctx, tx, &Entry{CustomerID: customerID, Kind: Reversal, Points: points, SaleID: saleID, Reason: reason})
+}
+
+// Reverse undoes the points of a cancelled sale inside tx: redeemed points
+// are given back and earned points taken away, as far as they were not
+// spent or taken back by refunds already.
+func (s *Service) Reverse(ctx context.Context, tx pgx.Tx, saleID int64) error {
+
+	customerID, earned, redeemed, reversed, cancelled, err := s.salePoints(ctx, tx, saleID)
+	if err != nil || customerID == 0 || cancelled {
+		return err
+	}
+	return s.reverse(ctx, tx, customerID, saleID, redeemed-earned-reversed, reasonCancelled)
+}
+
+// Refund takes back the share of the earned points that the refunds of the
+// sale stand for inside tx. refunded is the amount of all refunds of the
+// sale so far and total the amount paid for it.
+func (s *Service) Refund(ctx context.Context, tx pgx.Tx, saleID int64, refunded, total int) error {
+
+	if total <= 0 || refunded <= 0 {
+		return nil
+	}
+	if refunded > total {
+		refunded = total
+	}
+
+	customerID, earned, _, reversed, cancelled, err := s.salePoints(ctx, tx, saleID)
+	if err != nil || customerID == 0 || cancelled {
+		return err
+	}
+	points := -earned*refunded/total - reversed
+	if points >= 0 {
+		return nil
+	}
+	return s.reverse(ctx, tx, customerID, saleID, points, reasonRefunded)
+}
+
+// Adjust changes the customer's points by hand. The manager and the reason
+// are kept with the entry.
+func (s *Service) Adjust(ctx context.Context, managerID, customerID int64, points int, reason string) (*Entry, error) {
+
+	if points == 0 {
+		return nil, ErrInvalidPoints
+	}
+	if reason == "" {
+		return nil, ErrReasonRequired
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		s.log.Error(ctx, "begin tx", "err", err)
+		return nil, ErrInternal
+	}
+	defer tx.Rollback(ctx)
+
+	e := &Entry{CustomerID: customerID, Kind: Adjustment, Points: points, ManagerID: managerID, Reason: reason}
+	if err = s.apply(ctx, tx, e); err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		s.log.Error(ctx, "commit loyalty adjustment", "err", err)
+		return nil, ErrInternal
+	}
+
+	s.log.Info(ctx, "loyalty points adjusted", "entry_id", e.ID, "customer_id", customerID, "points", points,
+		"balance", e.Balance, "manager_id", managerID, "reason", reason)
+	return e, nil
+}
+
+// ExpireAll writes off overdue points of every customer and returns the
+// number of customers affected.
+func (s *Service) ExpireAll(ctx context.Context) (int, error) {
+
+	rows, err := s.db.Query(ctx, `select distinct customer_id from loyalty_entries where remaining > 0 and expires <= current_timestamp`)
+	if err != nil {
+		s.log.Error(ctx, "find expired loyalty points", "err", err)
+		return 0, ErrInternal
+	}
+	customerIDs := make([]int64, 0)
+	for rows.Next() {
+		var id int64
+		if err = rows.Scan(&id); err != nil {
+			rows.Close()
+			s.log.Error(ctx, "scan expired loyalty points", "err", err)
+			return 0, ErrInternal
+		}
+		customerIDs = append(customerIDs, id)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		s.log.Error(ctx, "find expired loyalty points", "err", err)
+		return 0, ErrInternal
+	}
+
+	for _, id := range customerIDs {
+		if err = s.expire(ctx, id); err != nil {
+			return 0, err
+		}
+	}
+	return len(customerIDs), nil
+}
+
+func (s *Service) expire(ctx context.Context, customerID int64) error {
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		s.log.Error(ctx, "begin tx", "err", err)
+		return ErrInternal
+	}
+	defer tx.Rollback(ctx)
+
+	balance, err := s.lock(ctx, tx, customerID)
+	if err != nil {
+		return err
+	}
+	if _, err = s.expireLocked(ctx, tx, customerID, balance); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		s.log.Error(ctx, "commit loyalty expiry", "err", err)
+		return ErrInternal
+	}
+	return nil
+}
+
+// Run expires overdue points periodically until ctx is done.
+func (s *Service) Run(ctx context.Context) {
+	ticker := time.NewTicker(DefaultInterval)
+	defer ticker.Stop()
+
+	for {
+		if n, err := s.ExpireAll(ctx); err == nil && n > 0 {
+			s.log.Info(ctx, "loyalty points expired", "customers", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Balance returns the customer's valid points. Points past their expiry
+// are not counted even if they were not written off yet.
+func (s *Service) Balance(ctx context.Context, customerID int64) (*Balance, error) {
+
+	item := &Balance{CustomerID: customerID}
+	soon := time.Now().Add(expiryNotice)
+	sqlstmt := `select coalesce(max(a.points), 0)
+		- coalesce(sum(e.remaining) filter (where e.expires <= current_timestamp), 0),
+		coalesce(sum(e.remaining) filter (where e.expires > current_timestamp and e.expires <= $2), 0),
+		min(e.expires) filter (where e.expires > current_timestamp)
+	from customers c left join loyalty_accounts a on a.customer_id = c.id
+	left join loyalty_entries e on e.customer_id = c.id and e.remaining > 0
+	where c.id = $1 group by c.id`
+	err := s.db.QueryRow(ctx, sqlstmt, customerID, soon).Scan(&item.Points, &item.ExpiringSoon, &item.NextExpiry)
+	if err == pgx.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		s.log.Error(ctx, "get loyalty balance", "err", err)
+		return nil, ErrInternal
+	}
+	return item, nil
+}
+
+// History returns the customer's latest entries, newest first.
+func (s *Service) History(ctx context.Context, customerID int64) ([]*Entry, error) {
+
+	items := make([]*Entry, 0)
+
+	sqlstmt := `select id, customer_id, kind, points, balance, expires, coalesce(sale_id, 0), coalesce(manager_id, 0), reason, created
+	from loyalty_entries where customer_id = $1 order by id desc limit 500`
+	rows, err := s.db.Query(ctx, sqlstmt, customerID)
+	if err != nil {
+		s.log.Error(ctx, "get loyalty history", "err", err)
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		item := &Entry{}
+		err = rows.Scan(&item.ID, &item.CustomerID, &item.Kind, &item.Points, &item.Balance, &item.Expires,
+			&item.SaleID, &item.ManagerID, &item.Reason, &item.Created)
+		if err != nil {
+			s.log.Error(ctx, "scan loyalty entry", "err", err)
+			return nil, ErrInternal
+		}
+		items = append(items, item)
+	}
+	if err = rows.Err(); err != nil {
+		s.log.Error(ctx, "get loyalty history", "err", err)
+		return nil, ErrInternal
+	}
+
+	return items, nil
+}
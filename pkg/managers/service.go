@@ -2,14 +2,23 @@ package managers
 
 import (
 	"context"
-	"log"
-	"strconv"
 	"time"
 	"errors"
 	"crypto/rand"
 	"encoding/hex"
+	"strconv"
 
 	"golang.org/x/crypto/bcrypt"
+
+	"github.com/ehsontjk/crud/pkg/inventory"
+	"github.com/ehsontjk/crud/pkg/logger"
+	"github.com/ehsontjk/crud/pkg/loyalty"
+	"github.com/ehsontjk/crud/pkg/orders"
+	"github.com/ehsontjk/crud/pkg/outbox"
+	"github.com/ehsontjk/crud/pkg/images"
+	"github.com/ehsontjk/crud/pkg/productcache"
+	"github.com/ehsontjk/crud/pkg/promotions"
+	"github.com/jackc/pgconn"
 	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 )
@@ -28,48 +37,128 @@ var (
 	ErrPhoneUsed = errors.New("phone alredy registered")
 	
 	ErrTokenExpired = errors.New("token expired")
+	
+	ErrInvalidPosition = errors.New("invalid sale position")
+	
+	ErrProductInactive = errors.New("product is not active")
+	
+	ErrInvalidDiscount = errors.New("invalid discount")
+	
+	ErrDiscountReasonRequired = errors.New("discount reason is required")
+	
+	ErrDiscountLimitExceeded = errors.New("discount limit exceeded")
+	
+	ErrInvalidDiscountLimit = errors.New("discount limit must be between 0 and 100")
+	
+	ErrCustomerInUse = errors.New("customer has history and cannot be removed")
+	
+	ErrInvalidChannel = errors.New("invalid sale channel")
+	
+	ErrSKUDuplicated = errors.New("sku already used by another product")
+	
+	ErrInvalidCategory = errors.New("invalid category")
+)
+
+
+const (
+	uniqueViolation     = "23505"
+	foreignKeyViolation = "23503"
+)
+
+
+const (
+	ChannelPOS    = "pos"
+	ChannelOnline = "online"
 )
 
 type Service struct {
-	db *pgxpool.Pool
+	db           *pgxpool.Pool
+	log          *logger.Logger
+	inventorySvc *inventory.Service
+	promotionSvc *promotions.Service
+	imageSvc     *images.Service
+	loyaltySvc   *loyalty.Service
+	cache        *productcache.Cache
 }
 
 
-func NewService(db *pgxpool.Pool) *Service {
-	return &Service{db: db}
+func NewService(db *pgxpool.Pool, log *logger.Logger, inventorySvc *inventory.Service, promotionSvc *promotions.Service, imageSvc *images.Service, loyaltySvc *loyalty.Service, cache *productcache.Cache) *Service {
+	return &Service{db: db, log: log, inventorySvc: inventorySvc, promotionSvc: promotionSvc, imageSvc: imageSvc, loyaltySvc: loyaltySvc, cache: cache}
 }
 
 
 type Manager struct {
-	ID          int64     `json:"id"`
-	Name        string    `json:"name"`
-	Salary      int64     `json:"salary"`
-	Plan        int64     `json:"plan"`
-	BossID      int64     `json:"boss_id"`
-	Departament string    `json:"departament"`
-	Phone       string    `json:"phone"`
-	Password    string    `json:"password"`
-	IsAdmin     bool      `json:"is_admin"`
-	Created     time.Time `json:"created"`
+	ID            int64     `json:"id"`
+	Name          string    `json:"name"`
+	Salary        int64     `json:"salary"`
+	Plan          int64     `json:"plan"`
+	BossID        int64     `json:"boss_id"`
+	Departament   string    `json:"departament"`
+	Phone         string    `json:"phone"`
+	Password      string    `json:"-"`
+	IsAdmin       bool      `json:"is_admin"`
+	DiscountLimit int       `json:"discount_limit"`
+	StoreID       int64     `json:"store_id"`
+	Active        bool      `json:"active"`
+	Created       time.Time `json:"created"`
 }
 
 
 type Product struct {
-	ID      int64     `json:"id"`
-	Name    string    `json:"name"`
-	Price   int       `json:"price"`
-	Qty     int       `json:"qty"`
-	Active  bool      `json:"active"`
-	Created time.Time `json:"created"`
+	ID           int64     `json:"id"`
+	SKU          string    `json:"sku"`
+	CategoryID   int64     `json:"category_id"`
+	Name         string    `json:"name"`
+	Price        int       `json:"price"`
+	Qty          int       `json:"qty"`
+	ReorderLevel int       `json:"reorder_level"`
+	Active       bool            `json:"active"`
+	Created      time.Time       `json:"created"`
+	Images       []*images.Image `json:"images"`
 }
 
 
 type Sale struct {
-	ID         int64           `json:"id"`
-	ManagerID  int64           `json:"manager_id"`
-	CustomerID int64           `json:"customer_id"`
-	Created    time.Time       `json:"created"`
-	Positions  []*SalePosition `json:"positions"`
+	ID           int64                 `json:"id"`
+	ManagerID    int64                 `json:"manager_id"`
+	CustomerID   int64                 `json:"customer_id"`
+	Channel      string                `json:"channel"`
+	StoreID      int64                 `json:"store_id"`
+	Status       string                `json:"status"`
+	Gross        int                   `json:"gross"`
+	Discount     int                   `json:"discount"`
+	Total        int                   `json:"total"`
+	Created      time.Time             `json:"created"`
+	Positions    []*SalePosition       `json:"positions"`
+	Discounts    []*SaleDiscount       `json:"discounts"`
+	PromoCodes   []string              `json:"promo_codes"`
+	Promotions   []*promotions.Applied `json:"promotions"`
+	RedeemPoints int                   `json:"redeem_points"`
+	PointsEarned int                   `json:"points_earned"`
+}
+
+
+// SalesTotals sums sales up: Gross at catalog prices, Discount given by
+// managers, promotions and loyalty points, Refunded for returns, and Net
+// actually earned. Cancelled sales are left out.
+type SalesTotals struct {
+	Gross    int `json:"gross"`
+	Discount int `json:"discount"`
+	Refunded int `json:"refunded"`
+	Net      int `json:"net"`
+}
+
+
+// SaleDiscount lowers the price of a sale below the catalog prices. With a
+// ProductID it applies to the positions of that product, otherwise to the
+// whole sale.
+type SaleDiscount struct {
+	ID        int64     `json:"id"`
+	SaleID    int64     `json:"sale_id"`
+	ProductID int64     `json:"product_id"`
+	Amount    int       `json:"amount"`
+	Reason    string    `json:"reason"`
+	Created   time.Time `json:"created"`
 }
 
 
@@ -94,15 +183,17 @@ type Customer struct {
 
 func (s *Service) IDByToken(ctx context.Context, token string) (int64, error) {
 	var id int64
-	sqlStatement := `select manager_id from managers_tokens where token = $1`
+	// tokens of deactivated managers stop working along with them
+	sqlStatement := `select t.manager_id from managers_tokens t join managers m on m.id = t.manager_id
+	where t.token = $1 and m.active`
 
 	err := s.db.QueryRow(ctx, sqlStatement, token).Scan(&id)
 
 	if err != nil {
-		log.Print(err)
 		if err == pgx.ErrNoRows {
 			return 0, nil
 		}
+		s.log.Error(ctx, "find manager token", "err", err)
 		return 0, nil
 	}
 
@@ -124,31 +215,195 @@ func (s *Service) Create(ctx context.Context, item *Manager) (string, error) {
 	var token string
 	var id int64
 
-	sqlStmt := `insert into managers(name,phone,is_admin) values ($1,$2,$3) on conflict (phone) do nothing returning id;`
-	err := s.db.QueryRow(ctx, sqlStmt, item.Name, item.Phone, item.IsAdmin).Scan(&id)
+	if item.DiscountLimit < 0 || item.DiscountLimit > 100 {
+		return "", ErrInvalidDiscountLimit
+	}
+
+	tx, err := s.db.Begin(ctx)
 	if err != nil {
-		log.Print(err)
+		s.log.Error(ctx, "begin tx", "err", err)
 		return "", ErrInternal
 	}
+	defer tx.Rollback(ctx)
+
+	// managers registered without a password sign in with the token only
+	hash := ""
+	if item.Password != "" {
+		hashed, err := bcrypt.GenerateFromPassword([]byte(item.Password), bcrypt.DefaultCost)
+		if err != nil {
+			s.log.Error(ctx, "hash manager password", "err", err)
+			return "", ErrInternal
+		}
+		hash = string(hashed)
+	}
+
+	sqlStmt := `insert into managers(name,phone,password,is_admin,discount_limit,store_id) values ($1,$2,nullif($3, ''),$4,$5,nullif($6, 0)) on conflict (phone) do nothing returning id;`
+	err = tx.QueryRow(ctx, sqlStmt, item.Name, item.Phone, hash, item.IsAdmin, item.DiscountLimit, item.StoreID).Scan(&id)
+	if err == pgx.ErrNoRows {
+		return "", ErrPhoneUsed
+	}
+	if err != nil {
+		s.log.Error(ctx, "create manager", "err", err)
+		return "", ErrInternal
+	}
+	item.ID = id
 
 	token, err = GenerateTokenStr()
 	if err != nil {
 		return "", err
 	}
 
-	_, err = s.db.Exec(ctx, `insert into managers_tokens(token,manager_id) values($1,$2)`, token, id)
+	_, err = tx.Exec(ctx, `insert into managers_tokens(token,manager_id) values($1,$2)`, token, id)
 	if err != nil {
 		return "", ErrInternal
 	}
 
+	event := map[string]interface{}{"id": id, "name": item.Name, "phone": item.Phone, "is_admin": item.IsAdmin, "store_id": item.StoreID}
+	if err = outbox.Write(ctx, tx, outbox.ManagerRegistered, id, event); err != nil {
+		s.log.Error(ctx, "write manager event", "err", err)
+		return "", ErrInternal
+	}
+	if err = tx.Commit(ctx); err != nil {
+		s.log.Error(ctx, "commit manager", "err", err)
+		return "", ErrInternal
+	}
+
 	return token, nil
 }
 
 
+// Managers lists every manager, inactive ones included.
+func (s *Service) Managers(ctx context.Context) ([]*Manager, error) {
+
+	items := make([]*Manager, 0)
+	sqlstmt := `select id, name, salary, plan, coalesce(boss_id, 0), coalesce(departament, ''), phone, is_admin,
+		discount_limit, coalesce(store_id, 0), active, created
+	from managers order by id`
+	rows, err := s.db.Query(ctx, sqlstmt)
+	if err != nil {
+		s.log.Error(ctx, "get managers", "err", err)
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		item := &Manager{}
+		err = rows.Scan(&item.ID, &item.Name, &item.Salary, &item.Plan, &item.BossID, &item.Departament, &item.Phone, &item.IsAdmin,
+			&item.DiscountLimit, &item.StoreID, &item.Active, &item.Created)
+		if err != nil {
+			s.log.Error(ctx, "scan manager", "err", err)
+			return nil, ErrInternal
+		}
+		items = append(items, item)
+	}
+	if err = rows.Err(); err != nil {
+		s.log.Error(ctx, "get managers", "err", err)
+		return nil, ErrInternal
+	}
+	return items, nil
+}
+
+
+// HasAdmin reports whether there is an active admin.
+func (s *Service) HasAdmin(ctx context.Context) (bool, error) {
+	var exists bool
+	err := s.db.QueryRow(ctx, `select exists(select 1 from managers where is_admin and active)`).Scan(&exists)
+	if err != nil {
+		s.log.Error(ctx, "find admin", "err", err)
+		return false, ErrInternal
+	}
+	return exists, nil
+}
+
+
+// SetActive activates or deactivates the manager. Deactivation also revokes
+// the manager's tokens, so that open sessions end right away.
+func (s *Service) SetActive(ctx context.Context, id int64, active bool) error {
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		s.log.Error(ctx, "begin tx", "err", err)
+		return ErrInternal
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `update managers set active = $1 where id = $2`, active, id)
+	if err != nil {
+		s.log.Error(ctx, "change manager active", "err", err)
+		return ErrInternal
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	if !active {
+		if _, err = tx.Exec(ctx, `delete from managers_tokens where manager_id = $1`, id); err != nil {
+			s.log.Error(ctx, "revoke manager tokens", "err", err)
+			return ErrInternal
+		}
+	}
+	if err = tx.Commit(ctx); err != nil {
+		s.log.Error(ctx, "commit manager", "err", err)
+		return ErrInternal
+	}
+	return nil
+}
+
+
+// SetPassword replaces the manager's password.
+func (s *Service) SetPassword(ctx context.Context, id int64, password string) error {
+
+	if password == "" {
+		return ErrInvalidPassword
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		s.log.Error(ctx, "hash manager password", "err", err)
+		return ErrInternal
+	}
+
+	tag, err := s.db.Exec(ctx, `update managers set password = $1 where id = $2`, string(hash), id)
+	if err != nil {
+		s.log.Error(ctx, "set manager password", "err", err)
+		return ErrInternal
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+
+// RevokeTokens signs the manager out everywhere and returns how many tokens
+// were revoked.
+func (s *Service) RevokeTokens(ctx context.Context, id int64) (int64, error) {
+	tag, err := s.db.Exec(ctx, `delete from managers_tokens where manager_id = $1`, id)
+	if err != nil {
+		s.log.Error(ctx, "revoke manager tokens", "err", err)
+		return 0, ErrInternal
+	}
+	return tag.RowsAffected(), nil
+}
+
+
+// StoreID returns the store the manager works in, 0 if none is assigned.
+func (s *Service) StoreID(ctx context.Context, managerID int64) (int64, error) {
+	var storeID int64
+	err := s.db.QueryRow(ctx, `select coalesce(store_id, 0) from managers where id = $1`, managerID).Scan(&storeID)
+	if err == pgx.ErrNoRows {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		s.log.Error(ctx, "get manager store", "err", err)
+		return 0, ErrInternal
+	}
+	return storeID, nil
+}
+
+
 func (s *Service) Token(ctx context.Context, phone, password string) (token string, err error) {
 	var hash string
 	var id int64
-	err = s.db.QueryRow(ctx, `select id,password from managers where phone = $1`, phone).Scan(&id, &hash)
+	err = s.db.QueryRow(ctx, `select id,password from managers where phone = $1 and active`, phone).Scan(&id, &hash)
 
 	if err == pgx.ErrNoRows {
 		return "", ErrInvalidPassword
@@ -186,103 +441,427 @@ func GenerateTokenStr() (string, error) {
 }
 
 
-func (s *Service) SaveProduct(ctx context.Context, product *Product) (*Product, error) {
+func (s *Service) SaveProduct(ctx context.Context, managerID int64, product *Product) (*Product, error) {
 
-	var err error
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		s.log.Error(ctx, "begin tx", "err", err)
+		return nil, ErrInternal
+	}
+	defer tx.Rollback(ctx)
 
 	if product.ID == 0 {
-		sqlstmt := `insert into products(name,qty,price) values ($1,$2,$3) returning id,name,qty,price,active,created;`
-		err = s.db.QueryRow(ctx, sqlstmt, product.Name, product.Qty, product.Price).
-			Scan(&product.ID, &product.Name, &product.Qty, &product.Price, &product.Active, &product.Created)
+		initialQty := product.Qty
+		if initialQty < 0 {
+			return nil, inventory.ErrInvalidMovement
+		}
+		sqlstmt := `insert into products(sku,category_id,name,qty,price,reorder_level) values (nullif($1,''),nullif($2,0),$3,0,$4,$5) returning ` + productColumns
+		err = scanProduct(tx.QueryRow(ctx, sqlstmt, product.SKU, product.CategoryID, product.Name, product.Price, product.ReorderLevel), product)
+		if isPgError(err, uniqueViolation) {
+			return nil, ErrSKUDuplicated
+		}
+		if isPgError(err, foreignKeyViolation) {
+			return nil, ErrInvalidCategory
+		}
+		if err != nil {
+			s.log.Error(ctx, "save product", "err", err)
+			return nil, ErrInternal
+		}
+		if initialQty > 0 {
+			movement := &inventory.Movement{
+				ProductID: product.ID,
+				Kind:      inventory.Receipt,
+				Qty:       initialQty,
+				ManagerID: managerID,
+				Reason:    "initial stock",
+			}
+			if err = s.inventorySvc.Apply(ctx, tx, movement); err != nil {
+				return nil, err
+			}
+			product.Qty = movement.Balance
+		}
 	} else {
-		sqlstmt := `update  products set  name=$1, qty=$2,price=$3  where id = $4 returning id,name,qty,price,active,created;`
-		err = s.db.QueryRow(ctx, sqlstmt, product.Name, product.Qty, product.Price, product.ID).
-			Scan(&product.ID, &product.Name, &product.Qty, &product.Price, &product.Active, &product.Created)
+		// qty is owned by the stock ledger and can only change through
+		// movements; the other fields are replaced, an empty sku clears it
+		sqlstmt := `update  products set  sku=nullif($1,''), category_id=nullif($2,0), name=$3, price=$4, reorder_level=$5  where id = $6 returning ` + productColumns
+		err = scanProduct(tx.QueryRow(ctx, sqlstmt, product.SKU, product.CategoryID, product.Name, product.Price, product.ReorderLevel, product.ID), product)
+		if err == pgx.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		if isPgError(err, uniqueViolation) {
+			return nil, ErrSKUDuplicated
+		}
+		if isPgError(err, foreignKeyViolation) {
+			return nil, ErrInvalidCategory
+		}
+		if err != nil {
+			s.log.Error(ctx, "save product", "err", err)
+			return nil, ErrInternal
+		}
 	}
 
-	if err != nil {
-		log.Print(err)
+	if err = outbox.Write(ctx, tx, outbox.ProductChanged, product.ID, product); err != nil {
+		s.log.Error(ctx, "write product event", "err", err)
+		return nil, ErrInternal
+	}
+	if err = tx.Commit(ctx); err != nil {
+		s.log.Error(ctx, "commit product", "err", err)
 		return nil, ErrInternal
 	}
+	// a changed reorder level may put the product below its threshold, and
+	// this also drops the cached listings
+	s.inventorySvc.StockChanged(ctx)
+	if err = s.attachImages(ctx, []*Product{product}); err != nil {
+		return nil, err
+	}
 	return product, nil
 }
 
 
-func (s *Service) MakeSalePosition(ctx context.Context, position *SalePosition) bool {
+func (s *Service) attachImages(ctx context.Context, products []*Product) error {
+	productIDs := make([]int64, 0, len(products))
+	for _, product := range products {
+		productIDs = append(productIDs, product.ID)
+	}
+	byProduct, err := s.imageSvc.ForProducts(ctx, productIDs)
+	if err != nil {
+		return ErrInternal
+	}
+	for _, product := range products {
+		product.Images = byProduct[product.ID]
+		if product.Images == nil {
+			product.Images = make([]*images.Image, 0)
+		}
+	}
+	return nil
+}
+
+
+func isPgError(err error, code string) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == code
+}
+
+
+const productColumns = `id, coalesce(sku, ''), coalesce(category_id, 0), name, qty, price, reorder_level, active, created`
+
+func scanProduct(row pgx.Row, product *Product) error {
+	return row.Scan(&product.ID, &product.SKU, &product.CategoryID, &product.Name, &product.Qty, &product.Price,
+		&product.ReorderLevel, &product.Active, &product.Created)
+}
+
+
+func (s *Service) makeSalePosition(ctx context.Context, tx pgx.Tx, sale *Sale, position *SalePosition) error {
+	if position.Qty <= 0 {
+		return ErrInvalidPosition
+	}
+
+	// the price always comes from the catalog, deviations go through discounts
 	active := false
-	qty := 0
-	if err := s.db.QueryRow(ctx, `select qty,active from products where id = $1`, position.ProductID).
-		Scan(&qty, &active); err != nil {
-		return false
+	err := tx.QueryRow(ctx, `select active, price from products where id = $1`, position.ProductID).Scan(&active, &position.Price)
+	if err == pgx.ErrNoRows {
+		return ErrNotFound
 	}
-	if qty < position.Qty || !active {
-		return false
+	if err != nil {
+		s.log.Error(ctx, "get product", "err", err)
+		return ErrInternal
 	}
-	if _, err := s.db.Exec(ctx, `update products set qty = $1 where id = $2`, qty-position.Qty, position.ProductID); err != nil {
-		log.Print(err)
-		return false
+	if !active {
+		return ErrProductInactive
+	}
+
+	err = s.inventorySvc.Apply(ctx, tx, &inventory.Movement{
+		ProductID: position.ProductID,
+		Kind:      inventory.Sale,
+		Qty:       -position.Qty,
+		ManagerID: sale.ManagerID,
+		SaleID:    sale.ID,
+	})
+	if err != nil {
+		return err
 	}
-	return true
+
+	position.SaleID = sale.ID
+	sqlstmt := `insert into sales_positions (sale_id,product_id,qty,price) values ($1,$2,$3,$4) returning id, created;`
+	err = tx.QueryRow(ctx, sqlstmt, sale.ID, position.ProductID, position.Qty, position.Price).
+		Scan(&position.ID, &position.Created)
+	if err != nil {
+		s.log.Error(ctx, "insert sale position", "err", err)
+		return ErrInternal
+	}
+	return nil
+}
+
+
+func (s *Service) applyDiscounts(ctx context.Context, tx pgx.Tx, sale *Sale) error {
+
+	gross := 0
+	productGross := make(map[int64]int)
+	for _, position := range sale.Positions {
+		gross += position.Price * position.Qty
+		productGross[position.ProductID] += position.Price * position.Qty
+	}
+	sale.Gross = gross
+	sale.Discount = 0
+	sale.Total = gross
+	if len(sale.Discounts) == 0 {
+		return nil
+	}
+
+	limit := 0
+	isAdmin := false
+	err := tx.QueryRow(ctx, `select discount_limit, is_admin from managers where id = $1`, sale.ManagerID).Scan(&limit, &isAdmin)
+	if err != nil && err != pgx.ErrNoRows {
+		s.log.Error(ctx, "get manager discount limit", "err", err)
+		return ErrInternal
+	}
+	if isAdmin {
+		limit = 100
+	}
+
+	discounted := make(map[int64]int)
+	for _, discount := range sale.Discounts {
+		if discount.Amount <= 0 {
+			return ErrInvalidDiscount
+		}
+		if discount.Reason == "" {
+			return ErrDiscountReasonRequired
+		}
+
+		base := gross
+		if discount.ProductID != 0 {
+			var ok bool
+			if base, ok = productGross[discount.ProductID]; !ok {
+				return ErrInvalidDiscount
+			}
+		}
+
+		// a line stays within the limit of its own gross and all the
+		// discounts together within the limit of the sale
+		discounted[discount.ProductID] += discount.Amount
+		if discounted[discount.ProductID]*100 > base*limit {
+			return ErrDiscountLimitExceeded
+		}
+		sale.Discount += discount.Amount
+		sale.Total -= discount.Amount
+		if sale.Discount*100 > gross*limit {
+			return ErrDiscountLimitExceeded
+		}
+	}
+	if sale.Total < 0 {
+		return ErrDiscountLimitExceeded
+	}
+
+	for _, discount := range sale.Discounts {
+		discount.SaleID = sale.ID
+		sqlstmt := `insert into sale_discounts(sale_id, product_id, amount, reason, manager_id)
+		values ($1, nullif($2, 0), $3, $4, nullif($5, 0)) returning id, created`
+		err = tx.QueryRow(ctx, sqlstmt, sale.ID, discount.ProductID, discount.Amount, discount.Reason, sale.ManagerID).
+			Scan(&discount.ID, &discount.Created)
+		if err != nil {
+			s.log.Error(ctx, "insert sale discount", "err", err)
+			return ErrInternal
+		}
+	}
+
+	return nil
+}
+
+
+func (s *Service) applyPromotions(ctx context.Context, tx pgx.Tx, sale *Sale) error {
+
+	lines := make([]*promotions.Line, 0, len(sale.Positions))
+	for _, position := range sale.Positions {
+		lines = append(lines, &promotions.Line{ProductID: position.ProductID, Price: position.Price, Qty: position.Qty})
+	}
+
+	applied, err := s.promotionSvc.Apply(ctx, tx, sale.ID, sale.PromoCodes, lines, sale.Total)
+	if err != nil {
+		return err
+	}
+
+	sale.Promotions = applied
+	for _, item := range applied {
+		sale.Discount += item.Amount
+		sale.Total -= item.Amount
+	}
+	return nil
+}
+
+
+// redeemPoints takes the points off the total like a discount. Points
+// cannot pay more than what is left after discounts and promotions.
+func (s *Service) redeemPoints(ctx context.Context, tx pgx.Tx, sale *Sale) error {
+
+	if sale.RedeemPoints == 0 {
+		return nil
+	}
+	if sale.RedeemPoints < 0 || sale.RedeemPoints > sale.Total {
+		return loyalty.ErrInvalidPoints
+	}
+	if err := s.loyaltySvc.Redeem(ctx, tx, sale.CustomerID, sale.ID, sale.RedeemPoints); err != nil {
+		return err
+	}
+	sale.Discount += sale.RedeemPoints
+	sale.Total -= sale.RedeemPoints
+	return nil
 }
 
 
 func (s *Service) MakeSale(ctx context.Context, sale *Sale) (*Sale, error) {
+	return s.MakeSaleWith(ctx, sale, nil)
+}
 
-	positionsSQLstmt := "insert into sales_positions (sale_id,product_id,qty,price) values "
 
-	sqlstmt := `insert into sales(manager_id,customer_id) values ($1,$2) returning id, created;`
+// SaleHook runs inside the transaction of a sale after the sale is made, so
+// that callers can commit their own changes together with it.
+type SaleHook func(ctx context.Context, tx pgx.Tx, sale *Sale) error
+
+
+func (s *Service) MakeSaleWith(ctx context.Context, sale *Sale, hook SaleHook) (*Sale, error) {
+
+	if len(sale.Positions) == 0 {
+		return nil, ErrInvalidPosition
+	}
+	if sale.Channel == "" {
+		sale.Channel = ChannelPOS
+	}
+	if sale.Channel != ChannelPOS && sale.Channel != ChannelOnline {
+		return nil, ErrInvalidChannel
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		s.log.Error(ctx, "begin tx", "err", err)
+		return nil, ErrInternal
+	}
+	defer tx.Rollback(ctx)
+
+	// goods leave the store the manager works in, online orders and managers
+	// without a store are served from the default store
+	sqlstmt := `insert into sales(manager_id,customer_id,channel,store_id) values (nullif($1, 0),$2,$3,
+		coalesce(nullif($4, 0), (select store_id from managers where id = $1), (select id from stores where is_default)))
+	returning id, store_id, created;`
 
-	err := s.db.QueryRow(ctx, sqlstmt, sale.ManagerID, sale.CustomerID).Scan(&sale.ID, &sale.Created)
+	err = tx.QueryRow(ctx, sqlstmt, sale.ManagerID, sale.CustomerID, sale.Channel, sale.StoreID).Scan(&sale.ID, &sale.StoreID, &sale.Created)
 	if err != nil {
-		log.Print(err)
+		s.log.Error(ctx, "create sale", "err", err)
+		return nil, ErrInternal
+	}
+
+	sale.Status = string(orders.Pending)
+	actor := orders.Actor{ManagerID: sale.ManagerID}
+	if sale.ManagerID == 0 {
+		actor.CustomerID = sale.CustomerID
+	}
+	if err = orders.Record(ctx, tx, sale.ID, orders.Pending, actor); err != nil {
+		s.log.Error(ctx, "record sale status", "err", err)
 		return nil, ErrInternal
 	}
 	for _, position := range sale.Positions {
-		if !s.MakeSalePosition(ctx, position) {
-			log.Print("Invalid position")
-			return nil, ErrInternal
+		if err = s.makeSalePosition(ctx, tx, sale, position); err != nil {
+			s.log.Warn(ctx, "invalid sale position", "product_id", position.ProductID, "qty", position.Qty, "err", err)
+			return nil, err
 		}
-		positionsSQLstmt += "(" + strconv.FormatInt(sale.ID, 10) + "," + strconv.FormatInt(position.ProductID, 10) + "," + strconv.Itoa(position.Price) + "," + strconv.Itoa(position.Qty) + "),"
+	}
+	if err = s.applyDiscounts(ctx, tx, sale); err != nil {
+		s.log.Warn(ctx, "invalid sale discount", "manager_id", sale.ManagerID, "err", err)
+		return nil, err
+	}
+	if err = s.applyPromotions(ctx, tx, sale); err != nil {
+		s.log.Warn(ctx, "invalid sale promotion", "manager_id", sale.ManagerID, "err", err)
+		return nil, err
 	}
 
-	positionsSQLstmt = positionsSQLstmt[0 : len(positionsSQLstmt)-1]
+	if err = s.redeemPoints(ctx, tx, sale); err != nil {
+		s.log.Warn(ctx, "invalid points redemption", "customer_id", sale.CustomerID, "points", sale.RedeemPoints, "err", err)
+		return nil, err
+	}
+	if sale.PointsEarned, err = s.loyaltySvc.Earn(ctx, tx, sale.CustomerID, sale.ID, sale.Total); err != nil {
+		return nil, err
+	}
 
-	log.Print(positionsSQLstmt)
-	_, err = s.db.Exec(ctx, positionsSQLstmt)
+	sqlstmt = `update sales set gross = $1, discount = $2, total = $3, points_redeemed = $4, points_earned = $5 where id = $6`
+	_, err = tx.Exec(ctx, sqlstmt, sale.Gross, sale.Discount, sale.Total, sale.RedeemPoints, sale.PointsEarned, sale.ID)
 	if err != nil {
-		log.Print(err)
+		s.log.Error(ctx, "update sale totals", "err", err)
+		return nil, ErrInternal
+	}
+
+	if hook != nil {
+		if err = hook(ctx, tx, sale); err != nil {
+			return nil, err
+		}
+	}
+	if err = outbox.Write(ctx, tx, outbox.SaleCreated, sale.ID, sale); err != nil {
+		s.log.Error(ctx, "write sale event", "err", err)
 		return nil, ErrInternal
 	}
 
+	if err = tx.Commit(ctx); err != nil {
+		s.log.Error(ctx, "commit sale", "err", err)
+		return nil, ErrInternal
+	}
+	s.inventorySvc.StockChanged(ctx)
+
+	s.log.Info(ctx, "sale created", "sale_id", sale.ID, "manager_id", sale.ManagerID, "channel", sale.Channel, "store_id", sale.StoreID, "positions", len(sale.Positions))
 	return sale, nil
 }
 
 
-func (s *Service) GetSales(ctx context.Context, id int64) (sum int, err error) {
-
-	sqlstmt := `
-	select coalesce(sum(sp.qty * sp.price),0) total
-	from managers m
-	left join sales s on s.manager_id= $1
-	left join sales_positions sp on sp.sale_id = s.id
-	group by m.id
-	limit 1`
+// GetSales sums up the manager's sales. With categoryIDs only positions of
+// products in those categories are counted: their sale discount is shared
+// out pro rata to the line amount and refunds are taken per returned line.
+func (s *Service) GetSales(ctx context.Context, id int64, categoryIDs []int64) (*SalesTotals, error) {
+
+	sqlstmt := `select coalesce(sum(gross), 0), coalesce(sum(discount), 0), coalesce(sum(refunded), 0), coalesce(sum(total - refunded), 0)
+	from sales where manager_id = $1 and status <> 'cancelled'`
+	args := []interface{}{id}
+	if categoryIDs != nil {
+		sqlstmt = `with lines as (
+			select sp.id, sp.price * sp.qty as amount, s.gross, s.discount
+			from sales s
+			join sales_positions sp on sp.sale_id = s.id
+			join products p on p.id = sp.product_id
+			where s.manager_id = $1 and s.status <> 'cancelled' and p.category_id = any($2)
+		), totals as (
+			select coalesce(sum(amount), 0)::bigint as gross,
+				coalesce(sum(case when gross > 0 then discount::bigint * amount / gross else 0 end), 0)::bigint as discount,
+				(select coalesce(sum(rp.refund), 0) from return_positions rp where rp.position_id in (select id from lines))::bigint as refunded
+			from lines
+		) select gross, discount, refunded, gross - discount - refunded from totals`
+		args = append(args, categoryIDs)
+	}
 
-	err = s.db.QueryRow(ctx, sqlstmt, id).Scan(&sum)
+	totals := &SalesTotals{}
+	err := s.db.QueryRow(ctx, sqlstmt, args...).Scan(&totals.Gross, &totals.Discount, &totals.Refunded, &totals.Net)
 	if err != nil {
-		log.Print(err)
-		return 0, ErrInternal
+		s.log.Error(ctx, "get sales total", "err", err)
+		return nil, ErrInternal
 	}
-	return sum, nil
+	return totals, nil
 }
 
 
-func (s *Service) Products(ctx context.Context) ([]*Product, error) {
+// Products lists active products, limited to the given categories unless
+// categoryIDs is nil. The list is cached and must not be changed.
+func (s *Service) Products(ctx context.Context, categoryIDs []int64) ([]*Product, error) {
+	items, err := s.cache.Load(productcache.Key("managers.products", categoryIDs), func() (interface{}, error) {
+		return s.products(ctx, categoryIDs)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items.([]*Product), nil
+}
+
+func (s *Service) products(ctx context.Context, categoryIDs []int64) ([]*Product, error) {
 
 	items := make([]*Product, 0)
 
-	sqlstmt := `select id, name, price, qty from products where active = true order by id limit 500`
-	rows, err := s.db.Query(ctx, sqlstmt)
+	sqlstmt := `select ` + productColumns + ` from products
+	where active = true and ($1::bigint[] is null or category_id = any($1)) order by id limit 500`
+	rows, err := s.db.Query(ctx, sqlstmt, categoryIDs)
 
 	if err != nil {
 		if err == pgx.ErrNoRows {
@@ -294,25 +873,79 @@ func (s *Service) Products(ctx context.Context) ([]*Product, error) {
 
 	for rows.Next() {
 		item := &Product{}
-		err = rows.Scan(&item.ID, &item.Name, &item.Price, &item.Qty)
+		err = scanProduct(rows, item)
 		if err != nil {
-			log.Print(err)
+			s.log.Error(ctx, "scan product", "err", err)
 			return nil, err
 		}
 		items = append(items, item)
 	}
 
+	if err = s.attachImages(ctx, items); err != nil {
+		return nil, err
+	}
 	return items, nil
 }
 
 
+// Product returns a product, active or not.
+func (s *Service) Product(ctx context.Context, id int64) (*Product, error) {
+	cached, err := s.cache.Load("managers.product:"+strconv.FormatInt(id, 10), func() (interface{}, error) {
+		return s.product(ctx, id)
+	})
+	if err != nil {
+		return nil, err
+	}
+	// callers may change their copy before saving it
+	item := *cached.(*Product)
+	return &item, nil
+}
+
+func (s *Service) product(ctx context.Context, id int64) (*Product, error) {
+
+	item := &Product{}
+	err := scanProduct(s.db.QueryRow(ctx, `select `+productColumns+` from products where id = $1`, id), item)
+	if err == pgx.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		s.log.Error(ctx, "get product", "err", err)
+		return nil, ErrInternal
+	}
+
+	if err = s.attachImages(ctx, []*Product{item}); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+
 func (s *Service) RemoveProductByID(ctx context.Context, id int64) (err error) {
 
-	_, err = s.db.Exec(ctx, `delete from products where id = $1`, id)
+	tx, err := s.db.Begin(ctx)
 	if err != nil {
-		log.Print(err)
+		s.log.Error(ctx, "begin tx", "err", err)
 		return ErrInternal
 	}
+	defer tx.Rollback(ctx)
+
+	// products stay in the table so that their stock history and sales remain intact
+	tag, err := tx.Exec(ctx, `update products set active = false where id = $1`, id)
+	if err != nil {
+		s.log.Error(ctx, "remove product", "err", err)
+		return ErrInternal
+	}
+	if tag.RowsAffected() > 0 {
+		if err = outbox.Write(ctx, tx, outbox.ProductRemoved, id, map[string]interface{}{"id": id}); err != nil {
+			s.log.Error(ctx, "write product event", "err", err)
+			return ErrInternal
+		}
+	}
+	if err = tx.Commit(ctx); err != nil {
+		s.log.Error(ctx, "commit product removal", "err", err)
+		return ErrInternal
+	}
+	s.cache.Invalidate(ctx)
 	return nil
 }
 
@@ -320,8 +953,12 @@ func (s *Service) RemoveProductByID(ctx context.Context, id int64) (err error) {
 func (s *Service) RemoveCustomerByID(ctx context.Context, id int64) (err error) {
 
 	_, err = s.db.Exec(ctx, `DELETE from customers where id = $1`, id)
+	// the points ledger and other records referring to the customer keep it
+	if isPgError(err, foreignKeyViolation) {
+		return ErrCustomerInUse
+	}
 	if err != nil {
-		log.Print(err)
+		s.log.Error(ctx, "remove customer", "err", err)
 		return ErrInternal
 	}
 	return nil
@@ -345,7 +982,7 @@ func (s *Service) Customers(ctx context.Context) ([]*Customer, error) {
 		item := &Customer{}
 		err = rows.Scan(&item.ID, &item.Name, &item.Phone, &item.Active, &item.Created)
 		if err != nil {
-			log.Print(err)
+			s.log.Error(ctx, "scan customer", "err", err)
 			return nil, err
 		}
 		items = append(items, item)
@@ -355,13 +992,52 @@ func (s *Service) Customers(ctx context.Context) ([]*Customer, error) {
 }
 
 
+func (s *Service) Customer(ctx context.Context, id int64) (*Customer, error) {
+
+	item := &Customer{}
+	sqlstmt := `select id, name, phone, active, created from customers where id = $1`
+	err := s.db.QueryRow(ctx, sqlstmt, id).Scan(&item.ID, &item.Name, &item.Phone, &item.Active, &item.Created)
+	if err == pgx.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		s.log.Error(ctx, "get customer", "err", err)
+		return nil, ErrInternal
+	}
+	return item, nil
+}
+
+
 func (s *Service) ChangeCustomer(ctx context.Context, customer *Customer) (*Customer, error) {
 
-	sqlstmt := `update customers set name = $2, phone = $3, active = $4  where id = $1 returning name,phone,active`
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		s.log.Error(ctx, "begin tx", "err", err)
+		return nil, ErrInternal
+	}
+	defer tx.Rollback(ctx)
+
+	sqlstmt := `update customers set name = $2, phone = $3, active = $4  where id = $1 returning name,phone,active,created`
 
-	if err := s.db.QueryRow(ctx, sqlstmt, customer.ID, customer.Name, customer.Phone, customer.Active).
-		Scan(&customer.Name, &customer.Phone, &customer.Active); err != nil {
-		log.Print(err)
+	err = tx.QueryRow(ctx, sqlstmt, customer.ID, customer.Name, customer.Phone, customer.Active).
+		Scan(&customer.Name, &customer.Phone, &customer.Active, &customer.Created)
+	if err == pgx.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if isPgError(err, uniqueViolation) {
+		return nil, ErrPhoneUsed
+	}
+	if err != nil {
+		s.log.Error(ctx, "change customer", "err", err)
+		return nil, ErrInternal
+	}
+
+	if err = outbox.Write(ctx, tx, outbox.CustomerChanged, customer.ID, customer); err != nil {
+		s.log.Error(ctx, "write customer event", "err", err)
+		return nil, ErrInternal
+	}
+	if err = tx.Commit(ctx); err != nil {
+		s.log.Error(ctx, "commit customer", "err", err)
 		return nil, ErrInternal
 	}
 
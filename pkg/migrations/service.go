@@ -0,0 +1,186 @@
+package migrations
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+
+	"github.com/ehsontjk/crud/pkg/logger"
+)
+
+var (
+	ErrInternal = errors.New("internal error")
+	ErrNoFiles  = errors.New("no migration files found")
+)
+
+// DefaultDir holds the migrations: 001 is the original schema and every
+// later change to it comes as a new file, since applied files are never run
+// again. Postgres images run the same files on first start, which is why
+// every file must be safe to apply again.
+const DefaultDir = "docker-entrypoint-initdb.d"
+
+// runLock keeps two runners from applying the same files at once.
+const runLock = 0x6d696772617465
+
+// Migration is an SQL file of the migrations directory. Files are applied
+// in name order. Changed is set when an applied file was edited since.
+type Migration struct {
+	Name     string     `json:"name"`
+	Checksum string     `json:"checksum"`
+	Applied  *time.Time `json:"applied"`
+	Changed  bool       `json:"changed"`
+	sql      string
+}
+
+type Service struct {
+	db  *pgxpool.Pool
+	log *logger.Logger
+}
+
+func NewService(db *pgxpool.Pool, log *logger.Logger) *Service {
+	return &Service{db: db, log: log}
+}
+
+func readDir(dir string) ([]*Migration, error) {
+	names, err := filepath.Glob(filepath.Join(dir, "*.sql"))
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, ErrNoFiles
+	}
+	sort.Strings(names)
+
+	items := make([]*Migration, 0, len(names))
+	for _, name := range names {
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(data)
+		items = append(items, &Migration{Name: filepath.Base(name), Checksum: hex.EncodeToString(sum[:]), sql: string(data)})
+	}
+	return items, nil
+}
+
+// List returns the migrations of dir with the time each was applied.
+func (s *Service) List(ctx context.Context, dir string) ([]*Migration, error) {
+
+	items, err := readDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err = s.prepare(ctx); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(ctx, `select name, checksum, applied from schema_migrations`)
+	if err != nil {
+		s.log.Error(ctx, "get applied migrations", "err", err)
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+
+	byName := make(map[string]*Migration)
+	for _, item := range items {
+		byName[item.Name] = item
+	}
+	for rows.Next() {
+		var name, checksum string
+		var applied time.Time
+		if err = rows.Scan(&name, &checksum, &applied); err != nil {
+			s.log.Error(ctx, "scan migration", "err", err)
+			return nil, ErrInternal
+		}
+		if item, ok := byName[name]; ok {
+			item.Applied = &applied
+			item.Changed = item.Checksum != checksum
+		}
+	}
+	if err = rows.Err(); err != nil {
+		s.log.Error(ctx, "get applied migrations", "err", err)
+		return nil, ErrInternal
+	}
+	return items, nil
+}
+
+func (s *Service) prepare(ctx context.Context) error {
+	sqlstmt := `create table if not exists schema_migrations
+	(
+		name     text primary key,
+		checksum text not null,
+		applied  timestamp not null default current_timestamp
+	)`
+	if _, err := s.db.Exec(ctx, sqlstmt); err != nil {
+		s.log.Error(ctx, "create migrations table", "err", err)
+		return ErrInternal
+	}
+	return nil
+}
+
+// Apply runs the migrations of dir that were not applied yet, each in its
+// own transaction, and returns them. It stops at the first failure; the
+// migrations applied before it stay applied.
+func (s *Service) Apply(ctx context.Context, dir string) ([]*Migration, error) {
+
+	conn, err := s.db.Acquire(ctx)
+	if err != nil {
+		s.log.Error(ctx, "acquire connection", "err", err)
+		return nil, ErrInternal
+	}
+	defer conn.Release()
+
+	if _, err = conn.Exec(ctx, `select pg_advisory_lock($1)`, int64(runLock)); err != nil {
+		s.log.Error(ctx, "lock migrations", "err", err)
+		return nil, ErrInternal
+	}
+	defer conn.Exec(context.Background(), `select pg_advisory_unlock($1)`, int64(runLock))
+
+	items, err := s.List(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	applied := make([]*Migration, 0)
+	for _, item := range items {
+		if item.Applied != nil {
+			continue
+		}
+
+		tx, err := conn.Begin(ctx)
+		if err != nil {
+			s.log.Error(ctx, "begin tx", "err", err)
+			return applied, ErrInternal
+		}
+		// without arguments the file goes out as a simple query, which may
+		// hold any number of statements
+		if _, err = tx.Exec(ctx, item.sql); err != nil {
+			tx.Rollback(ctx)
+			s.log.Error(ctx, "apply migration", "name", item.Name, "err", err)
+			return applied, err
+		}
+		now := time.Now()
+		sqlstmt := `insert into schema_migrations(name, checksum) values ($1, $2) returning applied`
+		if err = tx.QueryRow(ctx, sqlstmt, item.Name, item.Checksum).Scan(&now); err != nil {
+			tx.Rollback(ctx)
+			s.log.Error(ctx, "record migration", "name", item.Name, "err", err)
+			return applied, ErrInternal
+		}
+		if err = tx.Commit(ctx); err != nil {
+			s.log.Error(ctx, "commit migration", "name", item.Name, "err", err)
+			return applied, ErrInternal
+		}
+
+		item.Applied = &now
+		applied = append(applied, item)
+		s.log.Info(ctx, "migration applied", "name", item.Name)
+	}
+	return applied, nil
+}
@@ -0,0 +1,298 @@
+package orders
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+
+	"github.com/ehsontjk/crud/pkg/inventory"
+	"github.com/ehsontjk/crud/pkg/logger"
+	"github.com/ehsontjk/crud/pkg/loyalty"
+	"github.com/ehsontjk/crud/pkg/outbox"
+)
+
+var (
+	ErrNotFound          = errors.New("item not found")
+	ErrInternal          = errors.New("internal error")
+	ErrInvalidStatus     = errors.New("invalid order status")
+	ErrInvalidTransition = errors.New("invalid order status transition")
+)
+
+type Status string
+
+const (
+	Pending   Status = "pending"
+	Paid      Status = "paid"
+	Fulfilled Status = "fulfilled"
+	Completed Status = "completed"
+	Cancelled Status = "cancelled"
+)
+
+var transitions = map[Status][]Status{
+	Pending:   {Paid, Cancelled},
+	Paid:      {Fulfilled, Cancelled},
+	Fulfilled: {Completed},
+}
+
+func (s Status) Valid() bool {
+	switch s {
+	case Pending, Paid, Fulfilled, Completed, Cancelled:
+		return true
+	}
+	return false
+}
+
+func CanTransition(from, to Status) bool {
+	for _, next := range transitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Actor is who changed the status: a manager or a customer. Both are zero
+// for changes made by the system.
+type Actor struct {
+	ManagerID  int64
+	CustomerID int64
+}
+
+type Transition struct {
+	Status     Status    `json:"status"`
+	ManagerID  int64     `json:"manager_id"`
+	CustomerID int64     `json:"customer_id"`
+	Created    time.Time `json:"created"`
+}
+
+// StatusChange is the payload of the event of a status change.
+type StatusChange struct {
+	SaleID     int64  `json:"sale_id"`
+	From       Status `json:"from"`
+	To         Status `json:"to"`
+	ManagerID  int64  `json:"manager_id"`
+	CustomerID int64  `json:"customer_id"`
+}
+
+type Order struct {
+	SaleID     int64         `json:"sale_id"`
+	CustomerID int64         `json:"customer_id"`
+	Channel    string        `json:"channel"`
+	Status     Status        `json:"status"`
+	Total      int           `json:"total"`
+	Refunded   int           `json:"refunded"`
+	Created    time.Time     `json:"created"`
+	History    []*Transition `json:"history"`
+}
+
+type Service struct {
+	db           *pgxpool.Pool
+	log          *logger.Logger
+	inventorySvc *inventory.Service
+	loyaltySvc   *loyalty.Service
+}
+
+func NewService(db *pgxpool.Pool, log *logger.Logger, inventorySvc *inventory.Service, loyaltySvc *loyalty.Service) *Service {
+	return &Service{db: db, log: log, inventorySvc: inventorySvc, loyaltySvc: loyaltySvc}
+}
+
+// Record writes a status change of the sale into its history.
+func Record(ctx context.Context, tx pgx.Tx, saleID int64, status Status, actor Actor) error {
+	sqlstmt := `insert into sale_status_history(sale_id, status, manager_id, customer_id)
+	values ($1, $2, nullif($3, 0), nullif($4, 0))`
+	_, err := tx.Exec(ctx, sqlstmt, saleID, status, actor.ManagerID, actor.CustomerID)
+	return err
+}
+
+// Advance moves the order to the given status if the state machine allows it.
+// Cancelling an order puts its goods back into stock.
+func (s *Service) Advance(ctx context.Context, saleID int64, to Status, actor Actor) (*Order, error) {
+
+	if !to.Valid() {
+		return nil, ErrInvalidStatus
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		s.log.Error(ctx, "begin tx", "err", err)
+		return nil, ErrInternal
+	}
+	defer tx.Rollback(ctx)
+
+	var from Status
+	err = tx.QueryRow(ctx, `select status from sales where id = $1 for update`, saleID).Scan(&from)
+	if err == pgx.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		s.log.Error(ctx, "get order status", "err", err)
+		return nil, ErrInternal
+	}
+
+	if !CanTransition(from, to) {
+		return nil, ErrInvalidTransition
+	}
+
+	if _, err = tx.Exec(ctx, `update sales set status = $1 where id = $2`, to, saleID); err != nil {
+		s.log.Error(ctx, "update order status", "err", err)
+		return nil, ErrInternal
+	}
+	if err = Record(ctx, tx, saleID, to, actor); err != nil {
+		s.log.Error(ctx, "record order status", "err", err)
+		return nil, ErrInternal
+	}
+	change := &StatusChange{SaleID: saleID, From: from, To: to, ManagerID: actor.ManagerID, CustomerID: actor.CustomerID}
+	if err = outbox.Write(ctx, tx, outbox.OrderStatusChanged, saleID, change); err != nil {
+		s.log.Error(ctx, "write order status event", "err", err)
+		return nil, ErrInternal
+	}
+
+	if to == Cancelled {
+		if err = s.restock(ctx, tx, saleID, actor); err != nil {
+			return nil, err
+		}
+		if err = s.loyaltySvc.Reverse(ctx, tx, saleID); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		s.log.Error(ctx, "commit order status", "err", err)
+		return nil, ErrInternal
+	}
+	if to == Cancelled {
+		s.inventorySvc.StockChanged(ctx)
+	}
+
+	s.log.Info(ctx, "order status changed", "sale_id", saleID, "from", from, "to", to,
+		"manager_id", actor.ManagerID, "customer_id", actor.CustomerID)
+	return s.Order(ctx, saleID)
+}
+
+func (s *Service) restock(ctx context.Context, tx pgx.Tx, saleID int64, actor Actor) error {
+
+	// goods that were already returned are back in stock
+	sqlstmt := `select sp.product_id,
+		sum(sp.qty - coalesce((select sum(rp.qty) from return_positions rp where rp.position_id = sp.id), 0))
+	from sales_positions sp where sp.sale_id = $1 group by sp.product_id`
+	rows, err := tx.Query(ctx, sqlstmt, saleID)
+	if err != nil {
+		s.log.Error(ctx, "get order positions", "err", err)
+		return ErrInternal
+	}
+
+	movements := make([]*inventory.Movement, 0)
+	for rows.Next() {
+		item := &inventory.Movement{
+			Kind:      inventory.Return,
+			ManagerID: actor.ManagerID,
+			SaleID:    saleID,
+			Reason:    "order cancelled",
+		}
+		if err = rows.Scan(&item.ProductID, &item.Qty); err != nil {
+			rows.Close()
+			s.log.Error(ctx, "scan order position", "err", err)
+			return ErrInternal
+		}
+		movements = append(movements, item)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		s.log.Error(ctx, "get order positions", "err", err)
+		return ErrInternal
+	}
+
+	for _, item := range movements {
+		if item.Qty <= 0 {
+			continue
+		}
+		if err = s.inventorySvc.Apply(ctx, tx, item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Service) Order(ctx context.Context, saleID int64) (*Order, error) {
+
+	item := &Order{History: make([]*Transition, 0)}
+	sqlstmt := `select id, customer_id, channel, status, total, refunded, created from sales where id = $1`
+	err := s.db.QueryRow(ctx, sqlstmt, saleID).
+		Scan(&item.SaleID, &item.CustomerID, &item.Channel, &item.Status, &item.Total, &item.Refunded, &item.Created)
+	if err == pgx.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		s.log.Error(ctx, "get order", "err", err)
+		return nil, ErrInternal
+	}
+
+	sqlstmt = `select status, coalesce(manager_id, 0), coalesce(customer_id, 0), created
+	from sale_status_history where sale_id = $1 order by id`
+	rows, err := s.db.Query(ctx, sqlstmt, saleID)
+	if err != nil {
+		s.log.Error(ctx, "get order history", "err", err)
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		transition := &Transition{}
+		err = rows.Scan(&transition.Status, &transition.ManagerID, &transition.CustomerID, &transition.Created)
+		if err != nil {
+			s.log.Error(ctx, "scan order history", "err", err)
+			return nil, ErrInternal
+		}
+		item.History = append(item.History, transition)
+	}
+	if err = rows.Err(); err != nil {
+		s.log.Error(ctx, "get order history", "err", err)
+		return nil, ErrInternal
+	}
+
+	return item, nil
+}
+
+// CustomerOrder returns the order only if it belongs to the customer.
+func (s *Service) CustomerOrder(ctx context.Context, customerID, saleID int64) (*Order, error) {
+	item, err := s.Order(ctx, saleID)
+	if err != nil {
+		return nil, err
+	}
+	if item.CustomerID != customerID {
+		return nil, ErrNotFound
+	}
+	return item, nil
+}
+
+func (s *Service) CustomerOrders(ctx context.Context, customerID int64) ([]*Order, error) {
+
+	items := make([]*Order, 0)
+	sqlstmt := `select id, customer_id, channel, status, total, refunded, created from sales
+	where customer_id = $1 order by id desc limit 500`
+	rows, err := s.db.Query(ctx, sqlstmt, customerID)
+	if err != nil {
+		s.log.Error(ctx, "get customer orders", "err", err)
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		item := &Order{}
+		err = rows.Scan(&item.SaleID, &item.CustomerID, &item.Channel, &item.Status, &item.Total, &item.Refunded, &item.Created)
+		if err != nil {
+			s.log.Error(ctx, "scan customer order", "err", err)
+			return nil, ErrInternal
+		}
+		items = append(items, item)
+	}
+	if err = rows.Err(); err != nil {
+		s.log.Error(ctx, "get customer orders", "err", err)
+		return nil, ErrInternal
+	}
+
+	return items, nil
+}
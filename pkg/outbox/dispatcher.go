@@ -0,0 +1,320 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+
+	"github.com/ehsontjk/crud/pkg/logger"
+)
+
+const (
+	DefaultInterval = 10 * time.Second
+	// MaxAttempts is how often a consumer is given an event before the event
+	// is parked and the consumer goes on with the next one.
+	MaxAttempts   = 10
+	maxRetryDelay = time.Hour
+	batchSize     = 100
+)
+
+// Handler consumes an event inside tx, which also advances the consumer's
+// offset. Changes a handler makes through tx therefore happen exactly once
+// per event. A handler that fails is retried with the same event later, up
+// to MaxAttempts times.
+type Handler func(ctx context.Context, tx pgx.Tx, e *Event) error
+
+// Sink delivers events outside the database. Its offset is stored right
+// after a successful delivery, so a sink may see an event again only if the
+// process dies in between; sinks should use the event id to drop repeats.
+type Sink interface {
+	Deliver(ctx context.Context, e *Event) error
+}
+
+type consumer struct {
+	name      string
+	handler   Handler
+	committed func()
+}
+
+// Dispatcher feeds every event to every consumer in the order the events
+// were written. Each consumer keeps its own offset, so a slow or failing
+// consumer holds up only itself.
+// Several instances may run at once: a consumer is served by one of them at
+// a time.
+type Dispatcher struct {
+	db        *pgxpool.Pool
+	log       *logger.Logger
+	consumers []*consumer
+	wake      chan struct{}
+}
+
+func NewDispatcher(db *pgxpool.Pool, log *logger.Logger) *Dispatcher {
+	return &Dispatcher{db: db, log: log, wake: make(chan struct{}, 1)}
+}
+
+// Subscribe adds an in-process consumer. The name identifies its offset and
+// must stay the same across restarts. A new consumer starts with the events
+// written after its first run. Subscribe must be called before Run.
+func (d *Dispatcher) Subscribe(name string, handler Handler) {
+	d.consumers = append(d.consumers, &consumer{name: name, handler: handler})
+}
+
+// AfterCommit makes the dispatcher call fn once events handled by the named
+// consumer are committed, for work that must not start before the changes of
+// the handler are visible. fn must not block.
+func (d *Dispatcher) AfterCommit(name string, fn func()) {
+	for _, c := range d.consumers {
+		if c.name == name {
+			c.committed = fn
+		}
+	}
+}
+
+// AddSink adds a consumer that hands events to sink.
+func (d *Dispatcher) AddSink(name string, sink Sink) {
+	d.Subscribe(name, func(ctx context.Context, tx pgx.Tx, e *Event) error {
+		return sink.Deliver(ctx, e)
+	})
+}
+
+// Poke makes Run look for new events without blocking the caller.
+func (d *Dispatcher) Poke() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run dispatches events until ctx is done. It wakes up on every commit that
+// wrote events, on any instance, and periodically in case a notification
+// was lost.
+func (d *Dispatcher) Run(ctx context.Context) {
+
+	for _, c := range d.consumers {
+		sqlstmt := `insert into outbox_offsets(consumer, last_xid, last_id)
+		select $1, coalesce(max(xid), 0), coalesce(max(id), 0) from outbox_events on conflict (consumer) do nothing`
+		if _, err := d.db.Exec(ctx, sqlstmt, c.name); err != nil {
+			d.log.Error(ctx, "register outbox consumer", "consumer", c.name, "err", err)
+		}
+	}
+
+	go d.listen(ctx)
+
+	ticker := time.NewTicker(DefaultInterval)
+	defer ticker.Stop()
+
+	for {
+		for _, c := range d.consumers {
+			for {
+				n, err := d.dispatch(ctx, c)
+				if err != nil || n < batchSize {
+					break
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+	}
+}
+
+func (d *Dispatcher) listen(ctx context.Context) {
+	for {
+		err := d.waitForEvents(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		d.log.Warn(ctx, "outbox listener stopped", "err", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(DefaultInterval):
+		}
+	}
+}
+
+func (d *Dispatcher) waitForEvents(ctx context.Context) error {
+
+	conn, err := d.db.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+	// a listening connection must not go back to the pool
+	defer conn.Conn().Close(context.Background())
+
+	if _, err = conn.Exec(ctx, `listen `+channel); err != nil {
+		return err
+	}
+	for {
+		if _, err = conn.Conn().WaitForNotification(ctx); err != nil {
+			return err
+		}
+		d.Poke()
+	}
+}
+
+// position orders events by the transaction that wrote them, then by id.
+type position struct {
+	xid int64
+	id  int64
+}
+
+// retryDelay is how long a consumer waits before it is given an event again
+// after the given number of failed attempts.
+func retryDelay(attempts int) time.Duration {
+	delay := DefaultInterval
+	for i := 1; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	return delay
+}
+
+// dispatch hands the next batch of events to the consumer and returns how
+// many it took or parked.
+func (d *Dispatcher) dispatch(ctx context.Context, c *consumer) (int, error) {
+
+	tx, err := d.db.Begin(ctx)
+	if err != nil {
+		d.log.Error(ctx, "begin tx", "err", err)
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	// another instance serving this consumer holds the row, and a consumer
+	// that failed waits until its retry is due
+	var last position
+	var attempts int
+	sqlstmt := `select last_xid, last_id, attempts from outbox_offsets
+	where consumer = $1 and retry_at <= current_timestamp for update skip locked`
+	err = tx.QueryRow(ctx, sqlstmt, c.name).Scan(&last.xid, &last.id, &attempts)
+	if err == pgx.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		d.log.Error(ctx, "lock outbox offset", "consumer", c.name, "err", err)
+		return 0, err
+	}
+
+	// only events of transactions older than any still running are read: a
+	// transaction that commits later has a larger id, so its events sort
+	// after every event handled here and none is skipped
+	sqlstmt = `select xid, id, type, aggregate_id, payload::text, created from outbox_events
+	where (xid, id) > ($1, $2) and xid < pg_snapshot_xmin(pg_current_snapshot())::text::bigint
+	order by xid, id limit $3`
+	rows, err := tx.Query(ctx, sqlstmt, last.xid, last.id, batchSize)
+	if err != nil {
+		d.log.Error(ctx, "get outbox events", "err", err)
+		return 0, err
+	}
+	events := make([]*Event, 0)
+	positions := make([]position, 0)
+	for rows.Next() {
+		e := &Event{}
+		var p position
+		var payload string
+		if err = rows.Scan(&p.xid, &e.ID, &e.Type, &e.AggregateID, &payload, &e.Created); err != nil {
+			rows.Close()
+			d.log.Error(ctx, "scan outbox event", "err", err)
+			return 0, err
+		}
+		p.id = e.ID
+		e.Payload = json.RawMessage(payload)
+		events = append(events, e)
+		positions = append(positions, p)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		d.log.Error(ctx, "get outbox events", "err", err)
+		return 0, err
+	}
+
+	handled := 0
+	var failed error
+	for i, e := range events {
+		// a savepoint per event undoes the changes of a failed handler only
+		sp, err := tx.Begin(ctx)
+		if err != nil {
+			d.log.Error(ctx, "begin savepoint", "err", err)
+			break
+		}
+		if failed = c.handler(ctx, sp, e); failed != nil {
+			sp.Rollback(ctx)
+			break
+		}
+		if err = sp.Commit(ctx); err != nil {
+			d.log.Error(ctx, "release savepoint", "err", err)
+			break
+		}
+		last = positions[i]
+		attempts = 0
+		handled++
+	}
+
+	delay := time.Duration(0)
+	if failed != nil {
+		e := events[handled]
+		attempts++
+		if attempts < MaxAttempts {
+			delay = retryDelay(attempts)
+			d.log.Warn(ctx, "outbox event not handled", "consumer", c.name, "event_id", e.ID, "type", e.Type,
+				"attempts", attempts, "retry_in", delay, "err", failed)
+		} else {
+			// the consumer moves on, the event waits in outbox_parked
+			sqlstmt = `insert into outbox_parked(consumer, event_id, attempts, error) values ($1, $2, $3, $4)
+			on conflict do nothing`
+			if _, err = tx.Exec(ctx, sqlstmt, c.name, e.ID, attempts, failed.Error()); err != nil {
+				d.log.Error(ctx, "park outbox event", "consumer", c.name, "err", err)
+				return 0, err
+			}
+			d.log.Error(ctx, "outbox event parked", "consumer", c.name, "event_id", e.ID, "type", e.Type,
+				"attempts", attempts, "err", failed)
+			last = positions[handled]
+			attempts = 0
+			handled++
+		}
+	} else if handled == 0 {
+		return 0, nil
+	}
+
+	sqlstmt = `update outbox_offsets set last_xid = $1, last_id = $2, attempts = $3,
+		retry_at = current_timestamp + $4 * interval '1 second', updated = current_timestamp
+	where consumer = $5`
+	if _, err = tx.Exec(ctx, sqlstmt, last.xid, last.id, attempts, int(delay/time.Second), c.name); err != nil {
+		d.log.Error(ctx, "update outbox offset", "consumer", c.name, "err", err)
+		return 0, err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		d.log.Error(ctx, "commit outbox offset", "consumer", c.name, "err", err)
+		return 0, err
+	}
+	if handled > 0 && c.committed != nil {
+		c.committed()
+	}
+	return handled, nil
+}
+
+// LogSink writes every event to the log at debug level.
+type LogSink struct {
+	log *logger.Logger
+}
+
+func NewLogSink(log *logger.Logger) *LogSink {
+	return &LogSink{log: log}
+}
+
+func (s *LogSink) Deliver(ctx context.Context, e *Event) error {
+	s.log.Debug(ctx, "outbox event", "event_id", e.ID, "type", e.Type, "aggregate_id", e.AggregateID)
+	return nil
+}
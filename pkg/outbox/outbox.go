@@ -0,0 +1,73 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// Event types written by the services.
+const (
+	SaleCreated        = "sale.created"
+	ReturnCreated      = "return.created"
+	ProductChanged     = "product.changed"
+	ProductRemoved     = "product.removed"
+	CustomerRegistered = "customer.registered"
+	CustomerChanged    = "customer.changed"
+	ManagerRegistered  = "manager.registered"
+	OrderStatusChanged = "order.status_changed"
+)
+
+// Types lists every event type.
+var Types = []string{SaleCreated, ReturnCreated, ProductChanged, ProductRemoved, CustomerRegistered, CustomerChanged, ManagerRegistered,
+	OrderStatusChanged}
+
+// channel is notified on commit of every transaction that wrote events.
+const channel = "outbox"
+
+// Event is a domain event stored in the outbox. AggregateID is the id of
+// the sale, product, customer or manager the event is about.
+type Event struct {
+	ID          int64           `json:"id"`
+	Type        string          `json:"type"`
+	AggregateID int64           `json:"aggregate_id"`
+	Payload     json.RawMessage `json:"payload"`
+	Created     time.Time       `json:"created"`
+}
+
+// Write stores the event inside tx, so it is published if and only if tx
+// commits. The event is stamped with the id of tx, which orders it for the
+// consumers.
+func Write(ctx context.Context, tx pgx.Tx, eventType string, aggregateID int64, payload interface{}) error {
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	sqlstmt := `insert into outbox_events(type, aggregate_id, payload) values ($1, $2, $3)`
+	if _, err = tx.Exec(ctx, sqlstmt, eventType, aggregateID, string(data)); err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, `select pg_notify($1, '')`, channel)
+	return err
+}
+
+// WriteAll stores an event for every row of query inside tx, like Write.
+// query takes no parameters and selects the aggregate id and the json
+// payload of the events in the order they are to be consumed. It returns
+// the number of events written.
+func WriteAll(ctx context.Context, tx pgx.Tx, eventType string, query string) (int, error) {
+
+	sqlstmt := `insert into outbox_events(type, aggregate_id, payload) select $1, e.* from (` + query + `) e`
+	tag, err := tx.Exec(ctx, sqlstmt, eventType)
+	if err != nil || tag.RowsAffected() == 0 {
+		return 0, err
+	}
+	if _, err = tx.Exec(ctx, `select pg_notify($1, '')`, channel); err != nil {
+		return 0, err
+	}
+	return int(tag.RowsAffected()), nil
+}
@@ -0,0 +1,227 @@
+// Package productcache keeps product listings and lookups in memory. Every
+// change to products, their stock, their images or the stores that list
+// them invalidates the whole cache, here and, through LISTEN/NOTIFY, in every
+// other instance. Entries also expire after a TTL in case a notification is
+// lost.
+package productcache
+
+import (
+	"container/list"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+
+	"github.com/ehsontjk/crud/pkg/logger"
+)
+
+const (
+	DefaultTTL  = time.Minute
+	DefaultSize = 1000
+)
+
+const (
+	// channel carries invalidations between the app instances.
+	channel     = "product_cache"
+	retryListen = 5 * time.Second
+)
+
+// Config limits how long entries live and how many of them are kept; the
+// least recently used ones go first.
+type Config struct {
+	TTL  time.Duration
+	Size int
+}
+
+// Stats counts what the cache did since the start.
+type Stats struct {
+	Entries       int    `json:"entries"`
+	Hits          uint64 `json:"hits"`
+	Misses        uint64 `json:"misses"`
+	Evictions     uint64 `json:"evictions"`
+	Invalidations uint64 `json:"invalidations"`
+}
+
+type entry struct {
+	key     string
+	value   interface{}
+	expires time.Time
+}
+
+type Cache struct {
+	db  *pgxpool.Pool
+	log *logger.Logger
+	cfg Config
+	// instance tells this instance's notifications from the others'.
+	instance string
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+	// generation grows with every invalidation, so that a value loaded
+	// before one is not cached after it.
+	generation uint64
+
+	hits          uint64
+	misses        uint64
+	evictions     uint64
+	invalidations uint64
+}
+
+func NewCache(db *pgxpool.Pool, log *logger.Logger, cfg Config) *Cache {
+	if cfg.TTL <= 0 {
+		cfg.TTL = DefaultTTL
+	}
+	if cfg.Size <= 0 {
+		cfg.Size = DefaultSize
+	}
+	buffer := make([]byte, 8)
+	rand.Read(buffer)
+	return &Cache{
+		db:       db,
+		log:      log,
+		cfg:      cfg,
+		instance: hex.EncodeToString(buffer),
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+// Load returns the value cached under key, or calls load and caches what
+// it returns. Cached values are shared between callers, who must not
+// change them.
+func (c *Cache) Load(key string, load func() (interface{}, error)) (interface{}, error) {
+
+	c.mu.Lock()
+	if element, ok := c.entries[key]; ok {
+		item := element.Value.(*entry)
+		if time.Now().Before(item.expires) {
+			c.lru.MoveToFront(element)
+			c.mu.Unlock()
+			atomic.AddUint64(&c.hits, 1)
+			return item.value, nil
+		}
+		c.remove(element)
+	}
+	generation := c.generation
+	c.mu.Unlock()
+	atomic.AddUint64(&c.misses, 1)
+
+	value, err := load()
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if generation != c.generation {
+		return value, nil
+	}
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
+	c.entries[key] = c.lru.PushFront(&entry{key: key, value: value, expires: time.Now().Add(c.cfg.TTL)})
+	for c.lru.Len() > c.cfg.Size {
+		c.remove(c.lru.Back())
+		atomic.AddUint64(&c.evictions, 1)
+	}
+	return value, nil
+}
+
+func (c *Cache) remove(element *list.Element) {
+	c.lru.Remove(element)
+	delete(c.entries, element.Value.(*entry).key)
+}
+
+// Invalidate empties the cache of every instance. It must be called after
+// the transaction that changed products commits.
+func (c *Cache) Invalidate(ctx context.Context) {
+	c.clear()
+	if _, err := c.db.Exec(ctx, `select pg_notify($1, $2)`, channel, c.instance); err != nil {
+		c.log.Warn(ctx, "notify product cache invalidation", "err", err)
+	}
+}
+
+func (c *Cache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	c.entries = make(map[string]*list.Element)
+	c.lru.Init()
+	atomic.AddUint64(&c.invalidations, 1)
+}
+
+func (c *Cache) Stats() *Stats {
+	c.mu.Lock()
+	entries := c.lru.Len()
+	c.mu.Unlock()
+	return &Stats{
+		Entries:       entries,
+		Hits:          atomic.LoadUint64(&c.hits),
+		Misses:        atomic.LoadUint64(&c.misses),
+		Evictions:     atomic.LoadUint64(&c.evictions),
+		Invalidations: atomic.LoadUint64(&c.invalidations),
+	}
+}
+
+// Run applies the invalidations of the other instances until ctx is done.
+func (c *Cache) Run(ctx context.Context) {
+	for {
+		err := c.listen(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		c.log.Warn(ctx, "product cache listener stopped", "err", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retryListen):
+		}
+	}
+}
+
+func (c *Cache) listen(ctx context.Context) error {
+
+	conn, err := c.db.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+	// a listening connection must not go back to the pool
+	defer conn.Conn().Close(context.Background())
+
+	if _, err = conn.Exec(ctx, `listen `+channel); err != nil {
+		return err
+	}
+	// invalidations sent while nobody listened are lost
+	c.clear()
+
+	for {
+		n, err := conn.Conn().WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		if n.Payload != c.instance {
+			c.clear()
+		}
+	}
+}
+
+// Key is the key of a listing of kind limited to ids, nil for all.
+func Key(kind string, ids []int64) string {
+	if ids == nil {
+		return kind + ":all"
+	}
+	parts := make([]string, 0, len(ids))
+	for _, id := range ids {
+		parts = append(parts, strconv.FormatInt(id, 10))
+	}
+	return kind + ":" + strings.Join(parts, ",")
+}
@@ -0,0 +1,200 @@
+package promotions
+
+import (
+	"sort"
+	"time"
+)
+
+type Kind string
+
+const (
+	Percent  Kind = "percent"
+	Fixed    Kind = "fixed"
+	BuyXGetY Kind = "buy_x_get_y"
+)
+
+// Promotion is a discount rule. Rules without a Code apply automatically to
+// every sale, rules with a Code only when the code is presented. ProductID
+// narrows the rule to one product and CategoryID to the products of a
+// category and its subcategories, otherwise it applies to the whole sale.
+type Promotion struct {
+	ID         int64      `json:"id"`
+	Code       string     `json:"code"`
+	Name       string     `json:"name"`
+	Kind       Kind       `json:"kind"`
+	Value      int        `json:"value"`
+	ProductID  int64      `json:"product_id"`
+	CategoryID int64      `json:"category_id"`
+	BuyQty     int        `json:"buy_qty"`
+	GetQty     int        `json:"get_qty"`
+	Starts     *time.Time `json:"starts"`
+	Ends       *time.Time `json:"ends"`
+	UsageLimit int        `json:"usage_limit"`
+	Used       int        `json:"used"`
+	Active     bool       `json:"active"`
+	Created    time.Time  `json:"created"`
+}
+
+// Line is a product line of a sale as seen by the engine. Categories holds
+// the product's category and all of its ancestors.
+type Line struct {
+	ProductID  int64
+	Price      int
+	Qty        int
+	Categories []int64
+}
+
+// Applied is a promotion that took effect on a sale.
+type Applied struct {
+	PromotionID int64  `json:"promotion_id"`
+	Code        string `json:"code"`
+	Name        string `json:"name"`
+	Amount      int    `json:"amount"`
+}
+
+func (p *Promotion) valid() bool {
+	if p.ProductID != 0 && p.CategoryID != 0 {
+		return false
+	}
+	switch p.Kind {
+	case Percent:
+		return p.Value > 0 && p.Value <= 100
+	case Fixed:
+		return p.Value > 0
+	case BuyXGetY:
+		return p.ProductID != 0 && p.BuyQty > 0 && p.GetQty > 0
+	}
+	return false
+}
+
+// rank orders evaluation: product rules, then category rules, then rules
+// for the whole sale.
+func (p *Promotion) rank() int {
+	switch {
+	case p.ProductID != 0:
+		return 0
+	case p.CategoryID != 0:
+		return 1
+	}
+	return 2
+}
+
+func (p *Promotion) matches(line *Line) bool {
+	if p.ProductID != 0 {
+		return line.ProductID == p.ProductID
+	}
+	for _, id := range line.Categories {
+		if id == p.CategoryID {
+			return true
+		}
+	}
+	return false
+}
+
+// InWindow reports whether the promotion is active at the given moment.
+func (p *Promotion) InWindow(now time.Time) bool {
+	if !p.Active {
+		return false
+	}
+	if p.Starts != nil && now.Before(*p.Starts) {
+		return false
+	}
+	if p.Ends != nil && !now.Before(*p.Ends) {
+		return false
+	}
+	return p.UsageLimit == 0 || p.Used < p.UsageLimit
+}
+
+// Evaluate computes the discounts that the promotions give on the lines.
+// Product and category rules are applied before sale-wide rules and no rule takes more
+// than what is left of the goods it applies to. The sum of all discounts
+// never exceeds payable, the amount due before promotions.
+func Evaluate(promos []*Promotion, lines []*Line, payable int, now time.Time) []*Applied {
+
+	products := make([]*Line, 0, len(lines))
+	productGross := make(map[int64]int)
+	productQty := make(map[int64]int)
+	productPrice := make(map[int64]int)
+	for _, line := range lines {
+		if _, ok := productGross[line.ProductID]; !ok {
+			products = append(products, line)
+		}
+		productGross[line.ProductID] += line.Price * line.Qty
+		productQty[line.ProductID] += line.Qty
+		productPrice[line.ProductID] = line.Price
+	}
+
+	ordered := make([]*Promotion, len(promos))
+	copy(ordered, promos)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].rank() < ordered[j].rank()
+	})
+
+	remaining := payable
+	applied := make([]*Applied, 0)
+	for _, p := range ordered {
+		if !p.valid() || !p.InWindow(now) {
+			continue
+		}
+
+		base := remaining
+		scoped := p.rank() < 2
+		matched := make([]int64, 0)
+		if scoped {
+			base = 0
+			for _, line := range products {
+				if p.matches(line) && productGross[line.ProductID] > 0 {
+					matched = append(matched, line.ProductID)
+					base += productGross[line.ProductID]
+				}
+			}
+			if base == 0 {
+				continue
+			}
+		}
+
+		amount := 0
+		switch p.Kind {
+		case Percent:
+			amount = base * p.Value / 100
+		case Fixed:
+			amount = p.Value
+		case BuyXGetY:
+			free := productQty[p.ProductID] / (p.BuyQty + p.GetQty) * p.GetQty
+			amount = free * productPrice[p.ProductID]
+		}
+
+		if amount > base {
+			amount = base
+		}
+		if amount > remaining {
+			amount = remaining
+		}
+		if amount <= 0 {
+			continue
+		}
+
+		// a scoped discount uses up the goods it applies to pro rata
+		left := amount
+		for i, productID := range matched {
+			share := left
+			if i < len(matched)-1 {
+				share = amount * productGross[productID] / base
+			}
+			if share > productGross[productID] {
+				share = productGross[productID]
+			}
+			productGross[productID] -= share
+			left -= share
+		}
+		remaining -= amount
+		applied = append(applied, &Applied{
+			PromotionID: p.ID,
+			Code:        p.Code,
+			Name:        p.Name,
+			Amount:      amount,
+		})
+	}
+
+	return applied
+}
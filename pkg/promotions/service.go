@@ -0,0 +1,260 @@
+package promotions
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+
+	"github.com/ehsontjk/crud/pkg/logger"
+)
+
+var (
+	ErrNotFound       = errors.New("item not found")
+	ErrInternal       = errors.New("internal error")
+	ErrInvalidPromo   = errors.New("invalid promotion")
+	ErrInvalidCode    = errors.New("invalid promo code")
+	ErrCodeExhausted  = errors.New("promo code usage limit reached")
+	ErrCodeDuplicated = errors.New("promo code already exists")
+)
+
+const (
+	uniqueViolation     = "23505"
+	foreignKeyViolation = "23503"
+)
+
+type Service struct {
+	db  *pgxpool.Pool
+	log *logger.Logger
+}
+
+func NewService(db *pgxpool.Pool, log *logger.Logger) *Service {
+	return &Service{db: db, log: log}
+}
+
+func NormalizeCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+const promotionColumns = `id, coalesce(code, ''), name, kind, value, coalesce(product_id, 0), coalesce(category_id, 0), buy_qty, get_qty,
+	starts, ends, coalesce(usage_limit, 0), used, active, created`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanPromotion(row scanner) (*Promotion, error) {
+	item := &Promotion{}
+	err := row.Scan(&item.ID, &item.Code, &item.Name, &item.Kind, &item.Value, &item.ProductID, &item.CategoryID, &item.BuyQty, &item.GetQty,
+		&item.Starts, &item.Ends, &item.UsageLimit, &item.Used, &item.Active, &item.Created)
+	return item, err
+}
+
+func (s *Service) Save(ctx context.Context, item *Promotion) (*Promotion, error) {
+
+	item.Code = NormalizeCode(item.Code)
+	if item.Name == "" || !item.valid() || item.UsageLimit < 0 {
+		return nil, ErrInvalidPromo
+	}
+	if item.Starts != nil && item.Ends != nil && !item.Starts.Before(*item.Ends) {
+		return nil, ErrInvalidPromo
+	}
+
+	var row pgx.Row
+	if item.ID == 0 {
+		sqlstmt := `insert into promotions(code, name, kind, value, product_id, buy_qty, get_qty, starts, ends, usage_limit, active, category_id)
+		values (nullif($1, ''), $2, $3, $4, nullif($5, 0), $6, $7, $8, $9, nullif($10, 0), $11, nullif($12, 0))
+		returning ` + promotionColumns
+		row = s.db.QueryRow(ctx, sqlstmt, item.Code, item.Name, item.Kind, item.Value, item.ProductID, item.BuyQty, item.GetQty,
+			item.Starts, item.Ends, item.UsageLimit, item.Active, item.CategoryID)
+	} else {
+		sqlstmt := `update promotions set code = nullif($1, ''), name = $2, kind = $3, value = $4, product_id = nullif($5, 0),
+		buy_qty = $6, get_qty = $7, starts = $8, ends = $9, usage_limit = nullif($10, 0), active = $11, category_id = nullif($12, 0)
+		where id = $13 returning ` + promotionColumns
+		row = s.db.QueryRow(ctx, sqlstmt, item.Code, item.Name, item.Kind, item.Value, item.ProductID, item.BuyQty, item.GetQty,
+			item.Starts, item.Ends, item.UsageLimit, item.Active, item.CategoryID, item.ID)
+	}
+
+	saved, err := scanPromotion(row)
+	if err == pgx.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+			return nil, ErrCodeDuplicated
+		}
+		if errors.As(err, &pgErr) && pgErr.Code == foreignKeyViolation {
+			return nil, ErrInvalidPromo
+		}
+		s.log.Error(ctx, "save promotion", "err", err)
+		return nil, ErrInternal
+	}
+	return saved, nil
+}
+
+func (s *Service) All(ctx context.Context) ([]*Promotion, error) {
+
+	items := make([]*Promotion, 0)
+	rows, err := s.db.Query(ctx, `select `+promotionColumns+` from promotions order by id desc limit 500`)
+	if err != nil {
+		s.log.Error(ctx, "get promotions", "err", err)
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		item, err := scanPromotion(rows)
+		if err != nil {
+			s.log.Error(ctx, "scan promotion", "err", err)
+			return nil, ErrInternal
+		}
+		items = append(items, item)
+	}
+	if err = rows.Err(); err != nil {
+		s.log.Error(ctx, "get promotions", "err", err)
+		return nil, ErrInternal
+	}
+	return items, nil
+}
+
+func (s *Service) Deactivate(ctx context.Context, id int64) error {
+
+	tag, err := s.db.Exec(ctx, `update promotions set active = false where id = $1`, id)
+	if err != nil {
+		s.log.Error(ctx, "deactivate promotion", "err", err)
+		return ErrInternal
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Apply evaluates the automatic promotions and the presented codes against
+// the sale lines inside tx, counts their usage and records them on the sale.
+func (s *Service) Apply(ctx context.Context, tx pgx.Tx, saleID int64, codes []string, lines []*Line, payable int) ([]*Applied, error) {
+
+	normalized := make([]string, 0, len(codes))
+	for _, code := range codes {
+		if code = NormalizeCode(code); code != "" {
+			normalized = append(normalized, code)
+		}
+	}
+
+	// rows are not locked, usage limits are enforced when usage is counted
+	// below so that sales only wait for each other on limited promotions
+	sqlstmt := `select ` + promotionColumns + ` from promotions
+	where active and (code is null or code = any($1)) order by id`
+	rows, err := tx.Query(ctx, sqlstmt, normalized)
+	if err != nil {
+		s.log.Error(ctx, "get applicable promotions", "err", err)
+		return nil, ErrInternal
+	}
+
+	promos := make([]*Promotion, 0)
+	found := make(map[string]*Promotion)
+	for rows.Next() {
+		item, err := scanPromotion(rows)
+		if err != nil {
+			rows.Close()
+			s.log.Error(ctx, "scan promotion", "err", err)
+			return nil, ErrInternal
+		}
+		promos = append(promos, item)
+		if item.Code != "" {
+			found[item.Code] = item
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		s.log.Error(ctx, "get applicable promotions", "err", err)
+		return nil, ErrInternal
+	}
+
+	now := time.Now()
+	for _, code := range normalized {
+		promo, ok := found[code]
+		if !ok {
+			return nil, ErrInvalidCode
+		}
+		if promo.UsageLimit != 0 && promo.Used >= promo.UsageLimit {
+			return nil, ErrCodeExhausted
+		}
+		if !promo.InWindow(now) {
+			return nil, ErrInvalidCode
+		}
+	}
+
+	if err = s.fillCategories(ctx, tx, lines); err != nil {
+		return nil, err
+	}
+
+	applied := Evaluate(promos, lines, payable, now)
+	for _, item := range applied {
+		sqlstmt = `update promotions set used = used + 1 where id = $1 and (usage_limit is null or used < usage_limit)`
+		tag, err := tx.Exec(ctx, sqlstmt, item.PromotionID)
+		if err != nil {
+			s.log.Error(ctx, "count promotion usage", "err", err)
+			return nil, ErrInternal
+		}
+		if tag.RowsAffected() == 0 {
+			// a concurrent sale used the last of it
+			return nil, ErrCodeExhausted
+		}
+		_, err = tx.Exec(ctx, `insert into sale_promotions(sale_id, promotion_id, amount) values ($1, $2, $3)`,
+			saleID, item.PromotionID, item.Amount)
+		if err != nil {
+			s.log.Error(ctx, "record sale promotion", "err", err)
+			return nil, ErrInternal
+		}
+	}
+
+	return applied, nil
+}
+
+// fillCategories sets the category chain of every line so that category
+// rules also match products of subcategories.
+func (s *Service) fillCategories(ctx context.Context, tx pgx.Tx, lines []*Line) error {
+
+	productIDs := make([]int64, 0, len(lines))
+	for _, line := range lines {
+		productIDs = append(productIDs, line.ProductID)
+	}
+
+	sqlstmt := `with recursive chain as (
+		select id as product_id, category_id from products where id = any($1) and category_id is not null
+		union
+		select chain.product_id, c.parent_id from chain join categories c on c.id = chain.category_id
+		where c.parent_id is not null
+	) select product_id, category_id from chain`
+	rows, err := tx.Query(ctx, sqlstmt, productIDs)
+	if err != nil {
+		s.log.Error(ctx, "get product categories", "err", err)
+		return ErrInternal
+	}
+	defer rows.Close()
+
+	chains := make(map[int64][]int64)
+	for rows.Next() {
+		var productID, categoryID int64
+		if err = rows.Scan(&productID, &categoryID); err != nil {
+			s.log.Error(ctx, "scan product category", "err", err)
+			return ErrInternal
+		}
+		chains[productID] = append(chains[productID], categoryID)
+	}
+	if err = rows.Err(); err != nil {
+		s.log.Error(ctx, "get product categories", "err", err)
+		return ErrInternal
+	}
+
+	for _, line := range lines {
+		line.Categories = chains[line.ProductID]
+	}
+	return nil
+}
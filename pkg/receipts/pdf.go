@@ -0,0 +1,83 @@
+package receipts
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+)
+
+const (
+	pointsPerMM    = 72 / 25.4
+	pdfMargin      = 6.0
+	courierAdvance = 0.6
+)
+
+func pdfEscape(text string) string {
+	encoded, err := encoding.ReplaceUnsupported(charmap.Windows1252.NewEncoder()).String(text)
+	if err != nil {
+		encoded = text
+	}
+	replacer := strings.NewReplacer(`\`, `\\`, `(`, `\(`, `)`, `\)`)
+	return replacer.Replace(encoded)
+}
+
+// PDF renders the receipt as a single page PDF as wide as the paper roll and
+// as long as the receipt. It uses the built-in Courier fonts, so characters
+// outside of Windows-1252 are printed as question marks.
+func (r *Receipt) PDF(paperMM int) ([]byte, error) {
+	cols, err := Columns(paperMM)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := r.layout(cols)
+	width := float64(paperMM) * pointsPerMM
+	fontSize := (width - 2*pdfMargin) / (float64(cols) * courierAdvance)
+	leading := fontSize * 1.25
+	height := 2*pdfMargin + float64(len(lines))*leading
+
+	var content bytes.Buffer
+	fmt.Fprintf(&content, "BT\n%.2f TL\n%.2f %.2f Td\n", leading, pdfMargin, height-pdfMargin-fontSize)
+	for _, line := range lines {
+		font := "F1"
+		if line.bold {
+			font = "F2"
+		}
+		text := line.text
+		if line.align == center {
+			text = centered(text, cols)
+		}
+		fmt.Fprintf(&content, "/%s %.2f Tf\n(%s) Tj T*\n", font, fontSize, pdfEscape(text))
+	}
+	content.WriteString("ET\n")
+
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] "+
+			"/Resources << /Font << /F1 4 0 R /F2 5 0 R >> >> /Contents 6 0 R >>", width, height),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Courier /Encoding /WinAnsiEncoding >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Courier-Bold /Encoding /WinAnsiEncoding >>",
+		fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", content.Len(), content.String()),
+	}
+
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = b.Len()
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+
+	return b.Bytes(), nil
+}
@@ -0,0 +1,255 @@
+package receipts
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+)
+
+var (
+	ErrUnsupportedWidth  = errors.New("unsupported paper width")
+	ErrUnsupportedFormat = errors.New("unsupported receipt format")
+)
+
+// Columns returns how many characters of the standard font fit on a thermal
+// paper roll of the given width in millimetres.
+func Columns(paperMM int) (int, error) {
+	switch paperMM {
+	case 58:
+		return 32, nil
+	case 80:
+		return 48, nil
+	}
+	return 0, ErrUnsupportedWidth
+}
+
+type align int
+
+const (
+	left align = iota
+	center
+)
+
+type printLine struct {
+	text  string
+	align align
+	bold  bool
+}
+
+func formatAmount(amount int) string {
+	return strconv.Itoa(amount)
+}
+
+// pair puts label on the left and value on the right edge of the line,
+// moving the value to a line of its own when both do not fit.
+func pair(label, value string, cols int) string {
+	lines := []string{label}
+	if utf8.RuneCountInString(label) > cols {
+		lines = wrap(label, cols)
+	}
+	last := lines[len(lines)-1]
+	gap := cols - utf8.RuneCountInString(last) - utf8.RuneCountInString(value)
+	if gap < 1 {
+		gap = cols - utf8.RuneCountInString(value)
+		if gap < 0 {
+			gap = 0
+		}
+		lines = append(lines, "")
+		last = ""
+	}
+	lines[len(lines)-1] = last + strings.Repeat(" ", gap) + value
+	return strings.Join(lines, "\n")
+}
+
+// wrap splits text into lines of at most cols characters, on spaces when possible.
+func wrap(text string, cols int) []string {
+	lines := make([]string, 0)
+	for _, word := range strings.Fields(text) {
+		for utf8.RuneCountInString(word) > cols {
+			runes := []rune(word)
+			lines = append(lines, string(runes[:cols]))
+			word = string(runes[cols:])
+		}
+		last := len(lines) - 1
+		if last >= 0 && utf8.RuneCountInString(lines[last])+1+utf8.RuneCountInString(word) <= cols && lines[last] != "" {
+			lines[last] += " " + word
+			continue
+		}
+		lines = append(lines, word)
+	}
+	if len(lines) == 0 {
+		lines = append(lines, "")
+	}
+	return lines
+}
+
+func (r *Receipt) layout(cols int) []printLine {
+	lines := make([]printLine, 0)
+	add := func(text string, a align, bold bool) {
+		for _, part := range strings.Split(text, "\n") {
+			lines = append(lines, printLine{text: part, align: a, bold: bold})
+		}
+	}
+	separator := strings.Repeat("-", cols)
+
+	for _, text := range wrap(r.Shop.Name, cols) {
+		add(text, center, true)
+	}
+	for _, text := range wrap(r.Shop.Address, cols) {
+		if text != "" {
+			add(text, center, false)
+		}
+	}
+	if r.Shop.Phone != "" {
+		add(r.Shop.Phone, center, false)
+	}
+	if r.Shop.TaxID != "" {
+		add("TIN "+r.Shop.TaxID, center, false)
+	}
+	add(separator, left, false)
+
+	add(pair(fmt.Sprintf("Receipt #%06d", r.Number), r.Created.Format("2006-01-02 15:04"), cols), left, false)
+	add(fmt.Sprintf("Sale %d", r.SaleID), left, false)
+	if r.Manager != "" {
+		add("Cashier: "+r.Manager, left, false)
+	} else {
+		add("Channel: "+r.Channel, left, false)
+	}
+	add(separator, left, false)
+
+	for _, line := range r.Lines {
+		for _, text := range wrap(line.Name, cols) {
+			add(text, left, false)
+		}
+		add(pair(fmt.Sprintf("  %d x %s", line.Qty, formatAmount(line.Price)), formatAmount(line.Total), cols), left, false)
+	}
+	add(separator, left, false)
+
+	add(pair("Subtotal", formatAmount(r.Gross), cols), left, false)
+	for _, discount := range r.Discounts {
+		add(pair(discount.Name, "-"+formatAmount(discount.Amount), cols), left, false)
+	}
+	add(pair("TOTAL", formatAmount(r.Total), cols), left, true)
+	if r.Refunded > 0 {
+		add(pair("Refunded", "-"+formatAmount(r.Refunded), cols), left, false)
+	}
+	add(strings.Repeat("=", cols), left, false)
+	add("Thank you for your purchase!", center, false)
+
+	return lines
+}
+
+func centered(text string, cols int) string {
+	pad := (cols - utf8.RuneCountInString(text)) / 2
+	if pad <= 0 {
+		return text
+	}
+	return strings.Repeat(" ", pad) + text
+}
+
+// Text renders the receipt as plain monospaced text, cols characters wide.
+func (r *Receipt) Text(cols int) string {
+	var b strings.Builder
+	for _, line := range r.layout(cols) {
+		if line.align == center {
+			b.WriteString(centered(line.text, cols))
+		} else {
+			b.WriteString(line.text)
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+var (
+	escInit      = []byte{0x1b, '@'}
+	escCodePage  = []byte{0x1b, 't', 17} // PC866, Cyrillic
+	escAlignLeft = []byte{0x1b, 'a', 0}
+	escCenter    = []byte{0x1b, 'a', 1}
+	escBoldOn    = []byte{0x1b, 'E', 1}
+	escBoldOff   = []byte{0x1b, 'E', 0}
+	escFeed      = []byte{0x1b, 'd', 4}
+	escCut       = []byte{0x1d, 'V', 66, 0}
+)
+
+// ESCPOS renders the receipt as a byte stream for ESC/POS thermal printers.
+// Text is sent in code page 866 so that Cyrillic names print correctly.
+func (r *Receipt) ESCPOS(cols int) []byte {
+	encoder := encoding.ReplaceUnsupported(charmap.CodePage866.NewEncoder())
+
+	var b bytes.Buffer
+	b.Write(escInit)
+	b.Write(escCodePage)
+	for _, line := range r.layout(cols) {
+		if line.align == center {
+			b.Write(escCenter)
+		} else {
+			b.Write(escAlignLeft)
+		}
+		if line.bold {
+			b.Write(escBoldOn)
+		}
+		text, err := encoder.String(line.text)
+		if err != nil {
+			text = strings.Repeat("?", utf8.RuneCountInString(line.text))
+		}
+		b.WriteString(text)
+		b.WriteByte('\n')
+		if line.bold {
+			b.Write(escBoldOff)
+		}
+	}
+	b.Write(escFeed)
+	b.Write(escCut)
+	return b.Bytes()
+}
+
+var htmlTemplate = template.Must(template.New("receipt").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Receipt #{{printf "%06d" .Number}}</title>
+<style>
+body { font-family: monospace; max-width: 320px; margin: 0 auto; }
+h1 { font-size: 1.2em; text-align: center; margin-bottom: 0; }
+.shop, .thanks { text-align: center; }
+table { width: 100%; border-collapse: collapse; }
+td.amount { text-align: right; white-space: nowrap; }
+tr.total td { font-weight: bold; border-top: 1px dashed #000; }
+</style>
+</head>
+<body>
+<h1>{{.Shop.Name}}</h1>
+<div class="shop">
+{{if .Shop.Address}}<div>{{.Shop.Address}}</div>{{end}}
+{{if .Shop.Phone}}<div>{{.Shop.Phone}}</div>{{end}}
+{{if .Shop.TaxID}}<div>TIN {{.Shop.TaxID}}</div>{{end}}
+</div>
+<hr>
+<div>Receipt #{{printf "%06d" .Number}} &middot; {{.Created.Format "2006-01-02 15:04"}}</div>
+<div>Sale {{.SaleID}}{{if .Manager}} &middot; Cashier: {{.Manager}}{{else}} &middot; {{.Channel}}{{end}}</div>
+<hr>
+<table>
+{{range .Lines}}<tr><td>{{.Name}}<br>{{.Qty}} x {{.Price}}</td><td class="amount">{{.Total}}</td></tr>
+{{end}}<tr><td>Subtotal</td><td class="amount">{{.Gross}}</td></tr>
+{{range .Discounts}}<tr><td>{{.Name}}</td><td class="amount">-{{.Amount}}</td></tr>
+{{end}}<tr class="total"><td>TOTAL</td><td class="amount">{{.Total}}</td></tr>
+{{if .Refunded}}<tr><td>Refunded</td><td class="amount">-{{.Refunded}}</td></tr>{{end}}
+</table>
+<hr>
+<div class="thanks">Thank you for your purchase!</div>
+</body>
+</html>
+`))
+
+func (r *Receipt) HTML(w io.Writer) error {
+	return htmlTemplate.Execute(w, r)
+}
@@ -0,0 +1,157 @@
+package receipts
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+
+	"github.com/ehsontjk/crud/pkg/logger"
+)
+
+var (
+	ErrNotFound = errors.New("item not found")
+	ErrInternal = errors.New("internal error")
+)
+
+// Shop holds the details printed in the receipt header.
+type Shop struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+	Phone   string `json:"phone"`
+	TaxID   string `json:"tax_id"`
+}
+
+type Line struct {
+	Name  string `json:"name"`
+	Qty   int    `json:"qty"`
+	Price int    `json:"price"`
+	Total int    `json:"total"`
+}
+
+type Adjustment struct {
+	Name   string `json:"name"`
+	Amount int    `json:"amount"`
+}
+
+type Receipt struct {
+	Shop      Shop          `json:"shop"`
+	Number    int64         `json:"number"`
+	SaleID    int64         `json:"sale_id"`
+	Manager   string        `json:"manager"`
+	Channel   string        `json:"channel"`
+	Created   time.Time     `json:"created"`
+	Lines     []*Line       `json:"lines"`
+	Gross     int           `json:"gross"`
+	Discount  int           `json:"discount"`
+	Total     int           `json:"total"`
+	Refunded  int           `json:"refunded"`
+	Discounts []*Adjustment `json:"discounts"`
+}
+
+type Service struct {
+	db   *pgxpool.Pool
+	log  *logger.Logger
+	shop Shop
+}
+
+func NewService(db *pgxpool.Pool, log *logger.Logger, shop Shop) *Service {
+	return &Service{db: db, log: log, shop: shop}
+}
+
+// ForSale collects the receipt of the sale. The receipt number is taken from
+// a single counter row the first time the receipt is requested, so numbers
+// have no gaps even when sales are rolled back.
+func (s *Service) ForSale(ctx context.Context, saleID int64) (*Receipt, error) {
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		s.log.Error(ctx, "begin tx", "err", err)
+		return nil, ErrInternal
+	}
+	defer tx.Rollback(ctx)
+
+	item := &Receipt{Shop: s.shop, SaleID: saleID, Lines: make([]*Line, 0), Discounts: make([]*Adjustment, 0)}
+	var number *int64
+	sqlstmt := `select s.receipt_no, coalesce(m.name, ''), s.channel, s.created, s.gross, s.discount, s.total, s.refunded
+	from sales s left join managers m on m.id = s.manager_id where s.id = $1 for update of s`
+	err = tx.QueryRow(ctx, sqlstmt, saleID).
+		Scan(&number, &item.Manager, &item.Channel, &item.Created, &item.Gross, &item.Discount, &item.Total, &item.Refunded)
+	if err == pgx.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		s.log.Error(ctx, "get receipt sale", "err", err)
+		return nil, ErrInternal
+	}
+
+	if number == nil {
+		number = new(int64)
+		err = tx.QueryRow(ctx, `update receipt_counter set last = last + 1 returning last`).Scan(number)
+		if err != nil {
+			s.log.Error(ctx, "next receipt number", "err", err)
+			return nil, ErrInternal
+		}
+		if _, err = tx.Exec(ctx, `update sales set receipt_no = $1 where id = $2`, *number, saleID); err != nil {
+			s.log.Error(ctx, "assign receipt number", "err", err)
+			return nil, ErrInternal
+		}
+	}
+	item.Number = *number
+
+	sqlstmt = `select p.name, sp.qty, sp.price from sales_positions sp join products p on p.id = sp.product_id
+	where sp.sale_id = $1 order by sp.id`
+	rows, err := tx.Query(ctx, sqlstmt, saleID)
+	if err != nil {
+		s.log.Error(ctx, "get receipt lines", "err", err)
+		return nil, ErrInternal
+	}
+	for rows.Next() {
+		line := &Line{}
+		if err = rows.Scan(&line.Name, &line.Qty, &line.Price); err != nil {
+			rows.Close()
+			s.log.Error(ctx, "scan receipt line", "err", err)
+			return nil, ErrInternal
+		}
+		line.Total = line.Qty * line.Price
+		item.Lines = append(item.Lines, line)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		s.log.Error(ctx, "get receipt lines", "err", err)
+		return nil, ErrInternal
+	}
+
+	sqlstmt = `select reason, amount from sale_discounts where sale_id = $1
+	union all
+	select p.name, sp.amount from sale_promotions sp join promotions p on p.id = sp.promotion_id where sp.sale_id = $1
+	union all
+	select 'Loyalty points', points_redeemed from sales where id = $1 and points_redeemed > 0`
+	rows, err = tx.Query(ctx, sqlstmt, saleID)
+	if err != nil {
+		s.log.Error(ctx, "get receipt discounts", "err", err)
+		return nil, ErrInternal
+	}
+	for rows.Next() {
+		discount := &Adjustment{}
+		if err = rows.Scan(&discount.Name, &discount.Amount); err != nil {
+			rows.Close()
+			s.log.Error(ctx, "scan receipt discount", "err", err)
+			return nil, ErrInternal
+		}
+		item.Discounts = append(item.Discounts, discount)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		s.log.Error(ctx, "get receipt discounts", "err", err)
+		return nil, ErrInternal
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		s.log.Error(ctx, "commit receipt", "err", err)
+		return nil, ErrInternal
+	}
+	return item, nil
+}
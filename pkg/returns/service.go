@@ -0,0 +1,287 @@
+package returns
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+
+	"github.com/ehsontjk/crud/pkg/inventory"
+	"github.com/ehsontjk/crud/pkg/logger"
+	"github.com/ehsontjk/crud/pkg/loyalty"
+	"github.com/ehsontjk/crud/pkg/orders"
+	"github.com/ehsontjk/crud/pkg/outbox"
+)
+
+var (
+	ErrNotFound        = errors.New("item not found")
+	ErrInternal        = errors.New("internal error")
+	ErrInvalidReturn   = errors.New("invalid return")
+	ErrReasonRequired  = errors.New("reason is required")
+	ErrSaleCancelled   = errors.New("sale is cancelled")
+	ErrSaleNotPaid     = errors.New("sale is not paid")
+	ErrNothingToReturn = errors.New("nothing left to return")
+	ErrQtyExceedsSold  = errors.New("returned qty exceeds sold qty")
+	ErrUnknownPosition = errors.New("position does not belong to the sale")
+)
+
+type Position struct {
+	ID         int64 `json:"id"`
+	PositionID int64 `json:"position_id"`
+	ProductID  int64 `json:"product_id"`
+	Qty        int   `json:"qty"`
+	Refund     int   `json:"refund"`
+}
+
+// Return undoes a sale in full or in part. Refunds are the share of the sale
+// total that the returned goods were actually paid, discounts included. The
+// same share of the loyalty points the sale earned is taken back.
+type Return struct {
+	ID        int64       `json:"id"`
+	SaleID    int64       `json:"sale_id"`
+	ManagerID int64       `json:"manager_id"`
+	Reason    string      `json:"reason"`
+	Refund    int         `json:"refund"`
+	Created   time.Time   `json:"created"`
+	Positions []*Position `json:"positions"`
+}
+
+type Service struct {
+	db           *pgxpool.Pool
+	log          *logger.Logger
+	inventorySvc *inventory.Service
+	loyaltySvc   *loyalty.Service
+}
+
+func NewService(db *pgxpool.Pool, log *logger.Logger, inventorySvc *inventory.Service, loyaltySvc *loyalty.Service) *Service {
+	return &Service{db: db, log: log, inventorySvc: inventorySvc, loyaltySvc: loyaltySvc}
+}
+
+type soldPosition struct {
+	productID int64
+	price     int
+	left      int
+}
+
+// Create returns the requested positions of the sale. Without positions the
+// whole remainder of the sale is returned.
+func (s *Service) Create(ctx context.Context, item *Return) (*Return, error) {
+
+	if item.Reason == "" {
+		return nil, ErrReasonRequired
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		s.log.Error(ctx, "begin tx", "err", err)
+		return nil, ErrInternal
+	}
+	defer tx.Rollback(ctx)
+
+	var status orders.Status
+	var gross, total, refunded int
+	err = tx.QueryRow(ctx, `select status, gross, total, refunded from sales where id = $1 for update`, item.SaleID).
+		Scan(&status, &gross, &total, &refunded)
+	if err == pgx.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		s.log.Error(ctx, "get sale", "err", err)
+		return nil, ErrInternal
+	}
+	// only goods that were paid for can be refunded
+	switch status {
+	case orders.Paid, orders.Fulfilled, orders.Completed:
+	case orders.Cancelled:
+		return nil, ErrSaleCancelled
+	default:
+		return nil, ErrSaleNotPaid
+	}
+
+	sold, err := s.soldPositions(ctx, tx, item.SaleID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(item.Positions) == 0 {
+		for id, position := range sold {
+			if position.left > 0 {
+				item.Positions = append(item.Positions, &Position{PositionID: id, Qty: position.left})
+			}
+		}
+		if len(item.Positions) == 0 {
+			return nil, ErrNothingToReturn
+		}
+	}
+
+	for _, position := range item.Positions {
+		if position.Qty <= 0 {
+			return nil, ErrInvalidReturn
+		}
+		soldPosition, ok := sold[position.PositionID]
+		if !ok {
+			return nil, ErrUnknownPosition
+		}
+		if position.Qty > soldPosition.left {
+			return nil, ErrQtyExceedsSold
+		}
+		soldPosition.left -= position.Qty
+		position.ProductID = soldPosition.productID
+		if gross > 0 {
+			position.Refund = soldPosition.price * position.Qty * total / gross
+		}
+		item.Refund += position.Refund
+	}
+
+	// the last return gets the rounding remainder so that a fully returned
+	// sale is refunded exactly its total
+	fullyReturned := true
+	for _, position := range sold {
+		if position.left > 0 {
+			fullyReturned = false
+			break
+		}
+	}
+	if fullyReturned {
+		rest := total - refunded - item.Refund
+		item.Positions[len(item.Positions)-1].Refund += rest
+		item.Refund += rest
+	}
+
+	sqlstmt := `insert into returns(sale_id, manager_id, reason, refund) values ($1, nullif($2, 0), $3, $4) returning id, created`
+	err = tx.QueryRow(ctx, sqlstmt, item.SaleID, item.ManagerID, item.Reason, item.Refund).Scan(&item.ID, &item.Created)
+	if err != nil {
+		s.log.Error(ctx, "create return", "err", err)
+		return nil, ErrInternal
+	}
+
+	if _, err = tx.Exec(ctx, `update sales set refunded = refunded + $1 where id = $2`, item.Refund, item.SaleID); err != nil {
+		s.log.Error(ctx, "update sale refunded", "err", err)
+		return nil, ErrInternal
+	}
+	if err = s.loyaltySvc.Refund(ctx, tx, item.SaleID, refunded+item.Refund, total); err != nil {
+		return nil, err
+	}
+
+	for _, position := range item.Positions {
+		sqlstmt = `insert into return_positions(return_id, position_id, product_id, qty, refund) values ($1, $2, $3, $4, $5) returning id`
+		err = tx.QueryRow(ctx, sqlstmt, item.ID, position.PositionID, position.ProductID, position.Qty, position.Refund).Scan(&position.ID)
+		if err != nil {
+			s.log.Error(ctx, "create return position", "err", err)
+			return nil, ErrInternal
+		}
+
+		err = s.inventorySvc.Apply(ctx, tx, &inventory.Movement{
+			ProductID: position.ProductID,
+			Kind:      inventory.Return,
+			Qty:       position.Qty,
+			ManagerID: item.ManagerID,
+			SaleID:    item.SaleID,
+			Reason:    item.Reason,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err = outbox.Write(ctx, tx, outbox.ReturnCreated, item.ID, item); err != nil {
+		s.log.Error(ctx, "write return event", "err", err)
+		return nil, ErrInternal
+	}
+	if err = tx.Commit(ctx); err != nil {
+		s.log.Error(ctx, "commit return", "err", err)
+		return nil, ErrInternal
+	}
+	s.inventorySvc.StockChanged(ctx)
+
+	s.log.Info(ctx, "sale returned", "sale_id", item.SaleID, "return_id", item.ID, "refund", item.Refund, "manager_id", item.ManagerID)
+	return item, nil
+}
+
+func (s *Service) soldPositions(ctx context.Context, tx pgx.Tx, saleID int64) (map[int64]*soldPosition, error) {
+
+	sqlstmt := `select sp.id, sp.product_id, sp.price,
+		sp.qty - coalesce((select sum(rp.qty) from return_positions rp where rp.position_id = sp.id), 0)
+	from sales_positions sp where sp.sale_id = $1`
+	rows, err := tx.Query(ctx, sqlstmt, saleID)
+	if err != nil {
+		s.log.Error(ctx, "get sale positions", "err", err)
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+
+	sold := make(map[int64]*soldPosition)
+	for rows.Next() {
+		var id int64
+		position := &soldPosition{}
+		if err = rows.Scan(&id, &position.productID, &position.price, &position.left); err != nil {
+			s.log.Error(ctx, "scan sale position", "err", err)
+			return nil, ErrInternal
+		}
+		sold[id] = position
+	}
+	if err = rows.Err(); err != nil {
+		s.log.Error(ctx, "get sale positions", "err", err)
+		return nil, ErrInternal
+	}
+
+	return sold, nil
+}
+
+func (s *Service) ForSale(ctx context.Context, saleID int64) ([]*Return, error) {
+
+	items := make([]*Return, 0)
+	byID := make(map[int64]*Return)
+
+	sqlstmt := `select id, sale_id, coalesce(manager_id, 0), reason, refund, created from returns where sale_id = $1 order by id`
+	rows, err := s.db.Query(ctx, sqlstmt, saleID)
+	if err != nil {
+		s.log.Error(ctx, "get returns", "err", err)
+		return nil, ErrInternal
+	}
+	for rows.Next() {
+		item := &Return{Positions: make([]*Position, 0)}
+		if err = rows.Scan(&item.ID, &item.SaleID, &item.ManagerID, &item.Reason, &item.Refund, &item.Created); err != nil {
+			rows.Close()
+			s.log.Error(ctx, "scan return", "err", err)
+			return nil, ErrInternal
+		}
+		items = append(items, item)
+		byID[item.ID] = item
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		s.log.Error(ctx, "get returns", "err", err)
+		return nil, ErrInternal
+	}
+
+	sqlstmt = `select rp.id, rp.return_id, rp.position_id, rp.product_id, rp.qty, rp.refund
+	from return_positions rp join returns r on r.id = rp.return_id where r.sale_id = $1 order by rp.id`
+	rows, err = s.db.Query(ctx, sqlstmt, saleID)
+	if err != nil {
+		s.log.Error(ctx, "get return positions", "err", err)
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var returnID int64
+		position := &Position{}
+		err = rows.Scan(&position.ID, &returnID, &position.PositionID, &position.ProductID, &position.Qty, &position.Refund)
+		if err != nil {
+			s.log.Error(ctx, "scan return position", "err", err)
+			return nil, ErrInternal
+		}
+		if item, ok := byID[returnID]; ok {
+			item.Positions = append(item.Positions, position)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		s.log.Error(ctx, "get return positions", "err", err)
+		return nil, ErrInternal
+	}
+
+	return items, nil
+}
@@ -8,8 +8,9 @@ import (
 	"github.com/jackc/pgx/v4"
 	"errors"
 	"context"
-	"log"
     "github.com/jackc/pgx/v4/pgxpool"
+
+	"github.com/ehsontjk/crud/pkg/logger"
 )
 
 var(
@@ -27,12 +28,13 @@ var(
 
 
 type Service struct {
-	db *pgxpool.Pool
+	db  *pgxpool.Pool
+	log *logger.Logger
 }
 
 
-func NewService(db *pgxpool.Pool) *Service {
-	return &Service{db: db}
+func NewService(db *pgxpool.Pool, log *logger.Logger) *Service {
+	return &Service{db: db, log: log}
 }
 
 
@@ -42,10 +44,11 @@ func (s *Service) Auth(login, password string) bool {
 	sqlStatement := `select login, password from managers where login=$1 and password=$2`
 
 	
-	err := s.db.QueryRow(context.Background(), sqlStatement, login, password).Scan(&login, &password)
+	ctx := context.Background()
+	err := s.db.QueryRow(ctx, sqlStatement, login, password).Scan(&login, &password)
 	
 	if err != nil {
-		log.Print(err)
+		s.log.Error(ctx, "auth manager", "err", err)
 		return false
 	}
 	
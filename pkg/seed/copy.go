@@ -0,0 +1,144 @@
+package seed
+
+import "time"
+
+// table is what one COPY writes.
+type table struct {
+	name    string
+	columns []string
+	source  *rows
+}
+
+// rows is a pgx.CopyFromSource that asks next for the rows to write until
+// it returns false. next may return no rows or several.
+type rows struct {
+	next  func() ([][]interface{}, bool)
+	queue [][]interface{}
+	row   []interface{}
+}
+
+func (r *rows) Next() bool {
+	for len(r.queue) == 0 {
+		queue, ok := r.next()
+		if !ok {
+			return false
+		}
+		r.queue = queue
+	}
+	r.row, r.queue = r.queue[0], r.queue[1:]
+	return true
+}
+
+func (r *rows) Values() ([]interface{}, error) {
+	return r.row, nil
+}
+
+func (r *rows) Err() error {
+	return nil
+}
+
+// each writes a row for every one of n items.
+func each(n int, row func(i int) []interface{}) *rows {
+	i := 0
+	return &rows{next: func() ([][]interface{}, bool) {
+		if i == n {
+			return nil, false
+		}
+		i++
+		return [][]interface{}{row(i - 1)}, true
+	}}
+}
+
+// eachSale writes the rows of every sale, generating the sales anew.
+func (d *dataset) eachSale(row func(item *sale) [][]interface{}) *rows {
+	it := d.sales()
+	return &rows{next: func() ([][]interface{}, bool) {
+		item := it.next()
+		if item == nil {
+			return nil, false
+		}
+		return row(item), true
+	}}
+}
+
+// nullable makes zero ids NULL.
+func nullable(id int64) interface{} {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
+
+// tables returns the COPYs of the dataset, referenced tables first.
+func (d *dataset) tables(password string) []*table {
+	return []*table{
+		{"stores", []string{"id", "name", "address", "created"}, each(len(d.stores), func(i int) []interface{} {
+			item := d.stores[i]
+			return []interface{}{item.id, item.name, item.address, d.from.AddDate(0, -1, 0)}
+		})},
+		{"categories", []string{"id", "parent_id", "name", "slug", "position"}, each(len(d.categories), func(i int) []interface{} {
+			item := d.categories[i]
+			return []interface{}{item.id, nullable(item.parentID), item.name, item.slug, item.position}
+		})},
+		{"customers", []string{"id", "name", "phone", "password", "created"}, each(len(d.customers), func(i int) []interface{} {
+			item := d.customers[i]
+			return []interface{}{item.id, item.name, item.phone, password, item.created}
+		})},
+		{"managers", []string{"id", "name", "salary", "plan", "boss_id", "departament", "phone", "password", "is_admin", "discount_limit", "store_id", "created"},
+			each(len(d.managers), func(i int) []interface{} {
+				item := d.managers[i]
+				return []interface{}{item.id, item.name, item.salary, item.plan, nullable(item.bossID), item.department, item.phone, password,
+					false, item.discountLimit, nullable(item.storeID), item.created}
+			})},
+		{"products", []string{"id", "sku", "category_id", "name", "price", "qty", "reorder_level", "created"}, each(len(d.products), func(i int) []interface{} {
+			item := d.products[i]
+			return []interface{}{item.id, item.sku, item.categoryID, item.name, item.price, item.qty, item.reorderLevel, item.created}
+		})},
+		{"store_stock", []string{"store_id", "product_id", "qty"}, each(len(d.stock), func(i int) []interface{} {
+			item := d.stock[i]
+			return []interface{}{item.storeID, item.productID, item.qty}
+		})},
+		{"stock_movements", []string{"product_id", "store_id", "kind", "qty", "balance", "reason", "created"}, each(len(d.stock), func(i int) []interface{} {
+			item := d.stock[i]
+			return []interface{}{item.productID, item.storeID, "receipt", item.qty, item.qty, "seed", d.from.Add(-time.Hour)}
+		})},
+		{"sales", []string{"id", "manager_id", "customer_id", "channel", "store_id", "status", "gross", "discount", "total", "refunded", "created"},
+			d.eachSale(func(item *sale) [][]interface{} {
+				return [][]interface{}{{item.id, nullable(item.managerID), item.customerID, item.channel, nullable(item.storeID), string(item.status),
+					item.gross, item.discount, item.total, item.refunded, item.created}}
+			})},
+		{"sales_positions", []string{"id", "product_id", "sale_id", "price", "qty", "created"}, d.eachSale(func(item *sale) [][]interface{} {
+			values := make([][]interface{}, 0, len(item.positions))
+			for _, p := range item.positions {
+				values = append(values, []interface{}{p.id, p.productID, item.id, p.price, p.qty, item.created})
+			}
+			return values
+		})},
+		{"sale_discounts", []string{"sale_id", "amount", "reason", "manager_id", "created"}, d.eachSale(func(item *sale) [][]interface{} {
+			if item.discount == 0 {
+				return nil
+			}
+			return [][]interface{}{{item.id, item.discount, item.discountReason, nullable(item.managerID), item.created}}
+		})},
+		{"sale_status_history", []string{"sale_id", "status", "manager_id", "customer_id", "created"}, d.eachSale(func(item *sale) [][]interface{} {
+			values := make([][]interface{}, 0, len(item.history))
+			for _, t := range item.history {
+				values = append(values, []interface{}{item.id, string(t.status), nullable(item.managerID), nullable(item.customerID), t.created})
+			}
+			return values
+		})},
+		{"returns", []string{"id", "sale_id", "manager_id", "reason", "refund", "created"}, d.eachSale(func(item *sale) [][]interface{} {
+			if item.ret == nil {
+				return nil
+			}
+			return [][]interface{}{{item.ret.id, item.id, nullable(item.managerID), item.ret.reason, item.ret.refund, item.ret.created}}
+		})},
+		{"return_positions", []string{"return_id", "position_id", "product_id", "qty", "refund"}, d.eachSale(func(item *sale) [][]interface{} {
+			if item.ret == nil {
+				return nil
+			}
+			p := item.ret.position
+			return [][]interface{}{{item.ret.id, p.id, p.productID, item.ret.qty, item.ret.refund}}
+		})},
+	}
+}
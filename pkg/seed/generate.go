@@ -0,0 +1,539 @@
+package seed
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ehsontjk/crud/pkg/managers"
+	"github.com/ehsontjk/crud/pkg/orders"
+)
+
+const (
+	// managerFanout is how many direct reports a boss has.
+	managerFanout = 4
+	onlineRate    = 0.2
+	anonymousRate = 0.3
+	discountRate  = 0.08
+	cancelRate    = 0.03
+	returnRate    = 0.02
+)
+
+// ids are the largest ids of the tables before seeding. Seeded rows of
+// tables that other rows reference get the ids that follow.
+type ids struct {
+	store, category, customer, manager, product, sale, position, ret int64
+}
+
+type store struct {
+	id      int64
+	name    string
+	address string
+}
+
+type categoryRow struct {
+	id       int64
+	parentID int64
+	name     string
+	slug     string
+	position int
+	leaf     *category
+}
+
+type customer struct {
+	id      int64
+	name    string
+	phone   string
+	created time.Time
+}
+
+type manager struct {
+	id            int64
+	bossID        int64
+	storeID       int64
+	name          string
+	phone         string
+	department    string
+	salary        int
+	plan          int
+	discountLimit int
+	created       time.Time
+}
+
+type product struct {
+	id           int64
+	categoryID   int64
+	sku          string
+	name         string
+	price        int
+	qty          int
+	reorderLevel int
+	created      time.Time
+}
+
+type stock struct {
+	storeID   int64
+	productID int64
+	qty       int
+}
+
+// dataset is everything but the sales, which are generated on the fly by
+// a saleIterator as many of them may not fit in memory.
+type dataset struct {
+	cfg          Config
+	base         ids
+	from         time.Time
+	defaultStore int64
+	stores       []*store
+	categories   []*categoryRow
+	customers    []*customer
+	managers     []*manager
+	sellers      []*manager
+	products     []*product
+	stock        []*stock
+	// popular holds product indexes, the best selling first.
+	popular []int
+	// days holds the number of sales of every day of the period.
+	days []int
+}
+
+// generate builds the dataset of cfg on top of the existing rows. The
+// store of online sales is defaultStore or, without one, the first
+// seeded store.
+func generate(cfg Config, base ids, defaultStore int64) *dataset {
+	d := &dataset{cfg: cfg, base: base, from: cfg.Until.AddDate(-cfg.Years, 0, 0), defaultStore: defaultStore}
+	d.generateStores()
+	d.generateCategories()
+	d.generateCustomers()
+	d.generateManagers()
+	d.generateProducts()
+	d.generateDays()
+	return d
+}
+
+func (d *dataset) generateStores() {
+	for i := 0; i < d.cfg.Stores; i++ {
+		r := newRand(d.cfg.Seed, streamStores, i)
+		street := pick(r, streets)
+		d.stores = append(d.stores, &store{
+			id:      d.base.store + int64(i) + 1,
+			name:    fmt.Sprintf("Store #%d, %s", i+1, street),
+			address: fmt.Sprintf("%d %s, Dushanbe", between(r, 1, 120), street),
+		})
+	}
+	if d.defaultStore == 0 && len(d.stores) > 0 {
+		d.defaultStore = d.stores[0].id
+	}
+}
+
+// storeID returns the store of the n-th team, spreading teams over the
+// seeded stores.
+func (d *dataset) storeID(n int) int64 {
+	if len(d.stores) == 0 {
+		return d.defaultStore
+	}
+	return d.stores[n%len(d.stores)].id
+}
+
+func (d *dataset) generateCategories() {
+	id := d.base.category
+	parents := make(map[string]int64)
+	for i, name := range parentCategories {
+		id++
+		parents[name] = id
+		d.categories = append(d.categories, &categoryRow{id: id, name: name, slug: slug(name, id), position: i})
+	}
+	for i, leaf := range leafCategories {
+		id++
+		d.categories = append(d.categories, &categoryRow{
+			id:       id,
+			parentID: parents[leaf.parent],
+			name:     leaf.name,
+			slug:     slug(leaf.name, id),
+			position: i,
+			leaf:     leaf,
+		})
+	}
+}
+
+// slug keeps the slugs of repeated seeding runs unique.
+func slug(name string, id int64) string {
+	return fmt.Sprintf("%s-%d", strings.ToLower(strings.ReplaceAll(name, " ", "-")), id)
+}
+
+// generateCustomers spreads the sign ups evenly from a quarter before the
+// period until its end, in id order.
+func (d *dataset) generateCustomers() {
+	start := d.from.AddDate(0, -3, 0)
+	span := d.cfg.Until.Sub(start)
+	n := d.cfg.Customers
+	for i := 0; i < n; i++ {
+		r := newRand(d.cfg.Seed, streamCustomers, i)
+		id := d.base.customer + int64(i) + 1
+		offset := time.Duration((float64(i) + r.Float64()) / float64(n) * float64(span))
+		d.customers = append(d.customers, &customer{
+			id:      id,
+			name:    pick(r, firstNames) + " " + pick(r, lastNames),
+			phone:   fmt.Sprintf("+9929%08d", id),
+			created: start.Add(offset).Truncate(time.Second),
+		})
+	}
+}
+
+// generateManagers builds a tree: the first manager is the director and
+// every boss has managerFanout reports. The director's reports head the
+// departments, and each department works in one store.
+func (d *dataset) generateManagers() {
+	salaries := []int{12000, 8000, 5000, 3500}
+	limits := []int{30, 20, 10, 5}
+
+	depth := make([]int, d.cfg.Managers)
+	branch := make([]int, d.cfg.Managers)
+	for i := 0; i < d.cfg.Managers; i++ {
+		r := newRand(d.cfg.Seed, streamManagers, i)
+		id := d.base.manager + int64(i) + 1
+		item := &manager{
+			id:      id,
+			name:    pick(r, firstNames) + " " + pick(r, lastNames),
+			phone:   fmt.Sprintf("+9925%08d", id),
+			created: d.from.AddDate(0, -1, 0).Add(time.Duration(i) * time.Hour),
+		}
+
+		if i == 0 {
+			item.department = "Head office"
+			item.storeID = d.defaultStore
+		} else {
+			boss := (i - 1) / managerFanout
+			item.bossID = d.base.manager + int64(boss) + 1
+			depth[i] = depth[boss] + 1
+			branch[i] = i
+			if depth[i] > 1 {
+				branch[i] = branch[boss]
+			}
+			item.department = departments[(branch[i]-1)%len(departments)]
+			item.storeID = d.storeID(branch[i] - 1)
+			item.plan = between(r, 50, 150) * 1000
+			d.sellers = append(d.sellers, item)
+		}
+
+		level := depth[i]
+		if level >= len(salaries) {
+			level = len(salaries) - 1
+		}
+		item.salary = salaries[level] + between(r, 0, 10)*100
+		if r.Intn(5) > 0 {
+			item.discountLimit = limits[level]
+		}
+		d.managers = append(d.managers, item)
+	}
+	// a shop of one sells through its director
+	if len(d.sellers) == 0 && len(d.managers) > 0 {
+		d.sellers = d.managers
+	}
+}
+
+// generateProducts creates the products before the period and stocks them
+// in every store. A permutation decides which of them sell best.
+func (d *dataset) generateProducts() {
+	var leaves []*categoryRow
+	for _, item := range d.categories {
+		if item.leaf != nil {
+			leaves = append(leaves, item)
+		}
+	}
+
+	storeIDs := make([]int64, 0, len(d.stores)+1)
+	if len(d.stores) == 0 || d.defaultStore != d.stores[0].id {
+		storeIDs = append(storeIDs, d.defaultStore)
+	}
+	for _, item := range d.stores {
+		storeIDs = append(storeIDs, item.id)
+	}
+
+	for i := 0; i < d.cfg.Products; i++ {
+		r := newRand(d.cfg.Seed, streamProducts, i)
+		category := leaves[r.Intn(len(leaves))]
+		id := d.base.product + int64(i) + 1
+		item := &product{
+			id:           id,
+			categoryID:   category.id,
+			sku:          fmt.Sprintf("SEED-%07d", id),
+			name:         fmt.Sprintf("%s %s, %s", pick(r, brands), pick(r, category.leaf.products), pick(r, variants)),
+			price:        between(r, category.leaf.minPrice, category.leaf.maxPrice),
+			reorderLevel: between(r, 1, 4) * 5,
+			created:      d.from.Add(-time.Duration(between(r, 1, 90*24)) * time.Hour),
+		}
+
+		r = newRand(d.cfg.Seed, streamStock, i)
+		for _, storeID := range storeIDs {
+			if storeID == 0 || r.Intn(10) < 2 {
+				continue
+			}
+			qty := between(r, 1, 200)
+			item.qty += qty
+			d.stock = append(d.stock, &stock{storeID: storeID, productID: id, qty: qty})
+		}
+		d.products = append(d.products, item)
+	}
+
+	d.popular = newRand(d.cfg.Seed, streamPopularity, 0).Perm(d.cfg.Products)
+}
+
+var weekdayWeights = [7]float64{1.2, 0.85, 0.9, 0.95, 1, 1.15, 1.3}
+
+var monthWeights = [12]float64{0.8, 0.85, 1.05, 1, 1, 0.95, 0.9, 0.9, 1, 1, 1.15, 1.5}
+
+// hourCDF is the share of a day's sales made before the end of each hour.
+var hourCDF = func() []float64 {
+	weights := []float64{0, 0, 0, 0, 0, 0, 0, 0.2, 1, 2, 3, 4, 5, 4.5, 4, 4, 4.5, 5.5, 6, 5.5, 4, 2.5, 1, 0.3}
+	total := 0.0
+	for _, w := range weights {
+		total += w
+	}
+	cdf := make([]float64, len(weights))
+	sum := 0.0
+	for i, w := range weights {
+		sum += w
+		cdf[i] = sum / total
+	}
+	return cdf
+}()
+
+// generateDays splits the sales over the days of the period: more at the
+// weekend and in December, growing over the years, with some noise.
+func (d *dataset) generateDays() {
+	n := int(d.cfg.Until.Sub(d.from).Hours() / 24)
+	if n == 0 {
+		return
+	}
+	r := newRand(d.cfg.Seed, streamDays, 0)
+	weights := make([]float64, n)
+	total := 0.0
+	for i := range weights {
+		day := d.from.AddDate(0, 0, i)
+		growth := 1 + 0.6*float64(i)/float64(n)
+		weights[i] = weekdayWeights[day.Weekday()] * monthWeights[day.Month()-1] * growth * (0.85 + 0.3*r.Float64())
+		total += weights[i]
+	}
+
+	d.days = make([]int, n)
+	sum, assigned := 0.0, 0
+	for i, w := range weights {
+		sum += w
+		upto := int(math.Round(sum / total * float64(d.cfg.Sales)))
+		d.days[i] = upto - assigned
+		assigned = upto
+	}
+}
+
+// at returns the time of the j-th of the k sales of a day. Sales are
+// spread by hourCDF in order, so that ids and times grow together.
+func (d *dataset) at(day, j, k int, r *rand.Rand) time.Time {
+	q := (float64(j) + r.Float64()) / float64(k)
+	h := sort.SearchFloat64s(hourCDF, q)
+	if h == len(hourCDF) {
+		h--
+	}
+	prev := 0.0
+	if h > 0 {
+		prev = hourCDF[h-1]
+	}
+	frac := 0.0
+	if hourCDF[h] > prev {
+		frac = (q - prev) / (hourCDF[h] - prev)
+	}
+	offset := time.Duration((float64(h) + frac) * float64(time.Hour))
+	return d.from.AddDate(0, 0, day).Add(offset).Truncate(time.Second)
+}
+
+type position struct {
+	id        int64
+	productID int64
+	price     int
+	qty       int
+}
+
+type transition struct {
+	status  orders.Status
+	created time.Time
+}
+
+type saleReturn struct {
+	id       int64
+	reason   string
+	position *position
+	qty      int
+	refund   int
+	created  time.Time
+}
+
+type sale struct {
+	id             int64
+	managerID      int64
+	customerID     int64
+	storeID        int64
+	channel        string
+	status         orders.Status
+	gross          int
+	discount       int
+	discountReason string
+	total          int
+	refunded       int
+	created        time.Time
+	positions      []*position
+	history        []*transition
+	ret            *saleReturn
+}
+
+// saleIterator generates the sales in id order. Every sale draws from its
+// own random stream, so iterating again yields the same sales.
+type saleIterator struct {
+	d          *dataset
+	i          int
+	day        int
+	inDay      int
+	positionID int64
+	returnID   int64
+}
+
+func (d *dataset) sales() *saleIterator {
+	return &saleIterator{d: d, positionID: d.base.position, returnID: d.base.ret}
+}
+
+func (it *saleIterator) next() *sale {
+	d := it.d
+	for it.day < len(d.days) && it.inDay >= d.days[it.day] {
+		it.day++
+		it.inDay = 0
+	}
+	if it.day >= len(d.days) {
+		return nil
+	}
+
+	r := newRand(d.cfg.Seed, streamSales, it.i)
+	item := &sale{
+		id:      d.base.sale + int64(it.i) + 1,
+		created: d.at(it.day, it.inDay, d.days[it.day], r),
+		channel: managers.ChannelOnline,
+		storeID: d.defaultStore,
+	}
+	it.i++
+	it.inDay++
+
+	var seller *manager
+	if len(d.sellers) > 0 && r.Float64() >= onlineRate {
+		seller = d.sellers[r.Intn(len(d.sellers))]
+		item.channel = managers.ChannelPOS
+		item.managerID = seller.id
+		item.storeID = seller.storeID
+	}
+	item.customerID = d.customerAt(item, r)
+
+	count := 1
+	for count < 8 && r.Float64() < 0.45 {
+		count++
+	}
+	zipf := rand.NewZipf(r, 1.07, 2, uint64(len(d.products)-1))
+	for k := 0; k < count; k++ {
+		p := d.products[d.popular[zipf.Uint64()]]
+		qty := 1
+		if r.Float64() < 0.25 {
+			qty += between(r, 1, 4)
+		}
+		it.positionID++
+		item.positions = append(item.positions, &position{id: it.positionID, productID: p.id, price: p.price, qty: qty})
+		item.gross += p.price * qty
+	}
+
+	if seller != nil && seller.discountLimit > 0 && r.Float64() < discountRate {
+		item.discount = item.gross * between(r, 1, seller.discountLimit) / 100
+		item.discountReason = pick(r, discountReasons)
+	}
+	item.total = item.gross - item.discount
+
+	d.advance(item, r)
+
+	if item.status == orders.Completed && r.Float64() < returnRate {
+		created := item.history[len(item.history)-1].created.Add(time.Duration(between(r, 1, 14*24)) * time.Hour)
+		if !created.After(d.cfg.Until) {
+			p := item.positions[r.Intn(len(item.positions))]
+			qty := between(r, 1, p.qty)
+			it.returnID++
+			item.ret = &saleReturn{
+				id:       it.returnID,
+				reason:   pick(r, returnReasons),
+				position: p,
+				qty:      qty,
+				refund:   int(int64(p.price) * int64(qty) * int64(item.total) / int64(item.gross)),
+				created:  created,
+			}
+			item.refunded = item.ret.refund
+		}
+	}
+	return item
+}
+
+// customerAt picks the customer of a sale among those signed up by then,
+// the long standing ones more often. Some till sales are anonymous.
+func (d *dataset) customerAt(item *sale, r *rand.Rand) int64 {
+	n := sort.Search(len(d.customers), func(i int) bool {
+		return d.customers[i].created.After(item.created)
+	})
+	if n == 0 || item.channel == managers.ChannelPOS && r.Float64() < anonymousRate {
+		return 0
+	}
+	i := int(float64(n) * math.Pow(r.Float64(), 1.6))
+	if i >= n {
+		i = n - 1
+	}
+	return d.customers[i].id
+}
+
+// advance walks the sale through the order states. Old sales have
+// finished, the latest ones are still pending, paid or fulfilled.
+func (d *dataset) advance(item *sale, r *rand.Rand) {
+	at := item.created
+	item.status = orders.Pending
+	item.history = []*transition{{status: orders.Pending, created: at}}
+
+	cancelAt := -1
+	if r.Float64() < cancelRate {
+		cancelAt = r.Intn(2)
+	}
+	for k, next := range []orders.Status{orders.Paid, orders.Fulfilled, orders.Completed} {
+		if k == cancelAt {
+			next = orders.Cancelled
+		}
+		at = at.Add(gap(r, item.channel, next))
+		if at.After(d.cfg.Until) {
+			return
+		}
+		item.status = next
+		item.history = append(item.history, &transition{status: next, created: at})
+		if next == orders.Cancelled {
+			return
+		}
+	}
+}
+
+// gap is how long a sale takes to reach status from the previous one.
+func gap(r *rand.Rand, channel string, status orders.Status) time.Duration {
+	if channel == managers.ChannelPOS {
+		if status == orders.Cancelled {
+			return time.Duration(between(r, 1, 30)) * time.Minute
+		}
+		return time.Duration(between(r, 1, 10)) * time.Minute
+	}
+	switch status {
+	case orders.Paid:
+		return time.Duration(between(r, 5, 360)) * time.Minute
+	case orders.Fulfilled:
+		return time.Duration(between(r, 12, 72)) * time.Hour
+	case orders.Completed:
+		return time.Duration(between(r, 24, 96)) * time.Hour
+	}
+	return time.Duration(between(r, 30, 48*60)) * time.Minute
+}
@@ -0,0 +1,55 @@
+package seed
+
+import "math/rand"
+
+// source is a splitmix64 generator. It is cheap to create, so every sale
+// gets its own stream derived from the seed and its index, and any table
+// can regenerate any sale without generating the ones before it.
+type source struct {
+	state uint64
+}
+
+func (s *source) Seed(seed int64) {
+	s.state = uint64(seed)
+}
+
+func (s *source) Uint64() uint64 {
+	s.state += 0x9e3779b97f4a7c15
+	z := s.state
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+func (s *source) Int63() int64 {
+	return int64(s.Uint64() >> 1)
+}
+
+// Streams keep the random numbers of different entities apart, so that
+// changing how many customers are generated does not change the products.
+const (
+	streamCustomers = iota + 1
+	streamManagers
+	streamStores
+	streamProducts
+	streamStock
+	streamSales
+	streamDays
+	streamPopularity
+)
+
+func newRand(seed int64, stream, index int) *rand.Rand {
+	s := &source{state: uint64(seed)}
+	s.state = s.Uint64() ^ uint64(stream)<<56 ^ uint64(index)
+	s.Uint64()
+	return rand.New(s)
+}
+
+func pick(r *rand.Rand, words []string) string {
+	return words[r.Intn(len(words))]
+}
+
+// between returns a random integer in [min, max].
+func between(r *rand.Rand, min, max int) int {
+	return min + r.Intn(max-min+1)
+}
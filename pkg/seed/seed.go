@@ -0,0 +1,152 @@
+// Package seed fills the database with generated demo data: stores,
+// categories, customers, a hierarchy of managers, stocked products and
+// years of sales with positions, discounts, status history and returns.
+// The same Config yields the same rows, and rows are written with COPY so
+// that millions of them load in minutes.
+//
+// Seeding only adds rows. It writes no outbox events, so webhooks and the
+// live feed do not see the generated sales, and it leaves loyalty points,
+// receipts and stock movements of sales alone: the seeded stock is what
+// is left after the sales.
+package seed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/ehsontjk/crud/pkg/logger"
+)
+
+var (
+	ErrInternal      = errors.New("internal error")
+	ErrInvalidConfig = errors.New("invalid seed config")
+)
+
+const (
+	DefaultSeed      = 1
+	DefaultCustomers = 1000
+	DefaultManagers  = 20
+	DefaultProducts  = 500
+	DefaultSales     = 10000
+	DefaultStores    = 2
+	DefaultYears     = 2
+	DefaultPassword  = "secret"
+)
+
+// Config sets the volumes. The sales are spread over Years before Until,
+// which is truncated to a day; with the same Until, the same Seed gives the
+// same data.
+type Config struct {
+	Seed      int64
+	Customers int
+	Managers  int
+	Products  int
+	Sales     int
+	Stores    int
+	Years     int
+	Until     time.Time
+	// Password is the password of every seeded customer and manager.
+	Password string
+}
+
+// Report counts the rows written per table.
+type Report struct {
+	Seed  int64            `json:"seed"`
+	From  time.Time        `json:"from"`
+	Until time.Time        `json:"until"`
+	Rows  map[string]int64 `json:"rows"`
+}
+
+type Service struct {
+	db  *pgxpool.Pool
+	log *logger.Logger
+}
+
+func NewService(db *pgxpool.Pool, log *logger.Logger) *Service {
+	return &Service{db: db, log: log}
+}
+
+// sequenced are the tables whose ids are assigned here rather than by
+// their sequence.
+var sequenced = []string{"stores", "categories", "customers", "managers", "products", "sales", "sales_positions", "returns"}
+
+const tables = `stores, categories, customers, managers, products, store_stock, stock_movements, sales,
+	sales_positions, sale_discounts, sale_status_history, returns, return_positions`
+
+// Run generates the data of cfg and writes it in one transaction.
+func (s *Service) Run(ctx context.Context, cfg Config) (*Report, error) {
+
+	cfg.Until = cfg.Until.UTC().Truncate(24 * time.Hour)
+	if cfg.Customers < 0 || cfg.Managers < 0 || cfg.Products < 0 || cfg.Sales < 0 || cfg.Stores < 0 || cfg.Years < 1 ||
+		cfg.Sales > 0 && cfg.Products == 0 || cfg.Password == "" || cfg.Until.IsZero() {
+		return nil, ErrInvalidConfig
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(cfg.Password), bcrypt.DefaultCost)
+	if err != nil {
+		s.log.Error(ctx, "hash password", "err", err)
+		return nil, ErrInternal
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		s.log.Error(ctx, "begin tx", "err", err)
+		return nil, ErrInternal
+	}
+	defer tx.Rollback(ctx)
+
+	// concurrent writers would take the ids reserved below
+	if _, err = tx.Exec(ctx, "lock table "+tables+" in exclusive mode"); err != nil {
+		s.log.Error(ctx, "lock tables", "err", err)
+		return nil, ErrInternal
+	}
+	var base ids
+	sqlstmt := `select (select coalesce(max(id), 0) from stores), (select coalesce(max(id), 0) from categories),
+		(select coalesce(max(id), 0) from customers), (select coalesce(max(id), 0) from managers),
+		(select coalesce(max(id), 0) from products), (select coalesce(max(id), 0) from sales),
+		(select coalesce(max(id), 0) from sales_positions), (select coalesce(max(id), 0) from returns),
+		(select coalesce(max(id), 0) from stores where is_default)`
+	var defaultStore int64
+	err = tx.QueryRow(ctx, sqlstmt).Scan(&base.store, &base.category, &base.customer, &base.manager,
+		&base.product, &base.sale, &base.position, &base.ret, &defaultStore)
+	if err != nil {
+		s.log.Error(ctx, "get max ids", "err", err)
+		return nil, ErrInternal
+	}
+
+	d := generate(cfg, base, defaultStore)
+	report := &Report{Seed: cfg.Seed, From: d.from, Until: cfg.Until, Rows: make(map[string]int64)}
+	for _, table := range d.tables(string(hash)) {
+		n, err := tx.CopyFrom(ctx, pgx.Identifier{table.name}, table.columns, table.source)
+		if err != nil {
+			s.log.Error(ctx, "copy seed data", "table", table.name, "err", err)
+			return nil, ErrInternal
+		}
+		report.Rows[table.name] += n
+		s.log.Info(ctx, "seeded", "table", table.name, "rows", n)
+	}
+
+	for _, table := range sequenced {
+		sqlstmt = fmt.Sprintf(`select setval(pg_get_serial_sequence('%[1]s', 'id'), greatest(max(id), 1), max(id) is not null) from %[1]s`, table)
+		if _, err = tx.Exec(ctx, sqlstmt); err != nil {
+			s.log.Error(ctx, "set sequence", "table", table, "err", err)
+			return nil, ErrInternal
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		s.log.Error(ctx, "commit tx", "err", err)
+		return nil, ErrInternal
+	}
+
+	// the planner should know about the new rows before anyone measures
+	if _, err = s.db.Exec(ctx, "analyze "+tables); err != nil {
+		s.log.Warn(ctx, "analyze seeded tables", "err", err)
+	}
+	return report, nil
+}
@@ -0,0 +1,55 @@
+package seed
+
+var firstNames = []string{
+	"Vasya", "Petya", "Anvar", "Farrukh", "Dilshod", "Rustam", "Sino", "Parviz", "Ivan", "Sergey",
+	"Alisher", "Bakhtiyor", "Jamshed", "Firdavs", "Umed", "Timur", "Dmitry", "Nikolay", "Oleg", "Behruz",
+	"Madina", "Nigina", "Zarina", "Malika", "Parvina", "Olga", "Anna", "Maria", "Elena", "Shahnoza",
+	"Sitora", "Gulnora", "Manizha", "Tahmina", "Natalya", "Irina", "Svetlana", "Farzona", "Mehrona", "Dilnoza",
+}
+
+var lastNames = []string{
+	"Rahimov", "Karimov", "Sharipov", "Nazarov", "Saidov", "Umarov", "Safarov", "Kholov", "Mirzoev", "Ismoilov",
+	"Ivanov", "Petrov", "Smirnov", "Kuznetsov", "Popov", "Sokolov", "Davlatov", "Rajabov", "Sultonov", "Boboev",
+}
+
+var streets = []string{
+	"Rudaki Ave", "Ismoili Somoni St", "Ayni St", "Bukhoro St", "Shohmansur St", "Firdavsi St",
+	"Sino Ave", "Karamov St", "Lohuti St", "Tursunzoda St",
+}
+
+var departments = []string{"Sales floor", "Electronics", "Groceries", "Home goods", "Online orders", "Clothing", "Warehouse"}
+
+var discountReasons = []string{"regular customer", "damaged packaging", "price match", "bulk purchase"}
+
+var returnReasons = []string{"defective", "wrong size", "changed mind", "damaged in delivery", "expired"}
+
+var brands = []string{"Somon", "Pamir", "Vahsh", "Zarafshon", "Orion", "Nova", "Atlas", "Sunrise", "Everyday", "Classic"}
+
+// category is a leaf category of the demo catalog with what it sells and
+// the price range of its products.
+type category struct {
+	parent   string
+	name     string
+	products []string
+	minPrice int
+	maxPrice int
+}
+
+var parentCategories = []string{"Food", "Household", "Electronics", "Clothing"}
+
+var leafCategories = []*category{
+	{"Food", "Dairy", []string{"Milk", "Kefir", "Yogurt", "Cheese", "Butter", "Cream", "Cottage cheese"}, 5, 60},
+	{"Food", "Bakery", []string{"Bread", "Non", "Baguette", "Croissant", "Cake", "Cookies"}, 2, 80},
+	{"Food", "Fruit", []string{"Apples", "Pears", "Grapes", "Apricots", "Melon", "Bananas", "Pomegranates"}, 5, 50},
+	{"Food", "Vegetables", []string{"Potatoes", "Onions", "Carrots", "Tomatoes", "Cucumbers", "Peppers"}, 3, 30},
+	{"Food", "Meat", []string{"Beef", "Lamb", "Chicken", "Sausages", "Minced meat"}, 30, 150},
+	{"Food", "Beverages", []string{"Green tea", "Black tea", "Coffee", "Juice", "Mineral water", "Lemonade"}, 5, 120},
+	{"Household", "Cleaning", []string{"Detergent", "Dish soap", "Bleach", "Sponges", "Glass cleaner"}, 10, 90},
+	{"Household", "Kitchen", []string{"Frying pan", "Saucepan", "Knife set", "Cutting board", "Teapot", "Bowls"}, 40, 600},
+	{"Electronics", "Phones", []string{"Smartphone", "Feature phone", "Tablet"}, 500, 9000},
+	{"Electronics", "Accessories", []string{"Charger", "Headphones", "Power bank", "Cable", "Phone case", "Memory card"}, 20, 700},
+	{"Clothing", "Men", []string{"Shirt", "Jeans", "Jacket", "Sweater", "T-shirt", "Shoes"}, 60, 1200},
+	{"Clothing", "Women", []string{"Dress", "Blouse", "Skirt", "Coat", "Scarf", "Shoes"}, 60, 1500},
+}
+
+var variants = []string{"small", "medium", "large", "family pack", "premium", "classic", "light", "organic"}
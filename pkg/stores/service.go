@@ -0,0 +1,215 @@
+package stores
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+
+	"github.com/ehsontjk/crud/pkg/logger"
+	"github.com/ehsontjk/crud/pkg/productcache"
+)
+
+var (
+	ErrNotFound     = errors.New("item not found")
+	ErrInternal     = errors.New("internal error")
+	ErrInvalidStore = errors.New("invalid store")
+	ErrDefaultStore = errors.New("the default store cannot be deactivated")
+)
+
+const foreignKeyViolation = "23503"
+
+// Store is a shop or a warehouse holding its own stock. Exactly one store
+// is the default one: it serves online orders, managers without a store and
+// catalog imports.
+type Store struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	Address   string    `json:"address"`
+	IsDefault bool      `json:"is_default"`
+	Active    bool      `json:"active"`
+	Created   time.Time `json:"created"`
+}
+
+// Availability is the qty of a product a customer can find in a store.
+type Availability struct {
+	StoreID int64  `json:"store_id"`
+	Name    string `json:"name"`
+	Address string `json:"address"`
+	Qty     int    `json:"qty"`
+}
+
+type Service struct {
+	db    *pgxpool.Pool
+	log   *logger.Logger
+	cache *productcache.Cache
+}
+
+func NewService(db *pgxpool.Pool, log *logger.Logger, cache *productcache.Cache) *Service {
+	return &Service{db: db, log: log, cache: cache}
+}
+
+const storeColumns = `id, name, address, is_default, active, created`
+
+func scanStore(row pgx.Row) (*Store, error) {
+	item := &Store{}
+	err := row.Scan(&item.ID, &item.Name, &item.Address, &item.IsDefault, &item.Active, &item.Created)
+	return item, err
+}
+
+// Save creates or updates a store. Making a store the default one takes
+// the flag away from the previous default store.
+func (s *Service) Save(ctx context.Context, item *Store) (*Store, error) {
+
+	item.Name = strings.TrimSpace(item.Name)
+	if item.Name == "" {
+		return nil, ErrInvalidStore
+	}
+	if item.IsDefault && !item.Active {
+		return nil, ErrDefaultStore
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		s.log.Error(ctx, "begin tx", "err", err)
+		return nil, ErrInternal
+	}
+	defer tx.Rollback(ctx)
+
+	if item.ID != 0 && !item.Active {
+		var isDefault bool
+		err = tx.QueryRow(ctx, `select is_default from stores where id = $1 for update`, item.ID).Scan(&isDefault)
+		if err == pgx.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		if err != nil {
+			s.log.Error(ctx, "lock store", "err", err)
+			return nil, ErrInternal
+		}
+		if isDefault {
+			return nil, ErrDefaultStore
+		}
+	}
+
+	if item.IsDefault {
+		_, err = tx.Exec(ctx, `update stores set is_default = false where is_default and id <> $1`, item.ID)
+		if err != nil {
+			s.log.Error(ctx, "reset default store", "err", err)
+			return nil, ErrInternal
+		}
+	}
+
+	// the default flag is only ever moved to a store, never just dropped
+	var row pgx.Row
+	if item.ID == 0 {
+		sqlstmt := `insert into stores(name, address, is_default, active) values ($1, $2, $3, $4) returning ` + storeColumns
+		row = tx.QueryRow(ctx, sqlstmt, item.Name, item.Address, item.IsDefault, item.Active)
+	} else {
+		sqlstmt := `update stores set name = $1, address = $2, is_default = is_default or $3, active = $4
+		where id = $5 returning ` + storeColumns
+		row = tx.QueryRow(ctx, sqlstmt, item.Name, item.Address, item.IsDefault, item.Active, item.ID)
+	}
+
+	saved, err := scanStore(row)
+	if err == pgx.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		s.log.Error(ctx, "save store", "err", err)
+		return nil, ErrInternal
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		s.log.Error(ctx, "commit store", "err", err)
+		return nil, ErrInternal
+	}
+	// product listings show the stores that have the products in stock
+	s.cache.Invalidate(ctx)
+	return saved, nil
+}
+
+func (s *Service) All(ctx context.Context) ([]*Store, error) {
+
+	items := make([]*Store, 0)
+	rows, err := s.db.Query(ctx, `select `+storeColumns+` from stores order by id`)
+	if err != nil {
+		s.log.Error(ctx, "get stores", "err", err)
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		item, err := scanStore(rows)
+		if err != nil {
+			s.log.Error(ctx, "scan store", "err", err)
+			return nil, ErrInternal
+		}
+		items = append(items, item)
+	}
+	if err = rows.Err(); err != nil {
+		s.log.Error(ctx, "get stores", "err", err)
+		return nil, ErrInternal
+	}
+	return items, nil
+}
+
+// AssignManager makes the manager sell from the store. Sales made before
+// keep the store they were made in.
+func (s *Service) AssignManager(ctx context.Context, storeID, managerID int64) error {
+
+	sqlstmt := `update managers set store_id = $1 where id = $2 and exists (select 1 from stores where id = $1 and active)`
+	tag, err := s.db.Exec(ctx, sqlstmt, storeID, managerID)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == foreignKeyViolation {
+			return ErrNotFound
+		}
+		s.log.Error(ctx, "assign manager store", "err", err)
+		return ErrInternal
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+
+	s.log.Info(ctx, "manager assigned to store", "manager_id", managerID, "store_id", storeID)
+	return nil
+}
+
+// Availability returns, keyed by product id, the active stores that have
+// the products in stock.
+func (s *Service) Availability(ctx context.Context, productIDs []int64) (map[int64][]*Availability, error) {
+
+	items := make(map[int64][]*Availability)
+	if len(productIDs) == 0 {
+		return items, nil
+	}
+
+	sqlstmt := `select ss.product_id, st.id, st.name, st.address, ss.qty from store_stock ss
+	join stores st on st.id = ss.store_id
+	where ss.product_id = any($1) and ss.qty > 0 and st.active order by ss.product_id, st.id`
+	rows, err := s.db.Query(ctx, sqlstmt, productIDs)
+	if err != nil {
+		s.log.Error(ctx, "get store availability", "err", err)
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var productID int64
+		item := &Availability{}
+		if err = rows.Scan(&productID, &item.StoreID, &item.Name, &item.Address, &item.Qty); err != nil {
+			s.log.Error(ctx, "scan store availability", "err", err)
+			return nil, ErrInternal
+		}
+		items[productID] = append(items[productID], item)
+	}
+	if err = rows.Err(); err != nil {
+		s.log.Error(ctx, "get store availability", "err", err)
+		return nil, ErrInternal
+	}
+	return items, nil
+}
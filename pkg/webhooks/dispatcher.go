@@ -0,0 +1,238 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	DefaultTimeout  = 10 * time.Second
+	DefaultInterval = 5 * time.Second
+	// MaxAttempts is how often a delivery is tried before it is given up.
+	MaxAttempts = 8
+	baseDelay   = 30 * time.Second
+	maxDelay    = 6 * time.Hour
+	batchSize   = 20
+	// lease is how long a batch of deliveries is held by the instance that
+	// leased it: long enough to post every delivery of the batch one after
+	// another even if each one times out.
+	lease = (batchSize + 1) * DefaultTimeout
+)
+
+// Headers sent with every delivery.
+const (
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderDelivery  = "X-Webhook-Delivery"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+// Sign returns the signature of a delivery: the hex HMAC-SHA256 of the
+// timestamp, a dot and the body, keyed with the subscription secret.
+// Receivers recompute it to check that a delivery is genuine and recent.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks a signature made by Sign in constant time.
+func Verify(secret string, timestamp int64, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
+
+// Backoff is the delay before the next attempt after the given number of
+// failed attempts: it doubles every time up to a few hours.
+func Backoff(attempts int) time.Duration {
+	delay := baseDelay
+	for i := 1; i < attempts && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+	return delay
+}
+
+// outcome is the status of a delivery after its attempts-th attempt ended
+// with err, and how long until it is due again if it is still pending.
+func outcome(attempts int, err error) (Status, time.Duration) {
+	if err == nil {
+		return Succeeded, 0
+	}
+	if attempts >= MaxAttempts {
+		return Failed, Backoff(attempts)
+	}
+	return Pending, Backoff(attempts)
+}
+
+// Poke makes Run look for due deliveries without blocking the caller.
+func (s *Service) Poke() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run delivers queued webhooks until ctx is done.
+func (s *Service) Run(ctx context.Context) {
+	ticker := time.NewTicker(DefaultInterval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			n, err := s.DeliverDue(ctx)
+			if err != nil || n < batchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.wake:
+		}
+	}
+}
+
+type due struct {
+	id       int64
+	event    string
+	body     []byte
+	url      string
+	secret   string
+	attempts int
+}
+
+// DeliverDue makes one attempt for a batch of due deliveries and returns
+// how many were tried. Deliveries are leased first, so several instances
+// can share the queue without sending anything twice at the same time.
+func (s *Service) DeliverDue(ctx context.Context) (int, error) {
+
+	sqlstmt := `update webhook_deliveries d set next_attempt = current_timestamp + $1 * interval '1 second'
+	from webhook_subscriptions ws
+	where ws.id = d.subscription_id and d.id in (
+		select id from webhook_deliveries where status = 'pending' and next_attempt <= current_timestamp
+		order by next_attempt, id limit $2 for update skip locked
+	) returning d.id, d.event, d.body, ws.url, ws.secret, d.attempts`
+	rows, err := s.db.Query(ctx, sqlstmt, int(lease/time.Second), batchSize)
+	if err != nil {
+		s.log.Error(ctx, "lease webhook deliveries", "err", err)
+		return 0, ErrInternal
+	}
+	items := make([]*due, 0)
+	for rows.Next() {
+		item := &due{}
+		var body string
+		if err = rows.Scan(&item.id, &item.event, &body, &item.url, &item.secret, &item.attempts); err != nil {
+			rows.Close()
+			s.log.Error(ctx, "scan webhook delivery", "err", err)
+			return 0, ErrInternal
+		}
+		item.body = []byte(body)
+		items = append(items, item)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		s.log.Error(ctx, "lease webhook deliveries", "err", err)
+		return 0, ErrInternal
+	}
+
+	for _, item := range items {
+		if err = s.attempt(ctx, item); err != nil {
+			return 0, err
+		}
+	}
+	return len(items), nil
+}
+
+func (s *Service) post(ctx context.Context, item *due) (int, error) {
+
+	req, err := http.NewRequest(http.MethodPost, item.url, bytes.NewReader(item.body))
+	if err != nil {
+		return 0, err
+	}
+	req = req.WithContext(ctx)
+
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, item.event)
+	req.Header.Set(HeaderDelivery, strconv.FormatInt(item.id, 10))
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature, Sign(item.secret, timestamp, item.body))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// drain a little so that the connection can be reused
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+func (s *Service) attempt(ctx context.Context, item *due) error {
+
+	started := time.Now()
+	code, err := s.post(ctx, item)
+	duration := time.Since(started)
+
+	errText := ""
+	if err != nil {
+		errText = err.Error()
+	}
+	item.attempts++
+	status, delay := outcome(item.attempts, err)
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		s.log.Error(ctx, "begin tx", "err", err)
+		return ErrInternal
+	}
+	defer tx.Rollback(ctx)
+
+	sqlstmt := `insert into webhook_attempts(delivery_id, response_code, error, duration_ms) values ($1, $2, $3, $4)`
+	if _, err = tx.Exec(ctx, sqlstmt, item.id, code, errText, int(duration/time.Millisecond)); err != nil {
+		s.log.Error(ctx, "record webhook attempt", "err", err)
+		return ErrInternal
+	}
+	// the database clock decides when deliveries are due, see DeliverDue
+	sqlstmt = `update webhook_deliveries set status = $1, attempts = $2, next_attempt = current_timestamp + $3 * interval '1 second',
+		response_code = $4, delivered = case when $1 = 'succeeded' then current_timestamp end
+	where id = $5`
+	if _, err = tx.Exec(ctx, sqlstmt, status, item.attempts, int(delay/time.Second), code, item.id); err != nil {
+		s.log.Error(ctx, "update webhook delivery", "err", err)
+		return ErrInternal
+	}
+	if err = tx.Commit(ctx); err != nil {
+		s.log.Error(ctx, "commit webhook attempt", "err", err)
+		return ErrInternal
+	}
+
+	switch status {
+	case Succeeded:
+		s.log.Info(ctx, "webhook delivered", "delivery_id", item.id, "event", item.event, "response_code", code)
+	case Failed:
+		s.log.Error(ctx, "webhook delivery failed", "delivery_id", item.id, "event", item.event, "attempts", item.attempts, "err", errText)
+	default:
+		s.log.Warn(ctx, "webhook delivery retried", "delivery_id", item.id, "event", item.event, "attempts", item.attempts,
+			"retry_in", delay, "err", errText)
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// receiver is a local webhook endpoint that answers with status and keeps
+// the requests it got.
+type receiver struct {
+	*httptest.Server
+	mu       sync.Mutex
+	status   int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func newReceiver(status int) *receiver {
+	r := &receiver{status: status}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.requests = append(r.requests, req)
+		r.bodies = append(r.bodies, body)
+		w.WriteHeader(r.status)
+	}))
+	return r
+}
+
+func (r *receiver) respond(status int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.status = status
+}
+
+func (r *receiver) received() ([]*http.Request, [][]byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.requests, r.bodies
+}
+
+func TestSignVerify(t *testing.T) {
+	body := []byte(`{"id":1,"event":"sale.created"}`)
+	signature := Sign("secret", 1600000000, body)
+
+	if !Verify("secret", 1600000000, body, signature) {
+		t.Fatal("signature does not verify")
+	}
+	if Verify("other", 1600000000, body, signature) {
+		t.Error("signature verifies with another secret")
+	}
+	if Verify("secret", 1600000001, body, signature) {
+		t.Error("signature verifies with another timestamp")
+	}
+	if Verify("secret", 1600000000, []byte(`{"id":2,"event":"sale.created"}`), signature) {
+		t.Error("signature verifies with another body")
+	}
+}
+
+func TestPostSignsDelivery(t *testing.T) {
+	r := newReceiver(http.StatusNoContent)
+	defer r.Close()
+
+	s := &Service{client: r.Client()}
+	item := &due{id: 7, event: "sale.created", body: []byte(`{"id":3}`), url: r.URL, secret: "secret"}
+	code, err := s.post(context.Background(), item)
+	if err != nil || code != http.StatusNoContent {
+		t.Fatalf("post = %d, %v", code, err)
+	}
+	requests, bodies := r.received()
+	if len(requests) != 1 {
+		t.Fatalf("receiver got %d requests", len(requests))
+	}
+
+	header := requests[0].Header
+	if got := header.Get(HeaderEvent); got != item.event {
+		t.Errorf("%s = %q", HeaderEvent, got)
+	}
+	if got := header.Get(HeaderDelivery); got != "7" {
+		t.Errorf("%s = %q", HeaderDelivery, got)
+	}
+	if got := header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q", got)
+	}
+	timestamp, err := strconv.ParseInt(header.Get(HeaderTimestamp), 10, 64)
+	if err != nil {
+		t.Fatalf("%s: %v", HeaderTimestamp, err)
+	}
+	if !Verify(item.secret, timestamp, bodies[0], header.Get(HeaderSignature)) {
+		t.Errorf("%s does not verify", HeaderSignature)
+	}
+	if string(bodies[0]) != string(item.body) {
+		t.Errorf("body = %s", bodies[0])
+	}
+}
+
+func TestPostServerError(t *testing.T) {
+	r := newReceiver(http.StatusBadGateway)
+	defer r.Close()
+
+	s := &Service{client: r.Client()}
+	code, err := s.post(context.Background(), &due{id: 1, event: "sale.created", body: []byte(`{}`), url: r.URL, secret: "secret"})
+	if err == nil || code != http.StatusBadGateway {
+		t.Fatalf("post = %d, %v", code, err)
+	}
+
+	if status, delay := outcome(1, err); status != Pending || delay != Backoff(1) {
+		t.Errorf("after a %d: %s in %v, want %s in %v", code, status, delay, Pending, Backoff(1))
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	if Backoff(1) != baseDelay {
+		t.Errorf("Backoff(1) = %v", Backoff(1))
+	}
+	for attempts := 2; attempts <= 20; attempts++ {
+		prev, delay := Backoff(attempts-1), Backoff(attempts)
+		if delay != 2*prev && delay != maxDelay {
+			t.Errorf("Backoff(%d) = %v after %v", attempts, delay, prev)
+		}
+		if delay > maxDelay {
+			t.Errorf("Backoff(%d) = %v over %v", attempts, delay, maxDelay)
+		}
+	}
+}
+
+func TestOutcome(t *testing.T) {
+	failure := errors.New("webhook responded with 500 Internal Server Error")
+
+	if status, delay := outcome(3, nil); status != Succeeded || delay != 0 {
+		t.Errorf("success: %s in %v", status, delay)
+	}
+	for attempts := 1; attempts < MaxAttempts; attempts++ {
+		if status, delay := outcome(attempts, failure); status != Pending || delay != Backoff(attempts) {
+			t.Errorf("failure %d: %s in %v, want %s in %v", attempts, status, delay, Pending, Backoff(attempts))
+		}
+	}
+	if status, _ := outcome(MaxAttempts, failure); status != Failed {
+		t.Errorf("failure %d: %s, want %s", MaxAttempts, status, Failed)
+	}
+}
@@ -0,0 +1,303 @@
+package webhooks
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+
+	"github.com/ehsontjk/crud/pkg/logger"
+	"github.com/ehsontjk/crud/pkg/outbox"
+)
+
+var (
+	ErrNotFound     = errors.New("item not found")
+	ErrInternal     = errors.New("internal error")
+	ErrInvalidURL   = errors.New("invalid webhook url")
+	ErrInvalidEvent = errors.New("invalid webhook event")
+)
+
+// AllEvents subscribes to every outbox event type.
+const AllEvents = "*"
+
+var knownEvents = map[string]bool{AllEvents: true}
+
+func init() {
+	for _, event := range outbox.Types {
+		knownEvents[event] = true
+	}
+}
+
+type Status string
+
+const (
+	Pending   Status = "pending"
+	Succeeded Status = "succeeded"
+	Failed    Status = "failed"
+)
+
+// Subscription sends the listed events to URL. The secret signs every
+// delivery and is only shown when the subscription is saved.
+type Subscription struct {
+	ID      int64     `json:"id"`
+	URL     string    `json:"url"`
+	Events  []string  `json:"events"`
+	Secret  string    `json:"secret,omitempty"`
+	Active  bool      `json:"active"`
+	Created time.Time `json:"created"`
+}
+
+// Delivery is one event sent to one subscription, with every attempt made.
+type Delivery struct {
+	ID             int64      `json:"id"`
+	SubscriptionID int64      `json:"subscription_id"`
+	Event          string     `json:"event"`
+	Status         Status     `json:"status"`
+	Attempts       int        `json:"attempts"`
+	NextAttempt    time.Time  `json:"next_attempt"`
+	ResponseCode   int        `json:"response_code"`
+	Created        time.Time  `json:"created"`
+	Delivered      *time.Time `json:"delivered,omitempty"`
+	Log            []*Attempt `json:"log"`
+}
+
+// Attempt is a single HTTP request of a delivery. ResponseCode is 0 when
+// the receiver could not be reached.
+type Attempt struct {
+	ResponseCode int       `json:"response_code"`
+	Error        string    `json:"error"`
+	DurationMS   int       `json:"duration_ms"`
+	Created      time.Time `json:"created"`
+}
+
+// Envelope is the JSON body of every delivery. ID is the outbox event id,
+// the same in every delivery of the event.
+type Envelope struct {
+	ID      int64           `json:"id"`
+	Event   string          `json:"event"`
+	Created time.Time       `json:"created"`
+	Data    json.RawMessage `json:"data"`
+}
+
+type Service struct {
+	db     *pgxpool.Pool
+	log    *logger.Logger
+	client *http.Client
+	wake   chan struct{}
+}
+
+func NewService(db *pgxpool.Pool, log *logger.Logger) *Service {
+	return &Service{
+		db:     db,
+		log:    log,
+		client: &http.Client{Timeout: DefaultTimeout},
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+func validURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func newSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Save creates or updates a subscription. A new subscription without a
+// secret gets a random one; an update without a secret keeps the old one.
+func (s *Service) Save(ctx context.Context, item *Subscription) (*Subscription, error) {
+
+	if !validURL(item.URL) {
+		return nil, ErrInvalidURL
+	}
+	if len(item.Events) == 0 {
+		return nil, ErrInvalidEvent
+	}
+	for _, event := range item.Events {
+		if !knownEvents[event] {
+			return nil, ErrInvalidEvent
+		}
+	}
+
+	var err error
+	if item.ID == 0 && item.Secret == "" {
+		if item.Secret, err = newSecret(); err != nil {
+			s.log.Error(ctx, "generate webhook secret", "err", err)
+			return nil, ErrInternal
+		}
+	}
+
+	saved := &Subscription{}
+	if item.ID == 0 {
+		sqlstmt := `insert into webhook_subscriptions(url, events, secret, active) values ($1, $2, $3, $4)
+		returning id, url, events, secret, active, created`
+		err = s.db.QueryRow(ctx, sqlstmt, item.URL, item.Events, item.Secret, item.Active).
+			Scan(&saved.ID, &saved.URL, &saved.Events, &saved.Secret, &saved.Active, &saved.Created)
+	} else {
+		sqlstmt := `update webhook_subscriptions set url = $1, events = $2, secret = coalesce(nullif($3, ''), secret), active = $4
+		where id = $5 returning id, url, events, secret, active, created`
+		err = s.db.QueryRow(ctx, sqlstmt, item.URL, item.Events, item.Secret, item.Active, item.ID).
+			Scan(&saved.ID, &saved.URL, &saved.Events, &saved.Secret, &saved.Active, &saved.Created)
+	}
+	if err == pgx.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		s.log.Error(ctx, "save webhook subscription", "err", err)
+		return nil, ErrInternal
+	}
+	return saved, nil
+}
+
+// Remove deletes the subscription together with its delivery log.
+func (s *Service) Remove(ctx context.Context, id int64) error {
+
+	tag, err := s.db.Exec(ctx, `delete from webhook_subscriptions where id = $1`, id)
+	if err != nil {
+		s.log.Error(ctx, "remove webhook subscription", "err", err)
+		return ErrInternal
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *Service) Subscriptions(ctx context.Context) ([]*Subscription, error) {
+
+	items := make([]*Subscription, 0)
+	rows, err := s.db.Query(ctx, `select id, url, events, active, created from webhook_subscriptions order by id`)
+	if err != nil {
+		s.log.Error(ctx, "get webhook subscriptions", "err", err)
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		item := &Subscription{}
+		if err = rows.Scan(&item.ID, &item.URL, &item.Events, &item.Active, &item.Created); err != nil {
+			s.log.Error(ctx, "scan webhook subscription", "err", err)
+			return nil, ErrInternal
+		}
+		items = append(items, item)
+	}
+	if err = rows.Err(); err != nil {
+		s.log.Error(ctx, "get webhook subscriptions", "err", err)
+		return nil, ErrInternal
+	}
+	return items, nil
+}
+
+// Handle queues an outbox event for every active subscription that wants
+// it. It runs as an outbox consumer, so every event is queued exactly once.
+// Deliveries are made in the background by Run; the dispatcher pokes it
+// once the queued deliveries are committed.
+func (s *Service) Handle(ctx context.Context, tx pgx.Tx, e *outbox.Event) error {
+
+	body, err := json.Marshal(&Envelope{ID: e.ID, Event: e.Type, Created: e.Created, Data: e.Payload})
+	if err != nil {
+		s.log.Error(ctx, "encode webhook body", "event", e.Type, "err", err)
+		return ErrInternal
+	}
+
+	sqlstmt := `insert into webhook_deliveries(subscription_id, event, body)
+	select id, $1, $2 from webhook_subscriptions where active and ($1 = any(events) or '*' = any(events))`
+	if _, err = tx.Exec(ctx, sqlstmt, e.Type, string(body)); err != nil {
+		s.log.Error(ctx, "queue webhook deliveries", "event", e.Type, "err", err)
+		return ErrInternal
+	}
+	return nil
+}
+
+// Redeliver queues the delivery again right away with a fresh retry budget.
+func (s *Service) Redeliver(ctx context.Context, deliveryID int64) error {
+
+	sqlstmt := `update webhook_deliveries set status = 'pending', attempts = 0, next_attempt = current_timestamp
+	where id = $1`
+	tag, err := s.db.Exec(ctx, sqlstmt, deliveryID)
+	if err != nil {
+		s.log.Error(ctx, "redeliver webhook", "err", err)
+		return ErrInternal
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	s.Poke()
+	return nil
+}
+
+// Deliveries returns the latest deliveries of the subscription, newest
+// first, each with its attempts.
+func (s *Service) Deliveries(ctx context.Context, subscriptionID int64) ([]*Delivery, error) {
+
+	items := make([]*Delivery, 0)
+	byID := make(map[int64]*Delivery)
+
+	sqlstmt := `select id, subscription_id, event, status, attempts, next_attempt, response_code, created, delivered
+	from webhook_deliveries where subscription_id = $1 order by id desc limit 100`
+	rows, err := s.db.Query(ctx, sqlstmt, subscriptionID)
+	if err != nil {
+		s.log.Error(ctx, "get webhook deliveries", "err", err)
+		return nil, ErrInternal
+	}
+	for rows.Next() {
+		item := &Delivery{Log: make([]*Attempt, 0)}
+		err = rows.Scan(&item.ID, &item.SubscriptionID, &item.Event, &item.Status, &item.Attempts, &item.NextAttempt,
+			&item.ResponseCode, &item.Created, &item.Delivered)
+		if err != nil {
+			rows.Close()
+			s.log.Error(ctx, "scan webhook delivery", "err", err)
+			return nil, ErrInternal
+		}
+		items = append(items, item)
+		byID[item.ID] = item
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		s.log.Error(ctx, "get webhook deliveries", "err", err)
+		return nil, ErrInternal
+	}
+	if len(items) == 0 {
+		return items, nil
+	}
+
+	ids := make([]int64, 0, len(items))
+	for _, item := range items {
+		ids = append(ids, item.ID)
+	}
+	sqlstmt = `select delivery_id, response_code, error, duration_ms, created from webhook_attempts
+	where delivery_id = any($1) order by id`
+	rows, err = s.db.Query(ctx, sqlstmt, ids)
+	if err != nil {
+		s.log.Error(ctx, "get webhook attempts", "err", err)
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var deliveryID int64
+		attempt := &Attempt{}
+		if err = rows.Scan(&deliveryID, &attempt.ResponseCode, &attempt.Error, &attempt.DurationMS, &attempt.Created); err != nil {
+			s.log.Error(ctx, "scan webhook attempt", "err", err)
+			return nil, ErrInternal
+		}
+		byID[deliveryID].Log = append(byID[deliveryID].Log, attempt)
+	}
+	if err = rows.Err(); err != nil {
+		s.log.Error(ctx, "get webhook attempts", "err", err)
+		return nil, ErrInternal
+	}
+
+	return items, nil
+}
@@ -0,0 +1,149 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+
+	"github.com/ehsontjk/crud/pkg/logger"
+	"github.com/ehsontjk/crud/pkg/migrations"
+	"github.com/ehsontjk/crud/pkg/outbox"
+)
+
+// testDB connects to TEST_DATABASE_URL and brings its schema up to date.
+// It must be a database the tests may write to.
+func testDB(t *testing.T) *pgxpool.Pool {
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	ctx := context.Background()
+	db, err := pgxpool.Connect(ctx, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(db.Close)
+
+	log := logger.NewWithWriter(ioutil.Discard, logger.ERROR)
+	if _, err = migrations.NewService(db, log).Apply(ctx, filepath.Join("..", "..", migrations.DefaultDir)); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestDeliveryRetriesFailsAndRedelivers(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+
+	r := newReceiver(http.StatusInternalServerError)
+	defer r.Close()
+
+	s := NewService(db, logger.NewWithWriter(ioutil.Discard, logger.ERROR))
+	s.client = r.Client()
+
+	sub, err := s.Save(ctx, &Subscription{URL: r.URL, Events: []string{outbox.SaleCreated}, Active: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Remove(ctx, sub.ID)
+
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := &outbox.Event{ID: 1, Type: outbox.SaleCreated, AggregateID: 1, Payload: json.RawMessage(`{"id":1}`), Created: time.Now()}
+	if err = s.Handle(ctx, tx, event); err != nil {
+		t.Fatal(err)
+	}
+	if err = tx.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	delivery := func() *Delivery {
+		items, err := s.Deliveries(ctx, sub.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(items) != 1 {
+			t.Fatalf("%d deliveries, want 1", len(items))
+		}
+		return items[0]
+	}
+	deliverDue := func() {
+		if _, err := s.DeliverDue(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// sent counts the requests the receiver got for the delivery
+	sent := func(id int64) int {
+		requests, _ := r.received()
+		n := 0
+		for _, req := range requests {
+			if req.Header.Get(HeaderDelivery) == strconv.FormatInt(id, 10) {
+				n++
+			}
+		}
+		return n
+	}
+	makeDue := func(id int64, attempts int) {
+		sqlstmt := `update webhook_deliveries set next_attempt = current_timestamp, attempts = $1 where id = $2`
+		if _, err := db.Exec(ctx, sqlstmt, attempts, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a 5xx keeps the delivery pending and backs off
+	deliverDue()
+	d := delivery()
+	if d.Status != Pending || d.Attempts != 1 || d.ResponseCode != http.StatusInternalServerError || len(d.Log) != 1 {
+		t.Fatalf("after a 5xx: %s, %d attempts, code %d, %d logged", d.Status, d.Attempts, d.ResponseCode, len(d.Log))
+	}
+	deliverDue()
+	if n := sent(d.ID); n != 1 {
+		t.Fatalf("sent %d times before the backoff ran out", n)
+	}
+	makeDue(d.ID, d.Attempts)
+	deliverDue()
+	if n := sent(d.ID); n != 2 {
+		t.Fatalf("sent %d times after the backoff ran out, want 2", n)
+	}
+
+	// the last attempt gives up
+	makeDue(d.ID, MaxAttempts-1)
+	deliverDue()
+	d = delivery()
+	if d.Status != Failed || d.Attempts != MaxAttempts {
+		t.Fatalf("after %d attempts: %s, %d attempts", MaxAttempts, d.Status, d.Attempts)
+	}
+	deliverDue()
+	if n := sent(d.ID); n != 3 {
+		t.Fatalf("sent %d times after it failed, want 3", n)
+	}
+
+	// redelivering starts over and the receiver is back
+	if err = s.Redeliver(ctx, d.ID); err != nil {
+		t.Fatal(err)
+	}
+	d = delivery()
+	if d.Status != Pending || d.Attempts != 0 {
+		t.Fatalf("after redelivery: %s, %d attempts", d.Status, d.Attempts)
+	}
+	r.respond(http.StatusOK)
+	deliverDue()
+	d = delivery()
+	if d.Status != Succeeded || d.Attempts != 1 || d.ResponseCode != http.StatusOK || d.Delivered == nil {
+		t.Fatalf("after redelivery: %s, %d attempts, code %d, delivered %v", d.Status, d.Attempts, d.ResponseCode, d.Delivered)
+	}
+
+	if err = s.Redeliver(ctx, -1); err != ErrNotFound {
+		t.Errorf("Redeliver of a missing delivery = %v, want %v", err, ErrNotFound)
+	}
+}
@@ -0,0 +1,233 @@
+package xlsx
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrNoSheet     = errors.New("xlsx: workbook has no sheets")
+	ErrInvalidCell = errors.New("xlsx: invalid cell reference")
+)
+
+// ReadRows returns the values of the first sheet of the workbook as text.
+// Shared strings, inline strings, numbers and booleans are supported; styles
+// and formulas are ignored, formula cells yield their cached value.
+func ReadRows(r io.ReaderAt, size int64) ([][]string, error) {
+	z, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string]*zip.File, len(z.File))
+	for _, f := range z.File {
+		files[f.Name] = f
+	}
+
+	sheetPath, err := firstSheetPath(files)
+	if err != nil {
+		return nil, err
+	}
+
+	shared, err := sharedStrings(files["xl/sharedStrings.xml"])
+	if err != nil {
+		return nil, err
+	}
+
+	sheet, ok := files[sheetPath]
+	if !ok {
+		return nil, ErrNoSheet
+	}
+	return readSheet(sheet, shared)
+}
+
+func decodeFile(f *zip.File, v interface{}) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return xml.NewDecoder(rc).Decode(v)
+}
+
+func firstSheetPath(files map[string]*zip.File) (string, error) {
+	workbookFile, ok := files["xl/workbook.xml"]
+	if !ok {
+		return "", ErrNoSheet
+	}
+	var workbook struct {
+		Sheets []struct {
+			RID string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	if err := decodeFile(workbookFile, &workbook); err != nil {
+		return "", err
+	}
+	if len(workbook.Sheets) == 0 {
+		return "", ErrNoSheet
+	}
+
+	relsFile, ok := files["xl/_rels/workbook.xml.rels"]
+	if !ok {
+		return "xl/worksheets/sheet1.xml", nil
+	}
+	var rels struct {
+		Relationships []struct {
+			ID     string `xml:"Id,attr"`
+			Target string `xml:"Target,attr"`
+		} `xml:"Relationship"`
+	}
+	if err := decodeFile(relsFile, &rels); err != nil {
+		return "", err
+	}
+	for _, rel := range rels.Relationships {
+		if rel.ID != workbook.Sheets[0].RID {
+			continue
+		}
+		if strings.HasPrefix(rel.Target, "/") {
+			return strings.TrimPrefix(rel.Target, "/"), nil
+		}
+		return path.Join("xl", rel.Target), nil
+	}
+	return "", ErrNoSheet
+}
+
+type richText struct {
+	Text string `xml:"t"`
+	Runs []struct {
+		Text string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (t richText) String() string {
+	if len(t.Runs) == 0 {
+		return t.Text
+	}
+	var b strings.Builder
+	for _, run := range t.Runs {
+		b.WriteString(run.Text)
+	}
+	return b.String()
+}
+
+func sharedStrings(f *zip.File) ([]string, error) {
+	if f == nil {
+		return nil, nil
+	}
+	var sst struct {
+		Items []richText `xml:"si"`
+	}
+	if err := decodeFile(f, &sst); err != nil {
+		return nil, err
+	}
+	values := make([]string, len(sst.Items))
+	for i, item := range sst.Items {
+		values[i] = item.String()
+	}
+	return values, nil
+}
+
+type cell struct {
+	Ref    string   `xml:"r,attr"`
+	Type   string   `xml:"t,attr"`
+	Value  string   `xml:"v"`
+	Inline richText `xml:"is"`
+}
+
+func readSheet(f *zip.File, shared []string) ([][]string, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	rows := make([][]string, 0)
+	decoder := xml.NewDecoder(rc)
+	var current []string
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "row":
+				rowNumber := len(rows) + 1
+				for _, attr := range t.Attr {
+					if attr.Name.Local == "r" {
+						if n, err := strconv.Atoi(attr.Value); err == nil && n > rowNumber {
+							rowNumber = n
+						}
+					}
+				}
+				// keep row numbers aligned with the sheet when empty rows are skipped
+				for len(rows) < rowNumber-1 {
+					rows = append(rows, nil)
+				}
+				current = make([]string, 0)
+			case "c":
+				var c cell
+				if err = decoder.DecodeElement(&c, &t); err != nil {
+					return nil, err
+				}
+				col := len(current)
+				if c.Ref != "" {
+					if col, err = columnIndex(c.Ref); err != nil {
+						return nil, err
+					}
+				}
+				for len(current) <= col {
+					current = append(current, "")
+				}
+				current[col] = c.text(shared)
+			}
+		case xml.EndElement:
+			if t.Name.Local == "row" {
+				rows = append(rows, current)
+				current = nil
+			}
+		}
+	}
+	return rows, nil
+}
+
+func (c cell) text(shared []string) string {
+	switch c.Type {
+	case "s":
+		i, err := strconv.Atoi(strings.TrimSpace(c.Value))
+		if err != nil || i < 0 || i >= len(shared) {
+			return ""
+		}
+		return shared[i]
+	case "inlineStr":
+		return c.Inline.String()
+	case "b":
+		if strings.TrimSpace(c.Value) == "1" {
+			return "true"
+		}
+		return "false"
+	}
+	return c.Value
+}
+
+func columnIndex(ref string) (int, error) {
+	col := 0
+	i := 0
+	for ; i < len(ref) && ref[i] >= 'A' && ref[i] <= 'Z'; i++ {
+		col = col*26 + int(ref[i]-'A'+1)
+	}
+	if i == 0 {
+		return 0, ErrInvalidCell
+	}
+	return col - 1, nil
+}
@@ -0,0 +1,187 @@
+// Package xlsx reads and writes the subset of Office Open XML spreadsheets
+// needed for data exchange: a single sheet of plain values without styling.
+package xlsx
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrClosed      = errors.New("xlsx: writer is closed")
+	ErrTooManyRows = errors.New("xlsx: sheet row limit exceeded")
+)
+
+// MaxRows is the number of rows a sheet can hold.
+const MaxRows = 1048576
+
+const contentTypesXML = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/xml"/>
+<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>
+<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>
+</Types>`
+
+const rootRelsXML = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>
+</Relationships>`
+
+const workbookRelsXML = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>
+</Relationships>`
+
+const workbookXML = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<sheets><sheet name="%s" sheetId="1" r:id="rId1"/></sheets>
+</workbook>`
+
+const sheetHeader = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`
+
+const sheetFooter = `</sheetData></worksheet>`
+
+// Writer streams rows into a single sheet workbook, so that large exports
+// never have to be held in memory.
+type Writer struct {
+	zip    *zip.Writer
+	sheet  *bufio.Writer
+	row    int
+	closed bool
+}
+
+func NewWriter(w io.Writer, sheetName string) (*Writer, error) {
+	z := zip.NewWriter(w)
+
+	var escaped bytesWriter
+	if err := xml.EscapeText(&escaped, []byte(sheetName)); err != nil {
+		return nil, err
+	}
+
+	parts := []struct {
+		name string
+		body string
+	}{
+		{"[Content_Types].xml", contentTypesXML},
+		{"_rels/.rels", rootRelsXML},
+		{"xl/workbook.xml", fmt.Sprintf(workbookXML, string(escaped))},
+		{"xl/_rels/workbook.xml.rels", workbookRelsXML},
+	}
+	for _, part := range parts {
+		f, err := z.Create(part.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = io.WriteString(f, part.body); err != nil {
+			return nil, err
+		}
+	}
+
+	f, err := z.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	sheet := bufio.NewWriter(f)
+	if _, err = sheet.WriteString(sheetHeader); err != nil {
+		return nil, err
+	}
+
+	return &Writer{zip: z, sheet: sheet}, nil
+}
+
+type bytesWriter []byte
+
+func (b *bytesWriter) Write(p []byte) (int, error) {
+	*b = append(*b, p...)
+	return len(p), nil
+}
+
+// WriteRow appends a row. Numbers and booleans become typed cells, times are
+// written as RFC 3339 text and everything else as inline strings.
+func (w *Writer) WriteRow(values ...interface{}) error {
+	if w.closed {
+		return ErrClosed
+	}
+	if w.row == MaxRows {
+		return ErrTooManyRows
+	}
+	w.row++
+
+	if _, err := fmt.Fprintf(w.sheet, `<row r="%d">`, w.row); err != nil {
+		return err
+	}
+	for i, value := range values {
+		ref := CellName(i, w.row)
+		var err error
+		switch v := value.(type) {
+		case nil:
+			continue
+		case int:
+			_, err = fmt.Fprintf(w.sheet, `<c r="%s"><v>%d</v></c>`, ref, v)
+		case int64:
+			_, err = fmt.Fprintf(w.sheet, `<c r="%s"><v>%d</v></c>`, ref, v)
+		case float64:
+			_, err = fmt.Fprintf(w.sheet, `<c r="%s"><v>%s</v></c>`, ref, strconv.FormatFloat(v, 'f', -1, 64))
+		case bool:
+			b := 0
+			if v {
+				b = 1
+			}
+			_, err = fmt.Fprintf(w.sheet, `<c r="%s" t="b"><v>%d</v></c>`, ref, b)
+		case time.Time:
+			err = w.writeString(ref, v.Format(time.RFC3339))
+		case string:
+			err = w.writeString(ref, v)
+		default:
+			err = w.writeString(ref, fmt.Sprint(v))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	_, err := w.sheet.WriteString(`</row>`)
+	return err
+}
+
+func (w *Writer) writeString(ref, value string) error {
+	if _, err := fmt.Fprintf(w.sheet, `<c r="%s" t="inlineStr"><is><t xml:space="preserve">`, ref); err != nil {
+		return err
+	}
+	if err := xml.EscapeText(w.sheet, []byte(value)); err != nil {
+		return err
+	}
+	_, err := w.sheet.WriteString(`</t></is></c>`)
+	return err
+}
+
+func (w *Writer) Close() error {
+	if w.closed {
+		return ErrClosed
+	}
+	w.closed = true
+	if _, err := w.sheet.WriteString(sheetFooter); err != nil {
+		return err
+	}
+	if err := w.sheet.Flush(); err != nil {
+		return err
+	}
+	return w.zip.Close()
+}
+
+// CellName returns the A1 style reference of a zero based column and a one
+// based row.
+func CellName(col, row int) string {
+	name := ""
+	for col++; col > 0; col = (col - 1) / 26 {
+		name = string(rune('A'+(col-1)%26)) + name
+	}
+	return name + strconv.Itoa(row)
+}